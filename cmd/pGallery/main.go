@@ -5,8 +5,14 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/Magnetkopf/pGallery/internal/cli"
+	"github.com/Magnetkopf/pGallery/internal/config"
+	"github.com/Magnetkopf/pGallery/internal/dupes"
+	"github.com/Magnetkopf/pGallery/internal/layout"
+	"github.com/Magnetkopf/pGallery/internal/pixiv"
+	"github.com/Magnetkopf/pGallery/utils"
 )
 
 func main() {
@@ -18,76 +24,260 @@ func main() {
 	switch os.Args[1] {
 	case "sync":
 		syncCmd := flag.NewFlagSet("sync", flag.ExitOnError)
-		flagCookieFile := syncCmd.String("cookie", "cookie.txt", "where is your cookie.txt")
-		flagUser := syncCmd.String("user", "", "bookmarks' owner id to sync")
-		flagBase := syncCmd.String("base", "downloads", "base directory to save artworks")
-		flagDownloader := syncCmd.String("downloader", "", "downloader to use (aria2c / built-in)")
+		flagProfile := syncCmd.String("profile", "", "profile name from pgallery.yaml")
+		syncCmd.String("cookie", config.Default.Cookie, "cookie file, env:NAME or - for stdin")
+		syncCmd.String("user", "", "bookmarks' owner id to sync")
+		syncCmd.String("base", config.Default.Base, "base directory to save artworks")
+		syncCmd.String("downloader", "", "downloader to use (aria2c / built-in)")
+		syncCmd.Int("concurrency", config.Default.Concurrency, "number of parallel downloads")
+		syncCmd.String("layout", config.Default.Layout.Artwork, "directory template for new artworks")
+		syncCmd.String("artist-layout", config.Default.Layout.Artist, "directory template for new artists")
+		flagOutput := syncCmd.String("output", "text", "progress output (text / json)")
+		flagRecheckDead := syncCmd.Bool("recheck-dead", false, "retry artworks recorded as deleted or private")
 
 		syncCmd.Parse(os.Args[2:])
+		profile := resolveProfile(syncCmd, *flagProfile)
 
-		if *flagUser == "" {
-			fmt.Println("Error: -user is required")
-			syncCmd.PrintDefaults()
+		if *flagOutput != string(utils.OutputText) && *flagOutput != string(utils.OutputJSON) {
+			fmt.Printf("Error: unknown -output %q\n", *flagOutput)
 			os.Exit(1)
 		}
 
-		cookieBytes, err := os.ReadFile(*flagCookieFile)
-		if err != nil {
-			fmt.Printf("Error reading cookie file: %v\n", err)
+		checkLayout(profile.Layout)
+		syncAccounts := loadAccounts(syncCmd, profile)
+
+		cli.Sync(cli.SyncArgs{
+			Accounts:    syncAccounts,
+			Base:        profile.Base,
+			Downloader:  profile.Downloader,
+			Concurrency: profile.Concurrency,
+			Filters:     profile.Filters,
+			Layout:      profile.Layout,
+			Output:      utils.OutputMode(*flagOutput),
+			RecheckDead: *flagRecheckDead,
+		})
+
+	case "serve":
+		serveCmd := flag.NewFlagSet("serve", flag.ExitOnError)
+		flagProfile := serveCmd.String("profile", "", "profile name from pgallery.yaml")
+		serveCmd.String("cookie", config.Default.Cookie, "cookie file, env:NAME or - for stdin")
+		serveCmd.String("user", "", "bookmarks' owner id to sync")
+		serveCmd.String("base", config.Default.Base, "base directory")
+		serveCmd.String("downloader", "", "downloader to use (aria2c / built-in)")
+		serveCmd.Int("concurrency", config.Default.Concurrency, "number of parallel downloads")
+		serveCmd.String("layout", config.Default.Layout.Artwork, "directory template for new artworks")
+		serveCmd.String("artist-layout", config.Default.Layout.Artist, "directory template for new artists")
+		serveCmd.Int("port", config.Default.Port, "port to listen on")
+		serveCmd.String("interval", config.Default.Interval, "time between scheduled syncs")
+		serveCmd.String("index", "", "index backend to build, json or bolt (default: the one built last)")
+
+		serveCmd.Parse(os.Args[2:])
+		profile := resolveProfile(serveCmd, *flagProfile)
+
+		interval, err := time.ParseDuration(profile.Interval)
+		if err != nil || interval <= 0 {
+			fmt.Printf("Error: invalid -interval %q\n", profile.Interval)
 			os.Exit(1)
 		}
+		checkLayout(profile.Layout)
 
-		cli.Sync(cli.SyncArgs{
-			UserID:     *flagUser,
-			Cookie:     strings.TrimSpace(string(cookieBytes)),
-			Base:       *flagBase,
-			Downloader: *flagDownloader,
+		cli.Serve(cli.ServeArgs{
+			Sync: cli.SyncArgs{
+				Accounts:    loadAccounts(serveCmd, profile),
+				Base:        profile.Base,
+				Downloader:  profile.Downloader,
+				Concurrency: profile.Concurrency,
+				Filters:     profile.Filters,
+				Layout:      profile.Layout,
+				Output:      utils.OutputText,
+			},
+			Port:     profile.Port,
+			Interval: interval,
+			Index:    profile.Index,
 		})
 
 	case "build":
 		buildCmd := flag.NewFlagSet("build", flag.ExitOnError)
-		flagBase := buildCmd.String("base", "downloads", "base directory to scan")
+		flagProfile := buildCmd.String("profile", "", "profile name from pgallery.yaml")
+		buildCmd.String("base", config.Default.Base, "base directory to scan")
+		flagFull := buildCmd.Bool("full", false, "read every artwork again instead of reusing the build cache")
+		flagWorkers := buildCmd.Int("workers", 0, "artworks read at once (0 = one per CPU)")
+		buildCmd.String("index", "", "index backend to build, json or bolt (default: the one built last)")
 
 		buildCmd.Parse(os.Args[2:])
+		profile := resolveProfile(buildCmd, *flagProfile)
 
-		if *flagBase == "" {
+		if profile.Base == "" {
 			fmt.Println("Error: -base is required")
 			buildCmd.PrintDefaults()
 			os.Exit(1)
 		}
 
 		cli.Build(cli.BuildArgs{
-			Base: *flagBase,
+			Base:    profile.Base,
+			Full:    *flagFull,
+			Workers: *flagWorkers,
+			Index:   profile.Index,
 		})
 
 	case "webui":
 		webuiCmd := flag.NewFlagSet("webui", flag.ExitOnError)
-		flagBase := webuiCmd.String("base", "downloads", "base directory")
-		flagPort := webuiCmd.Int("port", 8080, "port to listen on")
+		flagProfile := webuiCmd.String("profile", "", "profile name from pgallery.yaml")
+		webuiCmd.String("base", config.Default.Base, "base directory")
+		webuiCmd.Int("port", config.Default.Port, "port to listen on")
+		webuiCmd.String("index", "", "index backend to read, json or bolt (default: the newer one)")
 
 		webuiCmd.Parse(os.Args[2:])
+		profile := resolveProfile(webuiCmd, *flagProfile)
 
 		cli.WebUI(cli.WebUIArgs{
-			Base: *flagBase,
-			Port: *flagPort,
+			Base:  profile.Base,
+			Port:  profile.Port,
+			Index: profile.Index,
 		})
 
 	case "check":
 		checkCmd := flag.NewFlagSet("check", flag.ExitOnError)
-		flagBase := checkCmd.String("base", "downloads", "base directory containing artworks")
+		flagProfile := checkCmd.String("profile", "", "profile name from pgallery.yaml")
+		checkCmd.String("base", config.Default.Base, "base directory containing artworks")
 
 		checkCmd.Parse(os.Args[2:])
+		profile := resolveProfile(checkCmd, *flagProfile)
 
-		if *flagBase == "" {
+		if profile.Base == "" {
 			fmt.Println("Error: -base is required")
 			checkCmd.PrintDefaults()
 			os.Exit(1)
 		}
 
 		cli.Check(cli.CheckArgs{
-			Base: *flagBase,
+			Base: profile.Base,
+		})
+
+	case "relayout":
+		relayoutCmd := flag.NewFlagSet("relayout", flag.ExitOnError)
+		flagProfile := relayoutCmd.String("profile", "", "profile name from pgallery.yaml")
+		relayoutCmd.String("base", config.Default.Base, "base directory containing artworks")
+		flagTo := relayoutCmd.String("to", "", "new directory template for artworks (default: the profile's layout)")
+		flagArtistTo := relayoutCmd.String("artist-to", "", "new directory template for artists (default: the profile's layout)")
+		flagDryRun := relayoutCmd.Bool("dry-run", false, "only print the planned moves")
+		flagResume := relayoutCmd.Bool("resume", false, "finish an interrupted relayout")
+		flagRollback := relayoutCmd.Bool("rollback", false, "undo an interrupted relayout")
+
+		relayoutCmd.Parse(os.Args[2:])
+		profile := resolveProfile(relayoutCmd, *flagProfile)
+
+		target := profile.Layout.Merge(layout.Layout{Artwork: *flagTo, Artist: *flagArtistTo})
+		checkLayout(target)
+
+		cli.Relayout(cli.RelayoutArgs{
+			Base:     profile.Base,
+			Layout:   target,
+			DryRun:   *flagDryRun,
+			Resume:   *flagResume,
+			Rollback: *flagRollback,
+		})
+
+	case "thumbs":
+		thumbsCmd := flag.NewFlagSet("thumbs", flag.ExitOnError)
+		flagProfile := thumbsCmd.String("profile", "", "profile name from pgallery.yaml")
+		thumbsCmd.String("base", config.Default.Base, "base directory containing artworks")
+		flagForce := thumbsCmd.Bool("force", false, "render existing thumbnails again")
+		flagFolders := thumbsCmd.Bool("folders", false, "replace full-size folder.* images with a downscaled folder.jpg")
+
+		thumbsCmd.Parse(os.Args[2:])
+		profile := resolveProfile(thumbsCmd, *flagProfile)
+
+		cli.Thumbs(cli.ThumbsArgs{
+			Base:    profile.Base,
+			Force:   *flagForce,
+			Folders: *flagFolders,
+		})
+
+	case "dupes":
+		dupesCmd := flag.NewFlagSet("dupes", flag.ExitOnError)
+		flagProfile := dupesCmd.String("profile", "", "profile name from pgallery.yaml")
+		dupesCmd.String("base", config.Default.Base, "base directory containing artworks")
+		flagThreshold := dupesCmd.Int("threshold", dupes.DefaultThreshold, "largest hash distance (0-64) that counts as a duplicate")
+
+		dupesCmd.Parse(os.Args[2:])
+		profile := resolveProfile(dupesCmd, *flagProfile)
+
+		cli.Dupes(cli.DupesArgs{
+			Base:      profile.Base,
+			Threshold: *flagThreshold,
+		})
+
+	case "find-image":
+		findCmd := flag.NewFlagSet("find-image", flag.ExitOnError)
+		flagProfile := findCmd.String("profile", "", "profile name from pgallery.yaml")
+		findCmd.String("base", config.Default.Base, "base directory containing artworks")
+		flagDistance := findCmd.Int("distance", dupes.DefaultSearchDistance, "largest hash distance (0-64) to report")
+		flagLimit := findCmd.Int("limit", 10, "number of pages to list")
+		findCmd.Usage = func() {
+			fmt.Fprintln(findCmd.Output(), "Usage: pGallery find-image [flags] <file>")
+			findCmd.PrintDefaults()
+		}
+
+		findCmd.Parse(os.Args[2:])
+		if findCmd.NArg() != 1 {
+			findCmd.Usage()
+			os.Exit(1)
+		}
+		profile := resolveProfile(findCmd, *flagProfile)
+
+		cli.FindImage(cli.FindImageArgs{
+			Base:        profile.Base,
+			File:        findCmd.Arg(0),
+			MaxDistance: *flagDistance,
+			Limit:       *flagLimit,
+		})
+
+	case "search":
+		searchCmd := flag.NewFlagSet("search", flag.ExitOnError)
+		flagProfile := searchCmd.String("profile", "", "profile name from pgallery.yaml")
+		searchCmd.String("base", config.Default.Base, "base directory containing artworks")
+		flagLimit := searchCmd.Int("limit", 0, "number of artworks to list (0 = all)")
+		searchCmd.Usage = func() {
+			fmt.Fprintln(searchCmd.Output(), "Usage: pGallery search [flags] <query>")
+			fmt.Fprintln(searchCmd.Output(), "e.g. pGallery search 'tag:a (tag:b OR tag:c) -tag:d pages>5 date>=2023-01'")
+			searchCmd.PrintDefaults()
+		}
+
+		searchCmd.Parse(os.Args[2:])
+		if searchCmd.NArg() == 0 {
+			searchCmd.Usage()
+			os.Exit(1)
+		}
+		profile := resolveProfile(searchCmd, *flagProfile)
+
+		cli.Search(cli.SearchArgs{
+			Base:  profile.Base,
+			Query: strings.Join(searchCmd.Args(), " "),
+			Limit: *flagLimit,
 		})
 
+	case "config":
+		if len(os.Args) < 3 || os.Args[2] != "show" {
+			fmt.Println("Usage: pGallery config show [-profile <name>] [flags]")
+			os.Exit(1)
+		}
+
+		configCmd := flag.NewFlagSet("config show", flag.ExitOnError)
+		flagProfile := configCmd.String("profile", "", "profile name from pgallery.yaml")
+		configCmd.String("cookie", config.Default.Cookie, "cookie file")
+		configCmd.String("user", "", "bookmarks' owner id")
+		configCmd.String("base", config.Default.Base, "base directory")
+		configCmd.String("downloader", "", "downloader to use (aria2c / built-in)")
+		configCmd.Int("concurrency", config.Default.Concurrency, "number of parallel downloads")
+		configCmd.Int("port", config.Default.Port, "web UI port")
+		configCmd.String("interval", config.Default.Interval, "time between scheduled syncs")
+
+		configCmd.Parse(os.Args[3:])
+		profile := resolveProfile(configCmd, *flagProfile)
+
+		cli.ConfigShow(profile)
+
 	default:
 		fmt.Printf("Unknown command: %s\n", os.Args[1])
 		printUsage()
@@ -95,6 +285,79 @@ func main() {
 	}
 }
 
+// resolveProfile loads the selected profile and applies the flags that were
+// given explicitly on the command line on top of it.
+func resolveProfile(fs *flag.FlagSet, name string) config.Resolved {
+	var overrides config.Profile
+	var setErr error
+	fs.Visit(func(f *flag.Flag) {
+		if err := overrides.Set(f.Name, f.Value.String()); err != nil && setErr == nil {
+			setErr = err
+		}
+	})
+	if setErr != nil {
+		fmt.Printf("Error: %v\n", setErr)
+		os.Exit(1)
+	}
+
+	profile, err := config.Load(name, overrides.Base)
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	profile.Profile = profile.Profile.Merge(overrides)
+	return profile
+}
+
+// loadAccounts reads the cookie of every account to sync, exiting when there is none
+func loadAccounts(fs *flag.FlagSet, profile config.Resolved) []cli.Account {
+	// an explicit -user syncs just that account
+	if flagGiven(fs, "user") {
+		profile.Accounts = nil
+	}
+
+	accounts := profile.AllAccounts()
+	if len(accounts) == 0 {
+		fmt.Println("Error: -user is required")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var syncAccounts []cli.Account
+	for _, account := range accounts {
+		cookie, err := pixiv.LoadCookie(account.Cookie)
+		if err != nil {
+			fmt.Printf("Error reading cookie for %s: %v\n", account.UserID, err)
+			os.Exit(1)
+		}
+		syncAccounts = append(syncAccounts, cli.Account{
+			UserID:       account.UserID,
+			Cookie:       cookie,
+			CookieSource: account.Cookie,
+		})
+	}
+	return syncAccounts
+}
+
+func flagGiven(fs *flag.FlagSet, name string) bool {
+	given := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
+}
+
+// checkLayout exits when a layout template can't be used
+func checkLayout(l layout.Layout) {
+	if err := l.Validate(); err != nil {
+		fmt.Printf("Error: invalid layout: %v\n", err)
+		os.Exit(1)
+	}
+}
+
 func printUsage() {
 	fmt.Print(`pGallery
 
@@ -102,10 +365,17 @@ Usage:
   pGallery <command> [arguments]
 
 Commands:
-  sync      Sync bookmarks for a user
-  check     Verify downloaded artworks and repair downloaded.json
-  build     Index the database
-  webui     Start web UI
+  sync        Sync bookmarks for a user
+  check       Verify downloaded artworks and repair downloaded.json
+  relayout    Move the library into a new directory layout
+  dupes       Find near-duplicate images across artworks
+  find-image  Find the library pages that look like an image
+  search      List the artworks matching a query
+  thumbs      Make missing thumbnails
+  build       Index the database
+  webui       Start web UI
+  serve       Start web UI and sync + build on a schedule
+  config      Show the merged configuration (config show)
 
 Use "pGallery <command> -help" for more information.
 `)
@@ -4,10 +4,12 @@ go 1.25.4
 
 require (
 	github.com/tidwall/gjson v1.18.0
+	go.etcd.io/bbolt v1.4.3
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
+	golang.org/x/sys v0.29.0 // indirect
 )
@@ -1,128 +1,308 @@
 package cli
 
 import (
-	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
-	"strings"
+	"strconv"
 	"time"
 
+	"github.com/Magnetkopf/pGallery/internal/dupes"
+	"github.com/Magnetkopf/pGallery/internal/index"
+	"github.com/Magnetkopf/pGallery/internal/layout"
 	"github.com/Magnetkopf/pGallery/internal/model"
+	"github.com/Magnetkopf/pGallery/internal/search"
+	"github.com/Magnetkopf/pGallery/internal/thumbs"
+	"github.com/Magnetkopf/pGallery/utils"
+	"github.com/Magnetkopf/pGallery/web"
 	"gopkg.in/yaml.v3"
 )
 
 type BuildArgs struct {
 	Base string
+	// Full reads every artwork again instead of reusing the build cache
+	Full bool
+	// Workers bounds how many artworks are read at once, 0 for one per CPU
+	Workers int
+	// Index is the backend to write, see index.Backends; empty keeps the
+	// one the base already has
+	Index string
+}
+
+type buildOptions struct {
+	Full    bool
+	Workers int
+	// Index is the backend to write; empty keeps the one of the last build
+	Index string
 }
 
 func Build(args BuildArgs) {
+	// build rewrites the indexes and caches, which a second build or thumbs
+	// would race on
+	baseLock, err := utils.LockBase(args.Base, utils.LockExclusive, "build")
+	if err != nil {
+		log.Fatalf("Cannot build: %v", err)
+	}
+	defer baseLock.Release()
+
+	if _, err := buildIndex(args.Base, buildOptions{Full: args.Full, Workers: args.Workers, Index: args.Index}); err != nil {
+		log.Fatalf("Build failed: %v", err)
+	}
+}
+
+// buildIndex scans base, writes the index and returns it. Unless
+// opts.Full is set, artworks that didn't change since the last build are
+// taken from the build cache. Artworks are read in parallel but merged in ID
+// order, so the index doesn't depend on scheduling. The caller must hold the
+// base lock.
+func buildIndex(base string, opts buildOptions) (*model.Store, error) {
 	log.Println("Building index...")
 
 	store := model.Store{
-		ArtworkIndex: make(map[string]*model.ArtworkCard),
-		TagIndex:     make(map[string][]*model.ArtworkCard),
-		ArtistIndex:  make(map[string]*model.ArtistDetail),
+		ArtworkIndex:  make(map[string]*model.ArtworkCard),
+		TagIndex:      make(map[string][]*model.ArtworkCard),
+		ArtistIndex:   make(map[string]*model.ArtistDetail),
+		BookmarkIndex: make(map[string][]*model.ArtworkCard),
+		LostIndex:     make(map[string]*model.LostArtwork),
 	}
 
-	artistEntries, err := os.ReadDir(args.Base)
+	cache := loadBuildCache(base, opts.Full)
+	library, scanCache, err := layout.ScanCached(base, cache.Scan, opts.Workers)
 	if err != nil {
-		log.Fatalf("Failed to read base directory: %v", err)
+		return nil, fmt.Errorf("failed to read base directory: %w", err)
+	}
+	next := &buildCache{
+		Version:  buildCacheVersion,
+		Scan:     scanCache,
+		Artworks: make(map[int]*artworkEntry),
+		Artists:  make(map[int]*artistEntry),
 	}
 
-	for _, artistEntry := range artistEntries {
-		if !artistEntry.IsDir() {
-			continue
+	pageInfo := loadPageInfoCache(base)
+	palettes := loadPaletteCache(base)
+	ids := sortedKeys(library.Artworks)
+	entries := make([]*artworkEntry, len(ids)) // nil when unreadable
+	reusedEntry := make([]bool, len(ids))
+	utils.Parallel(len(ids), opts.Workers, func(i int) {
+		id := ids[i]
+		if entry, ok := cache.fresh(base, id, library.Artworks[id]); ok {
+			entries[i], reusedEntry[i] = entry, true
+			return
 		}
+		entries[i], _ = readArtwork(base, id, library.Artworks[id], pageInfo, palettes)
+	})
 
-		artistID := artistEntry.Name()
-		artistPath := filepath.Join(args.Base, artistEntry.Name())
-
-		artistYamlPath := filepath.Join(artistPath, "artist.yaml")
-		if _, err := os.Stat(artistYamlPath); err != nil {
-			log.Printf("Warning: No artist.yaml found for %s", artistID)
-		}
+	artistIDs := sortedKeys(library.Artists)
+	artists := make([]*artistEntry, len(artistIDs))
+	utils.Parallel(len(artistIDs), opts.Workers, func(i int) {
+		artists[i] = cache.artist(base, artistIDs[i], library.Artists[artistIDs[i]])
+	})
+	for i, id := range artistIDs {
+		next.Artists[id] = artists[i]
+	}
 
-		artworkEntries, err := os.ReadDir(artistPath)
-		if err != nil {
-			log.Printf("Failed to read artist directory %s: %v", artistPath, err)
+	var pages []dupes.Page
+	docs := make(map[string]search.Document)
+	reused := 0
+	for i, id := range ids {
+		entry := entries[i]
+		if entry == nil {
 			continue
 		}
+		if reusedEntry[i] {
+			// keep their entries in the side caches, which drop what isn't used
+			pageInfo.keep(entry.Files)
+			palettes.keep(id)
+			reused++
+		}
+		next.Artworks[id] = entry
 
-		for _, artworkEntry := range artworkEntries {
-			if !artworkEntry.IsDir() {
-				continue
-			}
+		for _, file := range entry.Files {
+			n, _ := layout.PageNumber(path.Base(file))
+			pages = append(pages, dupes.Page{Artwork: id, Page: n, Path: file})
+		}
 
-			artworkID := artworkEntry.Name()
-			artworkPath := filepath.Join(artistPath, artworkEntry.Name())
-			artworkYamlPath := filepath.Join(artworkPath, "artwork.yaml")
+		card := &entry.Card
+		artistID := card.ArtistID
+		store.ArtworkIndex[card.ID] = card
+		docs[card.ID] = entry.Text
 
-			artworkDataBytes, err := os.ReadFile(artworkYamlPath)
-			if err != nil {
-				log.Printf("Warning: Failed to read artwork.yaml for %s: %v", artworkID, err)
-				continue
+		if _, ok := store.ArtistIndex[artistID]; !ok {
+			artistName := entry.ArtistName
+			artistNumber, _ := strconv.Atoi(artistID)
+			artistDir, ok := library.Artists[artistNumber]
+			if !ok {
+				log.Printf("Warning: No artist.yaml found for %s", artistID)
+			} else if artist := next.Artists[artistNumber]; artist.Name != "" {
+				artistName = artist.Name
 			}
-
-			var artworkData model.ArtworkData
-			if err := yaml.Unmarshal(artworkDataBytes, &artworkData); err != nil {
-				log.Printf("Error unmarshaling artwork.yaml for %s: %v", artworkID, err)
-				continue
+			if artistName == "" {
+				artistName = artistID // Default key
 			}
 
-			var thumbnailPath string
-			files, _ := os.ReadDir(artworkPath)
-			for _, file := range files {
-				if strings.HasPrefix(file.Name(), "p0.") { //use folder. is also okay
-					thumbnailPath = filepath.Join(artistEntry.Name(), artworkEntry.Name(), file.Name())
-					break
-				}
+			store.ArtistIndex[artistID] = &model.ArtistDetail{
+				Name:     artistName,
+				Path:     filepath.ToSlash(artistDir),
+				Artworks: []*model.ArtworkCard{},
 			}
+		}
+		store.ArtistIndex[artistID].Artworks = append(store.ArtistIndex[artistID].Artworks, card)
 
-			card := &model.ArtworkCard{
-				ID:        artworkID,
-				ArtistID:  artistID,
-				Title:     artworkData.Title,
-				PageCount: artworkData.PageCount,
-				Thumbnail: thumbnailPath,
-			}
+		for _, tag := range entry.Tags {
+			store.TagIndex[tag] = append(store.TagIndex[tag], card)
+		}
 
-			store.ArtworkIndex[card.ID] = card
-
-			if _, ok := store.ArtistIndex[artistID]; !ok {
-				artistName := artistID // Default key
-				artistYamlBytes, err := os.ReadFile(artistYamlPath)
-				if err == nil {
-					var artistData model.ArtistData
-					if err := yaml.Unmarshal(artistYamlBytes, &artistData); err == nil && artistData.Name != "" {
-						artistName = artistData.Name
-					}
-				}
-
-				store.ArtistIndex[artistID] = &model.ArtistDetail{
-					Name:     artistName,
-					Artworks: []*model.ArtworkCard{},
-				}
-			}
-			store.ArtistIndex[artistID].Artworks = append(store.ArtistIndex[artistID].Artworks, card)
+		for _, userID := range card.BookmarkedBy {
+			store.BookmarkIndex[userID] = append(store.BookmarkIndex[userID], card)
+		}
+	}
 
-			for _, tag := range artworkData.Tags {
-				store.TagIndex[tag.Tag] = append(store.TagIndex[tag.Tag], card)
-			}
+	if err := next.save(base); err != nil {
+		log.Printf("Warning: Failed to write %s: %v", buildCacheFile, err)
+	}
+
+	if err := pageInfo.save(base); err != nil {
+		log.Printf("Warning: Failed to write %s: %v", pageInfoFile, err)
+	} else if pageInfo.read > 0 {
+		log.Printf("Read the dimensions of %d new pages.", pageInfo.read)
+	}
+
+	if err := palettes.save(base); err != nil {
+		log.Printf("Warning: Failed to write %s: %v", paletteFile, err)
+	} else if palettes.computed > 0 {
+		log.Printf("Extracted the colours of %d artworks.", palettes.computed)
+	}
+
+	if err := search.Write(base, docs); err != nil {
+		log.Printf("Warning: Failed to write %s: %v", search.File, err)
+	}
+
+	// perceptual hashes for dupes, only new or changed pages are hashed
+	if hashed, err := dupes.UpdateHashes(base, pages, opts.Workers); err != nil {
+		log.Printf("Warning: Failed to update page hashes: %v", err)
+	} else if hashed > 0 {
+		log.Printf("Hashed %d new pages.", hashed)
+	}
+
+	// renders of pages that were downloaded again, moved or deleted
+	if pruned, err := web.PruneImages(base); err != nil {
+		log.Printf("Warning: Failed to prune the web UI's image cache: %v", err)
+	} else if pruned > 0 {
+		log.Printf("Removed %d outdated web UI images.", pruned)
+	}
+
+	tombstones, err := loadTombstones(base)
+	if err != nil {
+		log.Printf("Warning: Failed to read %s: %v", tombstonesFile, err)
+	}
+	for id, tombstone := range tombstones {
+		key := strconv.Itoa(id)
+		// downloaded before it disappeared, the local copy is what counts
+		if _, ok := store.ArtworkIndex[key]; ok {
+			continue
+		}
+		store.LostIndex[key] = &model.LostArtwork{
+			Tombstone: *tombstone,
+			Thumbnail: tombstoneThumbnail(base, id),
 		}
 	}
 
 	store.LastIndexed = time.Now()
 
-	indexPath := filepath.Join(args.Base, "index.json")
-	indexBytes, err := json.MarshalIndent(store, "", "  ")
+	backend := opts.Index
+	if backend == "" {
+		backend = index.Detect(base)
+	}
+	if err := index.Write(base, backend, &store); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Indexed %d artworks, %d reused from the build cache, %d read.", len(store.ArtworkIndex), reused, len(store.ArtworkIndex)-reused)
+	return &store, nil
+}
+
+// readArtwork reads an artwork's directory and artwork.yaml; ok is false when
+// the artwork can't be indexed
+func readArtwork(base string, id int, artworkDir string, pageInfo *pageInfoCache, palettes *paletteCache) (*artworkEntry, bool) {
+	artworkID := strconv.Itoa(id)
+	artworkPath := filepath.Join(base, artworkDir)
+	artworkYamlPath := filepath.Join(artworkPath, "artwork.yaml")
+
+	// stamped before reading, so a change while reading is seen next time
+	entry := &artworkEntry{
+		Dir:        artworkDir,
+		DirStamp:   stampOf(artworkPath),
+		YamlStamp:  stampOf(artworkYamlPath),
+		ThumbStamp: stampOf(filepath.Join(base, filepath.FromSlash(thumbs.Dir), artworkID)),
+	}
+
+	artworkDataBytes, err := os.ReadFile(artworkYamlPath)
 	if err != nil {
-		log.Fatalf("Failed to marshal index: %v", err)
+		log.Printf("Warning: Failed to read artwork.yaml for %s: %v", artworkID, err)
+		return nil, false
 	}
 
-	if err := os.WriteFile(indexPath, indexBytes, 0644); err != nil {
-		log.Fatalf("Failed to write index.json: %v", err)
+	var artworkData model.ArtworkData
+	if err := yaml.Unmarshal(artworkDataBytes, &artworkData); err != nil {
+		log.Printf("Error unmarshaling artwork.yaml for %s: %v", artworkID, err)
+		return nil, false
 	}
 
-	log.Printf("Indexed %d artworks.", len(store.ArtworkIndex))
+	var thumbnailPath string
+	var pageInfos []model.PageInfo
+	files, _ := os.ReadDir(artworkPath)
+	for _, file := range files {
+		n, ok := layout.PageNumber(file.Name())
+		if !ok || file.IsDir() {
+			continue
+		}
+		pagePath := filepath.ToSlash(filepath.Join(artworkDir, file.Name()))
+		if n == 0 { //use folder. is also okay
+			thumbnailPath = pagePath
+		}
+		entry.Files = append(entry.Files, pagePath)
+		entry.FileStamps = append(entry.FileStamps, stampOf(filepath.Join(base, filepath.FromSlash(pagePath))))
+
+		for len(pageInfos) <= n {
+			pageInfos = append(pageInfos, model.PageInfo{})
+		}
+		pageInfos[n] = pageInfo.info(base, pagePath)
+	}
+
+	thumbnails := thumbs.Find(base, id)
+	paletteSource := thumbnailPath
+	if small, ok := thumbnails["small"]; ok {
+		paletteSource = small
+	}
+	if grid, ok := thumbnails["grid"]; ok {
+		thumbnailPath = grid
+	}
+
+	entry.Card = model.ArtworkCard{
+		ID:            artworkID,
+		ArtistID:      strconv.Itoa(artworkData.ArtistId),
+		Title:         artworkData.Title,
+		PageCount:     artworkData.PageCount,
+		Thumbnail:     thumbnailPath,
+		Thumbnails:    thumbnails,
+		Pages:         pageInfos,
+		Colors:        palettes.palette(base, id, paletteSource),
+		Path:          filepath.ToSlash(artworkDir),
+		CreateDate:    artworkData.CreateDate,
+		Rating:        artworkData.Rating(),
+		AI:            artworkData.IsAI(),
+		BookmarkID:    artworkData.BookmarkID,
+		BookmarkCount: artworkData.BookmarkCount,
+		LikeCount:     artworkData.LikeCount,
+		ViewCount:     artworkData.ViewCount,
+		BookmarkedBy:  artworkData.BookmarkedBy,
+	}
+	entry.ArtistName = artworkData.ArtistName
+	entry.Text = search.NewDocument(artworkData)
+	for _, tag := range artworkData.Tags {
+		entry.Tags = append(entry.Tags, tag.Tag)
+	}
+	return entry, true
 }
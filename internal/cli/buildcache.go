@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Magnetkopf/pGallery/internal/layout"
+	"github.com/Magnetkopf/pGallery/internal/model"
+	"github.com/Magnetkopf/pGallery/internal/search"
+	"github.com/Magnetkopf/pGallery/internal/thumbs"
+)
+
+// buildCacheFile keeps what build read from every artwork, relative to base
+const buildCacheFile = ".cache/build.json"
+
+// buildCacheVersion changes whenever artworkEntry does; an older cache is
+// ignored and everything is read again
+const buildCacheVersion = 6
+
+type buildCache struct {
+	Version  int                   `json:"version"`
+	Scan     layout.ScanCache      `json:"scan"`
+	Artworks map[int]*artworkEntry `json:"artworks"`
+	Artists  map[int]*artistEntry  `json:"artists"`
+}
+
+// fileStamp tells whether a file or directory changed since it was read
+type fileStamp struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// stampOf returns the stamp of path, zero when it doesn't exist
+func stampOf(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	if info.IsDir() {
+		return fileStamp{ModTime: info.ModTime()}
+	}
+	return fileStamp{Size: info.Size(), ModTime: info.ModTime()}
+}
+
+func (s fileStamp) equal(other fileStamp) bool {
+	return s.Size == other.Size && s.ModTime.Equal(other.ModTime)
+}
+
+// artworkEntry is what build reads from one artwork directory. It is reused
+// as long as the directory, its artwork.yaml, its pages and its thumbnails
+// are unchanged. The directory's mtime covers pages being added or removed;
+// a page downloaded again is written in place, so each page is stamped too.
+type artworkEntry struct {
+	Dir        string            `json:"dir"`
+	DirStamp   fileStamp         `json:"dir_stamp"`
+	YamlStamp  fileStamp         `json:"yaml_stamp"`
+	ThumbStamp fileStamp         `json:"thumb_stamp"`
+	Card       model.ArtworkCard `json:"card"`
+	ArtistName string            `json:"artist_name"`
+	Tags       []string          `json:"tags,omitempty"`
+	Files      []string          `json:"files,omitempty"`       // pages, relative to base
+	FileStamps []fileStamp       `json:"file_stamps,omitempty"` // of Files, in the same order
+	Text       search.Document   `json:"text"`                  // for the full-text index
+}
+
+type artistEntry struct {
+	Dir   string    `json:"dir"`
+	Stamp fileStamp `json:"stamp"`
+	Name  string    `json:"name"`
+}
+
+// loadBuildCache reads the cache of the last build; full starts empty
+func loadBuildCache(base string, full bool) *buildCache {
+	cache := &buildCache{
+		Version:  buildCacheVersion,
+		Artworks: make(map[int]*artworkEntry),
+		Artists:  make(map[int]*artistEntry),
+	}
+	if full {
+		return cache
+	}
+	content, err := os.ReadFile(filepath.Join(base, buildCacheFile))
+	if err != nil {
+		return cache
+	}
+	var old buildCache
+	if err := json.Unmarshal(content, &old); err != nil {
+		log.Printf("Warning: Failed to parse %s, reading every artwork again: %v", buildCacheFile, err)
+		return cache
+	}
+	if old.Version != buildCacheVersion || old.Artworks == nil || old.Artists == nil {
+		return cache
+	}
+	return &old
+}
+
+// fresh returns the cached entry of an artwork if nothing it was read from
+// changed since
+func (c *buildCache) fresh(base string, id int, dir string) (*artworkEntry, bool) {
+	entry, ok := c.Artworks[id]
+	if !ok || entry.Dir != dir {
+		return nil, false
+	}
+	artworkPath := filepath.Join(base, dir)
+	if !entry.DirStamp.equal(stampOf(artworkPath)) ||
+		!entry.YamlStamp.equal(stampOf(filepath.Join(artworkPath, "artwork.yaml"))) ||
+		!entry.ThumbStamp.equal(stampOf(filepath.Join(base, filepath.FromSlash(thumbs.Dir), strconv.Itoa(id)))) ||
+		len(entry.FileStamps) != len(entry.Files) {
+		return nil, false
+	}
+	for i, file := range entry.Files {
+		if !entry.FileStamps[i].equal(stampOf(filepath.Join(base, filepath.FromSlash(file)))) {
+			return nil, false
+		}
+	}
+	return entry, true
+}
+
+// artist returns the cached entry of an artist, or reads its artist.yaml
+// again when the file changed
+func (c *buildCache) artist(base string, id int, dir string) *artistEntry {
+	yamlPath := filepath.Join(base, dir, "artist.yaml")
+	stamp := stampOf(yamlPath)
+	if entry, ok := c.Artists[id]; ok && entry.Dir == dir && entry.Stamp.equal(stamp) {
+		return entry
+	}
+
+	entry := &artistEntry{Dir: dir, Stamp: stamp}
+	var artistData model.ArtistData
+	if readYamlFile(yamlPath, &artistData) == nil {
+		entry.Name = artistData.Name
+	}
+	return entry
+}
+
+func (c *buildCache) save(base string) error {
+	path := filepath.Join(base, buildCacheFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	content, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -2,13 +2,13 @@ package cli
 
 import (
 	"encoding/json"
-	"fmt"
 	"log"
 	"os"
 	"path/filepath"
-	"strconv"
 
+	"github.com/Magnetkopf/pGallery/internal/layout"
 	"github.com/Magnetkopf/pGallery/internal/model"
+	"github.com/Magnetkopf/pGallery/utils"
 	"gopkg.in/yaml.v3"
 )
 
@@ -17,6 +17,12 @@ type CheckArgs struct {
 }
 
 func Check(args CheckArgs) {
+	baseLock, err := utils.LockBase(args.Base, utils.LockExclusive, "check")
+	if err != nil {
+		log.Fatalf("Cannot check: %v", err)
+	}
+	defer baseLock.Release()
+
 	downloadedRecordPath := filepath.Join(args.Base, "downloaded.json")
 
 	fileContent, err := os.ReadFile(downloadedRecordPath)
@@ -29,35 +35,45 @@ func Check(args CheckArgs) {
 		log.Fatalf("Failed to parse downloaded.json: %v", err)
 	}
 
+	library, err := layout.Scan(args.Base)
+	if err != nil {
+		log.Fatalf("Failed to scan %s: %v", args.Base, err)
+	}
+
 	log.Printf("Checking %d artworks...", len(artworkIDs))
 
 	var validIDs []int
 	removed := 0
 
+	// Folders with pages but no usable artwork.yaml, whether recorded or not
+	for _, dir := range library.Broken {
+		log.Printf("❌ %s: artwork.yaml missing or unreadable, removing", dir)
+		if err := os.RemoveAll(filepath.Join(args.Base, dir)); err != nil {
+			log.Printf("⚠️  Failed to remove %s: %v", dir, err)
+		}
+	}
+
 	for _, artworkID := range artworkIDs {
-		// Find the artwork folder: base/<artistID>/<artworkID>
-		matches, err := filepath.Glob(filepath.Join(args.Base, "*", strconv.Itoa(artworkID)))
-		if err != nil || len(matches) == 0 {
+		// Find the artwork folder by its artwork.yaml, wherever the layout put it
+		artworkDir, found := library.Artworks[artworkID]
+		if !found {
 			log.Printf("❌ Artwork %d: folder not found, removing from downloaded.json", artworkID)
 			removed++
 			continue
 		}
-		artworkPath := matches[0]
+		artworkPath := filepath.Join(args.Base, artworkDir)
 
 		// Read artwork.yaml for page count
+		// Read artwork.yaml for page count; the scan only found the folder by
+		// it, so this fails only when the file changed since
+		var artworkData model.ArtworkData
 		yamlBytes, err := os.ReadFile(filepath.Join(artworkPath, "artwork.yaml"))
-		if err != nil {
-			log.Printf("❌ Artwork %d: artwork.yaml missing (%v), removing", artworkID, err)
-			_ = os.RemoveAll(artworkPath)
-			removed++
-			continue
+		if err == nil {
+			err = yaml.Unmarshal(yamlBytes, &artworkData)
 		}
-
-		var artworkData model.ArtworkData
-		if err := yaml.Unmarshal(yamlBytes, &artworkData); err != nil {
-			log.Printf("❌ Artwork %d: artwork.yaml parse error (%v), removing", artworkID, err)
-			_ = os.RemoveAll(artworkPath)
-			removed++
+		if err != nil {
+			log.Printf("⚠️  Artwork %d: can't read artwork.yaml (%v), skipping", artworkID, err)
+			validIDs = append(validIDs, artworkID)
 			continue
 		}
 
@@ -73,7 +89,7 @@ func Check(args CheckArgs) {
 
 		// Check p0.*, p1.*, …, p{pageCount-1}.*
 		for i := 0; i < pageCount && ok; i++ {
-			pageMatches, _ := filepath.Glob(filepath.Join(artworkPath, fmt.Sprintf("p%d.*", i)))
+			pageMatches, _ := filepath.Glob(filepath.Join(artworkPath, layout.PageFile(i)+".*"))
 			if len(pageMatches) == 0 {
 				log.Printf("❌ Artwork %d: missing p%d.* (%d pages expected)", artworkID, i, pageCount)
 				ok = false
@@ -101,5 +117,5 @@ func Check(args CheckArgs) {
 		log.Fatalf("Failed to write updated downloaded.json: %v", err)
 	}
 
-	log.Printf("Check complete: %d OK, %d removed", len(validIDs), removed)
+	log.Printf("Check complete: %d OK, %d removed, %d broken folders deleted", len(validIDs), removed, len(library.Broken))
 }
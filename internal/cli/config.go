@@ -0,0 +1,27 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/Magnetkopf/pGallery/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+func ConfigShow(profile config.Resolved) {
+	source := profile.Source
+	if source == "" {
+		source = "(none, built-in defaults)"
+	}
+	name := profile.Name
+	if name == "" {
+		name = "(none)"
+	}
+
+	out, err := yaml.Marshal(profile.Profile)
+	if err != nil {
+		log.Fatalf("Failed to marshal config: %v", err)
+	}
+
+	fmt.Printf("# config file: %s\n# profile: %s\n%s", source, name, out)
+}
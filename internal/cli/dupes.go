@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Magnetkopf/pGallery/internal/dupes"
+	"github.com/Magnetkopf/pGallery/utils"
+)
+
+type DupesArgs struct {
+	Base      string
+	Threshold int
+}
+
+func Dupes(args DupesArgs) {
+	baseLock, err := utils.LockBase(args.Base, utils.LockShared, "dupes")
+	if err != nil {
+		log.Fatalf("Cannot look for duplicates: %v", err)
+	}
+	defer baseLock.Release()
+
+	if err := listDupes(args.Base, args.Threshold); err != nil {
+		log.Fatalf("Dupes failed: %v", err)
+	}
+}
+
+func listDupes(base string, threshold int) error {
+	hashes, err := dupes.LoadHashes(base)
+	if err != nil {
+		return err
+	}
+	if len(hashes) == 0 {
+		return fmt.Errorf("no page hashes in %s yet, run build first", dupes.IndexFile)
+	}
+	decisions, err := dupes.LoadDecisions(base)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", dupes.DecisionsFile, err)
+	}
+
+	clusters := dupes.FindClusters(hashes, threshold)
+	pending := 0
+	for i, cluster := range clusters {
+		state := "to review"
+		if dupes.Reviewed(decisions, cluster) {
+			state = "reviewed"
+		} else {
+			pending++
+		}
+		log.Printf("🔁 Cluster %d (distance ≤ %d, %s): artworks %s", i+1, cluster.Distance, state, strings.ReplaceAll(cluster.Key(), ",", ", "))
+		for _, page := range cluster.Pages {
+			log.Printf("    %d p%d  %s", page.Artwork, page.Page, page.Path)
+		}
+	}
+
+	log.Printf("Found %d cluster(s) of near-duplicates among %d pages, %d to review", len(clusters), len(hashes), pending)
+	if pending > 0 {
+		log.Printf("Pick the keepers on the web UI's Duplicates page")
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"os"
+
+	"github.com/Magnetkopf/pGallery/internal/dupes"
+	"github.com/Magnetkopf/pGallery/utils"
+)
+
+type FindImageArgs struct {
+	Base string
+	File string
+	// MaxDistance is the largest hash distance (0-64) still reported
+	MaxDistance int
+	Limit       int
+}
+
+// FindImage lists the library pages that look like the given image, e.g. a
+// cropped or recompressed copy found elsewhere
+func FindImage(args FindImageArgs) {
+	baseLock, err := utils.LockBase(args.Base, utils.LockShared, "find-image")
+	if err != nil {
+		log.Fatalf("Cannot search: %v", err)
+	}
+	defer baseLock.Release()
+
+	f, err := os.Open(args.File)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", args.File, err)
+	}
+	query, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Failed to decode %s: %v", args.File, err)
+	}
+
+	hashes, err := dupes.LoadHashes(args.Base)
+	if err != nil {
+		log.Fatalf("Search failed: %v", err)
+	}
+	if len(hashes) == 0 {
+		log.Fatalf("Search failed: %v", fmt.Errorf("no page hashes in %s yet, run build first", dupes.IndexFile))
+	}
+
+	matches := dupes.Search(hashes, query, args.MaxDistance, args.Limit)
+	if len(matches) == 0 {
+		log.Printf("No page within distance %d among %d pages", args.MaxDistance, len(hashes))
+		return
+	}
+	for _, match := range matches {
+		how := ""
+		if match.Cropped {
+			how = " (matches a crop)"
+		}
+		log.Printf("🔎 %5.1f%%  %d p%d  %s%s", match.Similarity*100, match.Artwork, match.Page, match.Path, how)
+	}
+}
@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"encoding/json"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Magnetkopf/pGallery/internal/model"
+)
+
+// pageInfoFile caches the pixel size of every page, relative to base
+const pageInfoFile = ".cache/pageinfo.json"
+
+type pageInfoEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Width   int       `json:"width"`
+	Height  int       `json:"height"`
+}
+
+// pageInfoCache reads image headers only for pages that are new or changed
+// since the last build. It is safe for concurrent use.
+type pageInfoCache struct {
+	old map[string]pageInfoEntry
+
+	mu   sync.Mutex
+	next []pageInfoEntry
+	read int // headers read in this build
+}
+
+func loadPageInfoCache(base string) *pageInfoCache {
+	cache := &pageInfoCache{old: make(map[string]pageInfoEntry)}
+	content, err := os.ReadFile(filepath.Join(base, pageInfoFile))
+	if err != nil {
+		return cache
+	}
+	var entries []pageInfoEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		log.Printf("Warning: Failed to parse %s, reading every page again: %v", pageInfoFile, err)
+		return cache
+	}
+	for _, entry := range entries {
+		cache.old[entry.Path] = entry
+	}
+	return cache
+}
+
+// info returns the dimensions of the page at pagePath, relative to base
+func (c *pageInfoCache) info(base, pagePath string) model.PageInfo {
+	fullPath := filepath.Join(base, filepath.FromSlash(pagePath))
+	stat, err := os.Stat(fullPath)
+	if err != nil {
+		return model.PageInfo{}
+	}
+
+	entry, ok := c.old[pagePath]
+	read := !ok || entry.Size != stat.Size() || !entry.ModTime.Equal(stat.ModTime())
+	if read {
+		entry = pageInfoEntry{Path: pagePath, Size: stat.Size(), ModTime: stat.ModTime()}
+		if f, err := os.Open(fullPath); err == nil {
+			if config, _, err := image.DecodeConfig(f); err == nil {
+				entry.Width, entry.Height = config.Width, config.Height
+			}
+			f.Close()
+		}
+	}
+	c.mu.Lock()
+	c.next = append(c.next, entry)
+	if read {
+		c.read++
+	}
+	c.mu.Unlock()
+
+	info := model.PageInfo{Width: entry.Width, Height: entry.Height, Size: entry.Size}
+	if entry.Height > 0 {
+		info.Aspect = float64(entry.Width) / float64(entry.Height)
+	}
+	return info
+}
+
+// keep carries the entries of unchanged pages over without looking at them
+func (c *pageInfoCache) keep(pagePaths []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, pagePath := range pagePaths {
+		if entry, ok := c.old[pagePath]; ok {
+			c.next = append(c.next, entry)
+		}
+	}
+}
+
+// save writes the entries used in this build, dropping pages that are gone
+func (c *pageInfoCache) save(base string) error {
+	path := filepath.Join(base, pageInfoFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	sort.Slice(c.next, func(i, j int) bool {
+		return c.next[i].Path < c.next[j].Path
+	})
+	content, err := json.MarshalIndent(c.next, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
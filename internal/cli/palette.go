@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Magnetkopf/pGallery/internal/colors"
+	"github.com/Magnetkopf/pGallery/internal/model"
+	"github.com/Magnetkopf/pGallery/internal/thumbs"
+)
+
+// paletteFile caches the dominant colours of every artwork, relative to base
+const paletteFile = ".cache/colors.json"
+
+// paletteSize is how many colours are kept per artwork
+const paletteSize = 5
+
+// paletteThumb is the size palettes are computed at
+var paletteThumb = thumbs.Size{Width: 128, Height: 128}
+
+type paletteEntry struct {
+	Artwork int            `json:"artwork"`
+	Source  string         `json:"source"` // image the colours were taken from
+	Size    int64          `json:"size"`
+	ModTime time.Time      `json:"mod_time"`
+	Colors  []model.Swatch `json:"colors"`
+}
+
+// paletteCache decodes an image only for artworks whose source is new or
+// changed since the last build. It is safe for concurrent use.
+type paletteCache struct {
+	old map[int]paletteEntry
+
+	mu       sync.Mutex
+	next     []paletteEntry
+	computed int // palettes computed in this build
+}
+
+func loadPaletteCache(base string) *paletteCache {
+	cache := &paletteCache{old: make(map[int]paletteEntry)}
+	content, err := os.ReadFile(filepath.Join(base, paletteFile))
+	if err != nil {
+		return cache
+	}
+	var entries []paletteEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		log.Printf("Warning: Failed to parse %s, computing every palette again: %v", paletteFile, err)
+		return cache
+	}
+	for _, entry := range entries {
+		cache.old[entry.Artwork] = entry
+	}
+	return cache
+}
+
+// palette returns the dominant colours of an artwork, taken from source, which
+// is relative to base. The small thumbnail is preferred as it decodes fastest.
+func (c *paletteCache) palette(base string, id int, source string) []model.Swatch {
+	if source == "" {
+		return nil
+	}
+	fullPath := filepath.Join(base, filepath.FromSlash(source))
+	stat, err := os.Stat(fullPath)
+	if err != nil {
+		return nil
+	}
+
+	entry, ok := c.old[id]
+	compute := !ok || entry.Source != source || entry.Size != stat.Size() || !entry.ModTime.Equal(stat.ModTime())
+	if compute {
+		entry = paletteEntry{Artwork: id, Source: source, Size: stat.Size(), ModTime: stat.ModTime()}
+		if img, err := thumbs.Decode(fullPath); err == nil {
+			entry.Colors = colors.Palette(thumbs.Render(img, paletteThumb), paletteSize)
+		} else {
+			log.Printf("Warning: Failed to read colours of %d from %s: %v", id, source, err)
+		}
+	}
+	c.mu.Lock()
+	c.next = append(c.next, entry)
+	if compute {
+		c.computed++
+	}
+	c.mu.Unlock()
+	return entry.Colors
+}
+
+// keep carries the entry of an unchanged artwork over
+func (c *paletteCache) keep(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.old[id]; ok {
+		c.next = append(c.next, entry)
+	}
+}
+
+// save writes the entries used in this build, dropping artworks that are gone
+func (c *paletteCache) save(base string) error {
+	path := filepath.Join(base, paletteFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	sort.Slice(c.next, func(i, j int) bool {
+		return c.next[i].Artwork < c.next[j].Artwork
+	})
+	content, err := json.MarshalIndent(c.next, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,408 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Magnetkopf/pGallery/internal/layout"
+	"github.com/Magnetkopf/pGallery/internal/model"
+	"github.com/Magnetkopf/pGallery/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// journal of an unfinished relayout, in base
+const relayoutJournalFile = ".relayout.json"
+
+type RelayoutArgs struct {
+	Base   string
+	Layout layout.Layout
+	DryRun bool
+	// Resume finishes an interrupted relayout, Rollback undoes it
+	Resume   bool
+	Rollback bool
+}
+
+// relayoutMove moves one artwork folder, or the files of one artist
+// (artist.yaml and folder.*), from one directory to another. Paths are relative to base.
+type relayoutMove struct {
+	Kind string `json:"kind"` // "artwork" or "artist"
+	ID   int    `json:"id"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	Done bool   `json:"done"`
+}
+
+type relayoutJournal struct {
+	Started time.Time       `json:"started"`
+	Layout  layout.Layout   `json:"layout"`
+	Moves   []*relayoutMove `json:"moves"`
+}
+
+func Relayout(args RelayoutArgs) {
+	baseLock, err := utils.LockBase(args.Base, utils.LockExclusive, "relayout")
+	if err != nil {
+		log.Fatalf("Cannot relayout: %v", err)
+	}
+	defer baseLock.Release()
+
+	journalPath := filepath.Join(args.Base, relayoutJournalFile)
+	journal, err := readRelayoutJournal(journalPath)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", relayoutJournalFile, err)
+	}
+
+	switch {
+	case journal != nil && args.Rollback:
+		log.Printf("Rolling back the relayout started %s...", journal.Started.Format("2006-01-02 15:04:05"))
+		err = rollbackRelayout(args.Base, journalPath, journal)
+	case journal != nil && args.Resume:
+		log.Printf("Resuming the relayout started %s...", journal.Started.Format("2006-01-02 15:04:05"))
+		err = runRelayout(args.Base, journalPath, journal)
+	case journal != nil:
+		log.Fatalf("An interrupted relayout was found (%s), run relayout with -resume or -rollback", relayoutJournalFile)
+	case args.Resume || args.Rollback:
+		log.Fatalf("Nothing to resume or roll back, no %s found", relayoutJournalFile)
+	default:
+		err = startRelayout(args, journalPath)
+	}
+	if err != nil {
+		log.Fatalf("Relayout failed: %v", err)
+	}
+}
+
+// startRelayout plans the moves for args.Layout and carries them out unless
+// it is a dry run or the plan has collisions
+func startRelayout(args RelayoutArgs, journalPath string) error {
+	dirs := layout.Default.Merge(args.Layout)
+	if err := dirs.Validate(); err != nil {
+		return err
+	}
+
+	moves, collisions, err := planRelayout(args.Base, dirs)
+	if err != nil {
+		return err
+	}
+
+	for _, move := range moves {
+		log.Printf("📦 %s %d: %s → %s", move.Kind, move.ID, move.From, move.To)
+	}
+	for _, collision := range collisions {
+		log.Printf("❌ %s", collision)
+	}
+	log.Printf("Plan: %d move(s), %d collision(s)", len(moves), len(collisions))
+
+	if len(collisions) > 0 {
+		return fmt.Errorf("%d collision(s), nothing was moved; adjust the layout and try again", len(collisions))
+	}
+	if args.DryRun || len(moves) == 0 {
+		return nil
+	}
+
+	journal := &relayoutJournal{
+		Started: time.Now(),
+		Layout:  dirs,
+		Moves:   moves,
+	}
+	return runRelayout(args.Base, journalPath, journal)
+}
+
+// planRelayout lists the moves that bring the library into dirs, and the
+// reasons it can't when targets collide
+func planRelayout(base string, dirs layout.Layout) ([]*relayoutMove, []string, error) {
+	library, err := layout.Scan(base)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to scan %s: %w", base, err)
+	}
+
+	var moves []*relayoutMove
+	var collisions []string
+	targets := make(map[string]string) // target -> what goes there
+
+	artworkIDs := sortedKeys(library.Artworks)
+	for _, id := range artworkIDs {
+		from := library.Artworks[id]
+		var artwork model.ArtworkData
+		if err := readYamlFile(filepath.Join(base, from, "artwork.yaml"), &artwork); err != nil {
+			collisions = append(collisions, fmt.Sprintf("artwork %d: %v", id, err))
+			continue
+		}
+
+		to := dirs.ArtworkDir(artwork)
+		what := fmt.Sprintf("artwork %d", id)
+		if other, ok := targets[to]; ok {
+			collisions = append(collisions, fmt.Sprintf("%s and %s both go to %s", other, what, to))
+			continue
+		}
+		targets[to] = what
+		if to == from {
+			continue
+		}
+		moves = append(moves, &relayoutMove{Kind: "artwork", ID: id, From: from, To: to})
+	}
+
+	// a target must not exist yet, nor lie inside an artwork folder; parent
+	// folders of artworks (like artist folders) are fine to reuse
+	for _, move := range moves {
+		if _, err := os.Stat(filepath.Join(base, move.To)); err == nil {
+			collisions = append(collisions, fmt.Sprintf("artwork %d: %s already exists", move.ID, move.To))
+			continue
+		}
+		for _, id := range artworkIDs {
+			if inside(move.To, library.Artworks[id]) {
+				collisions = append(collisions, fmt.Sprintf("artwork %d: %s would be inside artwork %d", move.ID, move.To, id))
+				break
+			}
+		}
+	}
+
+	artistTargets := make(map[string]int)
+	for _, id := range sortedKeys(library.Artists) {
+		from := library.Artists[id]
+		var artist model.ArtistData
+		if err := readYamlFile(filepath.Join(base, from, "artist.yaml"), &artist); err != nil {
+			collisions = append(collisions, fmt.Sprintf("artist %d: %v", id, err))
+			continue
+		}
+
+		to := dirs.ArtistDir(artist)
+		if other, ok := artistTargets[to]; ok {
+			collisions = append(collisions, fmt.Sprintf("artists %d and %d both go to %s", other, id, to))
+			continue
+		}
+		artistTargets[to] = id
+		if to == from {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(base, to, "artist.yaml")); err == nil {
+			collisions = append(collisions, fmt.Sprintf("artist %d: %s already has an artist.yaml", id, to))
+			continue
+		}
+		if what, ok := targets[to]; ok {
+			collisions = append(collisions, fmt.Sprintf("artist %d: %s is the folder of %s", id, to, what))
+			continue
+		}
+		moves = append(moves, &relayoutMove{Kind: "artist", ID: id, From: from, To: to})
+	}
+
+	return moves, collisions, nil
+}
+
+// runRelayout carries out the moves that are not done yet, recording each in
+// the journal, then removes emptied folders and rebuilds the index
+func runRelayout(base, journalPath string, journal *relayoutJournal) error {
+	if err := writeRelayoutJournal(journalPath, journal); err != nil {
+		return fmt.Errorf("failed to write %s: %w", relayoutJournalFile, err)
+	}
+
+	for i, move := range journal.Moves {
+		if move.Done {
+			continue
+		}
+		if err := relayoutStep(base, move.From, move.To, move.Kind); err != nil {
+			return fmt.Errorf("%s %d: %w (run relayout -resume or -rollback)", move.Kind, move.ID, err)
+		}
+		move.Done = true
+		if err := writeRelayoutJournal(journalPath, journal); err != nil {
+			return fmt.Errorf("failed to write %s: %w", relayoutJournalFile, err)
+		}
+		log.Printf("✅ [%d/%d] %s %d → %s", i+1, len(journal.Moves), move.Kind, move.ID, move.To)
+	}
+
+	for _, move := range journal.Moves {
+		removeEmptyParents(base, move.From)
+	}
+	if _, err := buildIndex(base, buildOptions{}); err != nil {
+		return err
+	}
+	if err := os.Remove(journalPath); err != nil {
+		return err
+	}
+
+	log.Printf("Relayout complete: %d move(s). Set this layout in pgallery.yaml so sync keeps using it:", len(journal.Moves))
+	log.Printf("  layout: {artist: %q, artwork: %q}", journal.Layout.Artist, journal.Layout.Artwork)
+	return nil
+}
+
+// rollbackRelayout moves everything that was done back, newest first
+func rollbackRelayout(base, journalPath string, journal *relayoutJournal) error {
+	undone := 0
+	for i := len(journal.Moves) - 1; i >= 0; i-- {
+		move := journal.Moves[i]
+		// the last move may have happened, or begun, without making it into
+		// the journal
+		if !move.Done && !relayoutStepStarted(base, move.To, move.Kind) {
+			continue
+		}
+		if err := relayoutStep(base, move.To, move.From, move.Kind); err != nil {
+			return fmt.Errorf("%s %d: %w", move.Kind, move.ID, err)
+		}
+		move.Done = false
+		if err := writeRelayoutJournal(journalPath, journal); err != nil {
+			return fmt.Errorf("failed to write %s: %w", relayoutJournalFile, err)
+		}
+		undone++
+		log.Printf("↩️  %s %d → %s", move.Kind, move.ID, move.From)
+	}
+
+	for _, move := range journal.Moves {
+		removeEmptyParents(base, move.To)
+	}
+	if _, err := buildIndex(base, buildOptions{}); err != nil {
+		return err
+	}
+	if err := os.Remove(journalPath); err != nil {
+		return err
+	}
+	log.Printf("Rollback complete: %d move(s) undone", undone)
+	return nil
+}
+
+// relayoutStep moves an artwork folder, or an artist's files, from one
+// directory to another. A step that already happened is not an error, and
+// one that was cut off moves what is left, so interrupted runs can be
+// resumed or rolled back.
+func relayoutStep(base, from, to, kind string) error {
+	if relayoutStepDone(base, from, to, kind) {
+		return nil
+	}
+
+	fromPath := filepath.Join(base, from)
+	toPath := filepath.Join(base, to)
+
+	if kind == "artwork" {
+		if err := os.MkdirAll(filepath.Dir(toPath), 0755); err != nil {
+			return err
+		}
+		return os.Rename(fromPath, toPath)
+	}
+
+	if err := os.MkdirAll(toPath, 0755); err != nil {
+		return err
+	}
+	files, err := artistFiles(fromPath)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		if err := os.Rename(filepath.Join(fromPath, name), filepath.Join(toPath, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relayoutStepDone reports whether from has already been moved to to. An
+// artist's artist.yaml moves last, so it marks the files as moved, as long as
+// none are left behind.
+func relayoutStepDone(base, from, to, kind string) bool {
+	if kind == "artist" {
+		left, _ := artistFiles(filepath.Join(base, from))
+		_, err := os.Stat(filepath.Join(base, to, "artist.yaml"))
+		return len(left) == 0 && err == nil
+	}
+	_, fromErr := os.Stat(filepath.Join(base, from))
+	_, toErr := os.Stat(filepath.Join(base, to))
+	return os.IsNotExist(fromErr) && toErr == nil
+}
+
+// relayoutStepStarted reports whether anything was moved to to yet
+func relayoutStepStarted(base, to, kind string) bool {
+	if kind == "artwork" {
+		_, err := os.Stat(filepath.Join(base, to))
+		return err == nil
+	}
+	files, _ := artistFiles(filepath.Join(base, to))
+	return len(files) > 0
+}
+
+// artistFiles lists the files that belong to the artist in dir, which may
+// also hold artwork folders, with artist.yaml last: it marks the artist as
+// moved, so it must not arrive before the rest
+func artistFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(entry.Name(), "folder.") {
+			files = append(files, entry.Name())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "artist.yaml")); err == nil {
+		files = append(files, "artist.yaml")
+	}
+	return files, nil
+}
+
+// removeEmptyParents removes dir and its parents up to base while they are
+// empty; dir itself may be gone already, moved away
+func removeEmptyParents(base, dir string) {
+	for dir != "." && dir != "" {
+		if err := os.Remove(filepath.Join(base, dir)); err != nil && !os.IsNotExist(err) {
+			return // not empty
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// inside reports whether path is dir or lies below it
+func inside(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+func sortedKeys(m map[int]string) []int {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+func readYamlFile(path string, out interface{}) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(content, out)
+}
+
+func readRelayoutJournal(path string) (*relayoutJournal, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var journal relayoutJournal
+	if err := json.Unmarshal(content, &journal); err != nil {
+		return nil, err
+	}
+	return &journal, nil
+}
+
+// writeRelayoutJournal replaces the journal atomically, a crash leaves
+// either the old or the new version
+func writeRelayoutJournal(path string, journal *relayoutJournal) error {
+	content, err := json.MarshalIndent(journal, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
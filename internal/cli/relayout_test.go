@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Magnetkopf/pGallery/internal/layout"
+)
+
+func writeFiles(t *testing.T, base string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(base, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func exists(base, name string) bool {
+	_, err := os.Stat(filepath.Join(base, filepath.FromSlash(name)))
+	return err == nil
+}
+
+func TestArtistFiles(t *testing.T) {
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{
+		"artist.yaml":    "id: 10\n",
+		"folder.jpg":     "",
+		"folder.png":     "",
+		"notes.txt":      "",
+		"123/folder.jpg": "",
+	})
+	files, err := artistFiles(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// artist.yaml last, it marks the step as done
+	want := []string{"folder.jpg", "folder.png", "artist.yaml"}
+	if !slices.Equal(files, want) {
+		t.Errorf("artistFiles = %q, want %q", files, want)
+	}
+}
+
+// relayoutLibrary is artist 10 with artwork 123 in the default layout, and
+// the layout it is moved to
+var (
+	relayoutLibrary = map[string]string{
+		"10/artist.yaml":      "id: 10\nname: Alice\n",
+		"10/folder.jpg":       "",
+		"10/123/artwork.yaml": "id: 123\nartist_id: 10\npages: 1\n",
+		"10/123/folder.jpg":   "",
+		"10/123/p0.jpg":       "",
+	}
+	relayoutTarget = layout.Layout{Artist: "artists/{artist_id}", Artwork: "works/{id}"}
+	relayoutBefore = []string{"10/artist.yaml", "10/folder.jpg", "10/123/artwork.yaml", "10/123/p0.jpg"}
+	relayoutAfter  = []string{"artists/10/artist.yaml", "artists/10/folder.jpg", "works/123/artwork.yaml", "works/123/p0.jpg"}
+)
+
+// relayoutCrashes are the states a relayout can be cut off in: the moves
+// that made it into the journal, and what was moved on disk after that
+var relayoutCrashes = []struct {
+	name    string
+	done    int // moves recorded as done, artwork first
+	renamed []string
+}{
+	{"before any move", 0, nil},
+	{"artwork moved, not recorded", 0, []string{"10/123 works/123"}},
+	{"artwork recorded", 1, nil},
+	{"artist half moved", 1, []string{"10/folder.jpg artists/10/folder.jpg"}},
+	// earlier versions moved artist.yaml first
+	{"artist.yaml moved alone", 1, []string{"10/artist.yaml artists/10/artist.yaml"}},
+	{"artist moved, not recorded", 1, []string{"10/folder.jpg artists/10/folder.jpg", "10/artist.yaml artists/10/artist.yaml"}},
+	{"all recorded", 2, nil},
+}
+
+// crashedRelayout sets up the library as a relayout left it when cut off
+func crashedRelayout(t *testing.T, done int, renamed []string) (string, string, *relayoutJournal) {
+	t.Helper()
+	base := t.TempDir()
+	writeFiles(t, base, relayoutLibrary)
+
+	moves, collisions, err := planRelayout(base, relayoutTarget)
+	if err != nil || len(collisions) > 0 {
+		t.Fatalf("planRelayout: %v %v", err, collisions)
+	}
+	if len(moves) != 2 || moves[0].Kind != "artwork" || moves[1].Kind != "artist" {
+		t.Fatalf("planRelayout = %+v, want artwork 123 then artist 10", moves)
+	}
+	for i, move := range moves[:done] {
+		if err := relayoutStep(base, move.From, move.To, move.Kind); err != nil {
+			t.Fatal(err)
+		}
+		moves[i].Done = true
+	}
+	for _, rename := range renamed {
+		from, to, _ := strings.Cut(rename, " ")
+		to = filepath.Join(base, filepath.FromSlash(to))
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(filepath.Join(base, filepath.FromSlash(from)), to); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	journal := &relayoutJournal{Layout: relayoutTarget, Moves: moves}
+	journalPath := filepath.Join(base, relayoutJournalFile)
+	if err := writeRelayoutJournal(journalPath, journal); err != nil {
+		t.Fatal(err)
+	}
+	return base, journalPath, journal
+}
+
+func TestRelayoutResume(t *testing.T) {
+	for _, tt := range relayoutCrashes {
+		base, journalPath, journal := crashedRelayout(t, tt.done, tt.renamed)
+		if err := runRelayout(base, journalPath, journal); err != nil {
+			t.Errorf("%s: resume: %v", tt.name, err)
+			continue
+		}
+		for _, name := range relayoutAfter {
+			if !exists(base, name) {
+				t.Errorf("%s: %s missing after resume", tt.name, name)
+			}
+		}
+		// the old artist folder is emptied, folder.jpg included, and removed
+		for _, name := range []string{"10", relayoutJournalFile} {
+			if exists(base, name) {
+				t.Errorf("%s: %s left after resume", tt.name, name)
+			}
+		}
+	}
+}
+
+func TestRelayoutRollback(t *testing.T) {
+	for _, tt := range relayoutCrashes {
+		base, journalPath, journal := crashedRelayout(t, tt.done, tt.renamed)
+		if err := rollbackRelayout(base, journalPath, journal); err != nil {
+			t.Errorf("%s: rollback: %v", tt.name, err)
+			continue
+		}
+		for _, name := range relayoutBefore {
+			if !exists(base, name) {
+				t.Errorf("%s: %s missing after rollback", tt.name, name)
+			}
+		}
+		for _, name := range []string{"artists", "works", relayoutJournalFile} {
+			if exists(base, name) {
+				t.Errorf("%s: %s left after rollback", tt.name, name)
+			}
+		}
+	}
+}
+
+func TestPlanRelayoutCollisions(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		layout layout.Layout
+		want   int // collisions
+	}{
+		{"default is a no-op", nil, layout.Default, 0},
+		{"target exists", map[string]string{"works/123/p0.jpg": ""}, relayoutTarget, 1},
+		{"artist.yaml in the way", map[string]string{"artists/10/artist.yaml": "id: 11\n"}, relayoutTarget, 1},
+		{
+			"two artworks, one folder",
+			map[string]string{"10/12/artwork.yaml": "id: 12\nartist_id: 10\npages: 31\n"},
+			layout.Layout{Artist: "{artist_id}", Artwork: "{artist_id}/{id}{pages}"},
+			1, // 123 with 1 page and 12 with 31 pages
+		},
+	}
+	for _, tt := range tests {
+		base := t.TempDir()
+		writeFiles(t, base, relayoutLibrary)
+		writeFiles(t, base, tt.files)
+		_, collisions, err := planRelayout(base, tt.layout)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(collisions) != tt.want {
+			t.Errorf("%s: collisions = %q, want %d", tt.name, collisions, tt.want)
+		}
+	}
+}
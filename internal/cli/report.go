@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Magnetkopf/pGallery/internal/index"
+	"github.com/Magnetkopf/pGallery/internal/layout"
+	"github.com/Magnetkopf/pGallery/internal/model"
+	"github.com/Magnetkopf/pGallery/utils"
+)
+
+// SyncReport is what a sync run did, saved to <base>/reports/sync-<timestamp>.json
+type SyncReport struct {
+	Started         time.Time       `json:"started"`
+	Finished        time.Time       `json:"finished"`
+	DurationSeconds float64         `json:"duration_seconds"`
+	Accounts        []string        `json:"accounts"`
+	Total           int             `json:"total"`
+	NewArtworks     []int           `json:"new_artworks"`
+	Skipped         int             `json:"skipped"`
+	Failed          []FailedArtwork `json:"failed"`
+	Bytes           int64           `json:"bytes"`
+	NewArtists      []ReportArtist  `json:"new_artists"`
+	NewTags         []string        `json:"new_tags"`
+	Error           string          `json:"error,omitempty"`
+
+	knownTags map[string]bool
+}
+
+type FailedArtwork struct {
+	ID     int    `json:"id"`
+	Reason string `json:"reason"`
+}
+
+type ReportArtist struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// newSyncReport starts a report; tags already in the index don't count as new
+func newSyncReport(base string, accounts []Account) *SyncReport {
+	report := &SyncReport{
+		Started:     time.Now(),
+		NewArtworks: []int{},
+		Failed:      []FailedArtwork{},
+		NewArtists:  []ReportArtist{},
+		NewTags:     []string{},
+		knownTags:   make(map[string]bool),
+	}
+	for _, account := range accounts {
+		report.Accounts = append(report.Accounts, account.UserID)
+	}
+
+	if idx, err := index.Open(base, index.Detect(base)); err == nil {
+		for _, tag := range idx.Tags() {
+			report.knownTags[tag.Value] = true
+		}
+		idx.Close()
+	}
+	return report
+}
+
+// addTags records the tags of a newly downloaded artwork
+func (r *SyncReport) addTags(tags []model.TagData) {
+	for _, tag := range tags {
+		if !r.knownTags[tag.Tag] {
+			r.knownTags[tag.Tag] = true
+			r.NewTags = append(r.NewTags, tag.Tag)
+		}
+	}
+}
+
+func (r *SyncReport) finish(stats utils.Stats, err error) {
+	r.Finished = time.Now()
+	r.DurationSeconds = r.Finished.Sub(r.Started).Seconds()
+	r.Bytes = stats.Bytes
+	if err != nil {
+		r.Error = err.Error()
+	}
+	sort.Ints(r.NewArtworks)
+	sort.Strings(r.NewTags)
+}
+
+// save writes the report under base/reports and returns its path
+func (r *SyncReport) save(base string) (string, error) {
+	dir := filepath.Join(base, layout.ReportsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, "sync-"+r.Started.Format("20060102-150405")+".json")
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, content, 0644)
+}
+
+// Lines renders the report for the terminal
+func (r *SyncReport) Lines() []string {
+	lines := []string{
+		"===== Sync report =====",
+		fmt.Sprintf("Duration:     %s", time.Duration(r.DurationSeconds*float64(time.Second)).Round(time.Second)),
+		fmt.Sprintf("New artworks: %d", len(r.NewArtworks)),
+		fmt.Sprintf("Skipped:      %d", r.Skipped),
+		fmt.Sprintf("Failed:       %d", len(r.Failed)),
+	}
+	for _, failed := range r.Failed {
+		lines = append(lines, fmt.Sprintf("  - %d: %s", failed.ID, failed.Reason))
+	}
+	lines = append(lines, fmt.Sprintf("Transferred:  %.1f MB", float64(r.Bytes)/1024/1024))
+
+	var artists []string
+	for _, artist := range r.NewArtists {
+		artists = append(artists, fmt.Sprintf("%s (%d)", artist.Name, artist.ID))
+	}
+	lines = append(lines, fmt.Sprintf("New artists:  %d %s", len(r.NewArtists), strings.Join(artists, ", ")))
+	lines = append(lines, fmt.Sprintf("New tags:     %d %s", len(r.NewTags), strings.Join(r.NewTags, ", ")))
+	if r.Error != "" {
+		lines = append(lines, "Error:        "+r.Error)
+	}
+	return lines
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/Magnetkopf/pGallery/internal/filter"
+	"github.com/Magnetkopf/pGallery/internal/index"
+	"github.com/Magnetkopf/pGallery/internal/search"
+	"github.com/Magnetkopf/pGallery/utils"
+)
+
+type SearchArgs struct {
+	Base  string
+	Query string
+	// Limit caps how many artworks are listed, 0 for all
+	Limit int
+}
+
+// Search lists the artworks matching a query in the language of package
+// filter, the same the web UI's search box takes, one per line: ID, artist
+// ID, page count, title and directory, separated by tabs
+func Search(args SearchArgs) {
+	baseLock, err := utils.LockBase(args.Base, utils.LockShared, "search")
+	if err != nil {
+		log.Fatalf("Cannot search: %v", err)
+	}
+	defer baseLock.Release()
+
+	expr, err := filter.Parse(args.Query)
+	if err != nil {
+		log.Fatalf("Invalid search: %v", err)
+	}
+
+	idx, err := index.Open(args.Base, index.Detect(args.Base))
+	if err != nil {
+		log.Fatalf("Search failed: %v", err)
+	}
+	defer idx.Close()
+
+	var text *search.Index
+	if filter.NeedsText(expr) {
+		if text, err = search.Load(args.Base); err != nil {
+			log.Fatalf("Search failed: %v", err)
+		}
+	}
+	ids, err := filter.Evaluate(expr, idx, text)
+	if err != nil {
+		log.Fatalf("Search failed: %v", err)
+	}
+
+	// best match first when searching words, newest ID first otherwise
+	if words := filter.Text(expr); words != "" {
+		ids = text.Rank(words, ids)
+	} else {
+		sort.Slice(ids, func(i, j int) bool {
+			a, _ := strconv.Atoi(ids[i])
+			b, _ := strconv.Atoi(ids[j])
+			return a > b
+		})
+	}
+
+	total := len(ids)
+	if args.Limit > 0 && len(ids) > args.Limit {
+		ids = ids[:args.Limit]
+	}
+	for _, card := range idx.Artworks(ids) {
+		fmt.Printf("%s\t%s\t%d\t%s\t%s\n", card.ID, card.ArtistID, card.PageCount, card.Title, card.Path)
+	}
+	log.Printf("🔎 %d artworks match %s, %d listed", total, expr, len(ids))
+}
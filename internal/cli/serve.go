@@ -0,0 +1,227 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Magnetkopf/pGallery/internal/index"
+	"github.com/Magnetkopf/pGallery/internal/model"
+	"github.com/Magnetkopf/pGallery/internal/pixiv"
+	"github.com/Magnetkopf/pGallery/utils"
+	"github.com/Magnetkopf/pGallery/web"
+)
+
+type ServeArgs struct {
+	Sync     SyncArgs
+	Port     int
+	Interval time.Duration
+	// Index is the backend to build, see index.Backends; empty keeps the
+	// one the base already has
+	Index string
+}
+
+// indexCloseDelay is how long a replaced index stays open for the requests
+// still reading it
+const indexCloseDelay = time.Minute
+
+// Serve runs the web UI and periodically syncs and rebuilds the index in the
+// same process, swapping the new index in without a restart.
+func Serve(args ServeArgs) {
+	base := args.Sync.Base
+	if err := os.MkdirAll(base, 0755); err != nil {
+		log.Fatalf("Failed to create base directory: %v", err)
+	}
+
+	// serve is the only writer, so it keeps the library for itself
+	baseLock, err := utils.LockBase(base, utils.LockExclusive, "serve")
+	if err != nil {
+		log.Fatalf("Cannot serve: %v", err)
+	}
+	defer baseLock.Release()
+
+	ctx := &web.WebContext{Base: base}
+	backend := args.Index
+	if backend == "" {
+		backend = index.Detect(base)
+	}
+	idx, err := index.Open(base, backend)
+	if err != nil {
+		log.Printf("No usable index yet, starting empty: %v", err)
+		idx = index.NewMemory(&model.Store{})
+	}
+	ctx.SetIndex(idx)
+
+	s := &scheduler{
+		args:    args,
+		web:     ctx,
+		trigger: make(chan struct{}, 1),
+	}
+	s.state.Interval = args.Interval
+	ctx.Status = s.status
+	ctx.RunNow = s.runNow
+
+	go s.loop()
+
+	addr := fmt.Sprintf(":%d", args.Port)
+	log.Printf("Listening on http://localhost%s, syncing every %s", addr, args.Interval)
+	if err := http.ListenAndServe(addr, ctx.Handler()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type scheduler struct {
+	args    ServeArgs
+	web     *web.WebContext
+	trigger chan struct{}
+
+	mu       sync.Mutex
+	state    web.DaemonStatus
+	progress *utils.MemoryReporter // progress of the sync being run, if any
+}
+
+func (s *scheduler) status() web.DaemonStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.state
+	if state.Running && s.progress != nil {
+		p := s.progress.Snapshot()
+		state.Progress = fmt.Sprintf("%d/%d artworks, %d downloading",
+			p.Done+p.Skipped+p.Failed, p.Artworks, len(p.Downloads))
+	}
+	return state
+}
+
+func (s *scheduler) update(fn func(state *web.DaemonStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.state)
+}
+
+// runNow queues a run; it is ignored while one is already queued
+func (s *scheduler) runNow() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// loop runs once at startup and then every interval, or earlier when triggered
+func (s *scheduler) loop() {
+	timer := time.NewTimer(0)
+	for {
+		select {
+		case <-timer.C:
+		case <-s.trigger:
+			timer.Stop()
+		}
+
+		s.run()
+
+		timer.Reset(s.args.Interval)
+		s.update(func(state *web.DaemonStatus) {
+			state.NextRun = time.Now().Add(s.args.Interval)
+		})
+	}
+}
+
+func (s *scheduler) run() {
+	s.update(func(state *web.DaemonStatus) {
+		state.Running = true
+		state.Phase = "syncing"
+		state.Progress = ""
+		state.LastStart = time.Now()
+		state.LastFinish = time.Time{}
+		state.NextRun = time.Time{}
+	})
+
+	progress := utils.NewMemoryReporter(20)
+	s.mu.Lock()
+	s.progress = progress
+	s.mu.Unlock()
+
+	syncArgs := s.args.Sync
+	syncArgs.Accounts = reloadCookies(syncArgs.Accounts)
+	syncArgs.Reporter = utils.MultiReporter{utils.NewReporter(syncArgs.Output), progress}
+
+	var errs []error
+	err := runSync(syncArgs)
+	syncArgs.Reporter.Close()
+	if err != nil {
+		log.Printf("Scheduled sync failed: %v", err)
+		errs = append(errs, fmt.Errorf("sync: %w", err))
+	}
+
+	// rebuild even after a failed sync, whatever was downloaded should show up
+	s.update(func(state *web.DaemonStatus) {
+		state.Phase = "building index"
+	})
+	s.mu.Lock()
+	s.progress = nil
+	s.mu.Unlock()
+	if err := s.rebuild(); err != nil {
+		log.Printf("Scheduled build failed: %v", err)
+		errs = append(errs, fmt.Errorf("build: %w", err))
+	}
+
+	s.update(func(state *web.DaemonStatus) {
+		state.Running = false
+		state.Phase = ""
+		state.Progress = ""
+		state.LastFinish = time.Now()
+		state.Runs++
+		state.LastError = ""
+		if err := errors.Join(errs...); err != nil {
+			state.LastError = err.Error()
+		}
+	})
+}
+
+// reloadCookies reads the cookie files of accounts again, so a cookie
+// refreshed since startup is used without a restart. Environment variables
+// and stdin can't change, and an unreadable file keeps the cookie read before.
+func reloadCookies(accounts []Account) []Account {
+	reloaded := make([]Account, len(accounts))
+	for i, account := range accounts {
+		reloaded[i] = account
+		if account.CookieSource == "" || !pixiv.IsCookieFile(account.CookieSource) {
+			continue
+		}
+		cookie, err := pixiv.LoadCookie(account.CookieSource)
+		if err != nil {
+			log.Printf("⚠️ Keeping the previous cookie of %s: %v", account.UserID, err)
+			continue
+		}
+		reloaded[i].Cookie = cookie
+	}
+	return reloaded
+}
+
+// rebuild builds the index and swaps it into the web UI
+func (s *scheduler) rebuild() error {
+	base := s.args.Sync.Base
+	backend := s.args.Index
+	if backend == "" {
+		backend = index.Detect(base)
+	}
+	store, err := buildIndex(base, buildOptions{Index: backend})
+	if err != nil {
+		return err
+	}
+
+	// the JSON index is the store itself, no need to read it back
+	var idx index.Index = index.NewMemory(store)
+	if backend != index.JSON {
+		if idx, err = index.Open(base, backend); err != nil {
+			return err
+		}
+	}
+	if old := s.web.SetIndex(idx); old != nil {
+		time.AfterFunc(indexCloseDelay, func() { old.Close() })
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadCookies(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "cookie.txt")
+	if err := os.WriteFile(file, []byte("PHPSESSID=refreshed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PGALLERY_TEST_COOKIE", "PHPSESSID=from_env")
+
+	accounts := []Account{
+		{UserID: "1", Cookie: "PHPSESSID=old", CookieSource: file},
+		{UserID: "2", Cookie: "PHPSESSID=old", CookieSource: filepath.Join(dir, "missing.txt")},
+		{UserID: "3", Cookie: "PHPSESSID=old", CookieSource: "env:PGALLERY_TEST_COOKIE"},
+		{UserID: "4", Cookie: "PHPSESSID=old", CookieSource: "-"},
+		{UserID: "5", Cookie: "PHPSESSID=old"},
+	}
+	want := []string{"PHPSESSID=refreshed", "PHPSESSID=old", "PHPSESSID=old", "PHPSESSID=old", "PHPSESSID=old"}
+
+	reloaded := reloadCookies(accounts)
+	for i, account := range reloaded {
+		if account.Cookie != want[i] {
+			t.Errorf("account %s: cookie = %q, want %q", account.UserID, account.Cookie, want[i])
+		}
+	}
+	if accounts[0].Cookie != "PHPSESSID=old" {
+		t.Error("reloadCookies changed the accounts it was given")
+	}
+}
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -12,54 +13,117 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/Magnetkopf/pGallery/internal/config"
+	"github.com/Magnetkopf/pGallery/internal/layout"
 	"github.com/Magnetkopf/pGallery/internal/model"
 	"github.com/Magnetkopf/pGallery/internal/pixiv"
+	"github.com/Magnetkopf/pGallery/internal/thumbs"
 	"github.com/Magnetkopf/pGallery/utils"
 	"github.com/tidwall/gjson"
 	"gopkg.in/yaml.v3"
 )
 
+// Account is a pixiv user whose bookmarks are synced
+type Account struct {
+	UserID string
+	Cookie string
+	// CookieSource is where Cookie was read from, see pixiv.LoadCookie, so
+	// serve can read a refreshed cookie file before every run
+	CookieSource string
+}
+
 type SyncArgs struct {
-	Cookie     string
-	UserID     string
-	Base       string
-	Downloader string
+	Accounts    []Account
+	Base        string
+	Downloader  string
+	Concurrency int
+	Filters     config.Filters
+	Output      utils.OutputMode
+	// Layout places new artworks and artists; the zero value is layout.Default
+	Layout layout.Layout
+
+	// RecheckDead retries artworks recorded in tombstones.json
+	RecheckDead bool
+
+	// Reporter receives progress; when nil, Sync creates one for Output
+	Reporter utils.Reporter
 }
 
 const limitPerPage = 48
 
 func Sync(args SyncArgs) {
-	client := &pixiv.Client{
-		Cookie: args.Cookie,
-	}
-
-	utils.InitUI()
-	defer utils.StopUI()
-
-	downloadManager := utils.NewDownloadManager(5)
-	defer downloadManager.Wait()
-
 	// Ensure base directory exists
 	if err := os.MkdirAll(args.Base, 0755); err != nil {
 		log.Fatalf("Failed to create base directory: %v", err)
 	}
 
-	dest := fmt.Sprintf("https://www.pixiv.net/ajax/user/%s/illusts/bookmarks?tag=&offset=0&limit=%d&rest=show&lang=en", args.UserID, limitPerPage)
-	res, err := client.Get(dest)
+	baseLock, err := utils.LockBase(args.Base, utils.LockExclusive, "sync")
 	if err != nil {
-		log.Fatalln("Error fetching initial bookmarks:", err)
-		return
+		log.Fatalf("Cannot sync: %v", err)
 	}
+	defer baseLock.Release()
 
-	if gjson.Get(res, "error").Bool() {
-		log.Fatalf("API Error: %s", gjson.Get(res, "message").String())
+	if args.Reporter == nil {
+		args.Reporter = utils.NewReporter(args.Output)
+	}
+	err = runSync(args)
+	args.Reporter.Close()
+	if err != nil {
+		log.Fatalf("Sync failed: %v", err)
 	}
+}
 
-	totalArtworks := gjson.Get(res, "body.total").Int()
-	totalPages := int((totalArtworks + limitPerPage - 1) / limitPerPage)
-	log.Printf("Total artworks: %d, Total pages: %d", totalArtworks, totalPages)
+// runSync does the work of Sync; the caller must hold the base lock exclusively
+// and set args.Reporter
+func runSync(args SyncArgs) (err error) {
+	stats := utils.NewStatsReporter()
+	reporter := utils.MultiReporter{args.Reporter, stats}
+
+	startTime := time.Now()
+	summary := utils.Summary{}
+	report := newSyncReport(args.Base, args.Accounts)
+	defer func() {
+		summary.Seconds = time.Since(startTime).Seconds()
+		reporter.Summary(summary)
+
+		report.finish(stats.Stats(), err)
+		for _, line := range report.Lines() {
+			reporter.Log(line)
+		}
+		if path, saveErr := report.save(args.Base); saveErr != nil {
+			reporter.Log(fmt.Sprintf("⚠️ Failed to save sync report: %v", saveErr))
+		} else {
+			reporter.Log("Report saved to " + path)
+		}
+	}()
+
+	fail := func(artworkID int, reason string) {
+		reporter.ArtworkFailed(artworkID, reason)
+		summary.Failed++
+		report.Failed = append(report.Failed, FailedArtwork{ID: artworkID, Reason: reason})
+	}
+
+	dirs := layout.Default.Merge(args.Layout)
+	if err := dirs.Validate(); err != nil {
+		return err
+	}
+	// artworks already on disk stay where they are, whatever the layout; the
+	// scan reuses what the last build read, so unchanged folders aren't parsed
+	library, _, err := layout.ScanCached(args.Base, loadBuildCache(args.Base, false).Scan, 0)
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %w", args.Base, err)
+	}
+
+	concurrency := args.Concurrency
+	if concurrency < 1 {
+		concurrency = config.Default.Concurrency
+	}
+	downloadManager := utils.NewDownloadManager(concurrency, reporter)
+	defer downloadManager.Wait()
 
 	var artworkList []int
+	bookmarks := make(map[int]*bookmarkedArtwork)
+	filteredCount := 0
 	artistPFP := make(map[int]string)
 
 	downloadedRecordPath := filepath.Join(args.Base, "downloaded.json")
@@ -71,70 +135,271 @@ func Sync(args SyncArgs) {
 			for _, id := range loadedIDs {
 				downloadedMap[id] = true
 			}
-			log.Printf("Loaded %d records from downloaded.json", len(loadedIDs))
+			reporter.Log(fmt.Sprintf("Loaded %d records from downloaded.json", len(loadedIDs)))
 		}
 	}
 
-	for i := 0; i < totalPages; i++ {
-		offset := i * limitPerPage
-		log.Printf("🔍 Fetching page %d/%d...", i+1, totalPages)
+	tombstones, err := loadTombstones(args.Base)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", tombstonesFile, err)
+	}
 
-		dest = fmt.Sprintf("https://www.pixiv.net/ajax/user/%s/illusts/bookmarks?tag=&offset=%d&limit=%d&rest=show&lang=en", args.UserID, offset, limitPerPage)
-		bookmarkRes, err := client.Get(dest)
+	// bury records an artwork pixiv refuses to serve so later runs skip it
+	bury := func(bookmark *bookmarkedArtwork, reason string) {
+		tombstone, ok := tombstones[bookmark.ID]
+		if !ok {
+			tombstone = &model.Tombstone{
+				ID:        bookmark.ID,
+				FirstSeen: time.Now(),
+			}
+			tombstones[bookmark.ID] = tombstone
+		}
+		tombstone.ArtistID = bookmark.ArtistID
+		tombstone.Title = bookmark.Title
+		tombstone.Error = reason
+		tombstone.LastChecked = time.Now()
+		if bookmark.ThumbnailUrl != "" {
+			tombstone.ThumbnailUrl = bookmark.ThumbnailUrl
+		}
+
+		if err := saveTombstones(args.Base, tombstones); err != nil {
+			reporter.Log(fmt.Sprintf("⚠️ Failed to write %s: %v", tombstonesFile, err))
+		}
+		reporter.Log(fmt.Sprintf("🪦 Recorded lost work %d: %s", bookmark.ID, reason))
+
+		if tombstone.ThumbnailUrl == "" || tombstoneThumbnail(args.Base, bookmark.ID) != "" {
+			return
+		}
+		thumbDir := filepath.Join(args.Base, tombstoneThumbDir)
+		thumbName := strconv.Itoa(bookmark.ID) + ".jpg"
+		downloadManager.Add(utils.DownloadTask{
+			Args: utils.DownloaderArgs{
+				ID:         fmt.Sprintf("%d(lost)", bookmark.ID),
+				Url:        tombstone.ThumbnailUrl,
+				SavePath:   thumbDir,
+				FileName:   thumbName,
+				Referer:    "https://www.pixiv.net",
+				Downloader: args.Downloader,
+			},
+			OnComplete: func(success bool) {
+				if success {
+					_ = utils.ModifyPictureExtension(filepath.Join(thumbDir, thumbName))
+				}
+			},
+		})
+	}
+
+	clients := make(map[string]*pixiv.Client)
+	var accountErrs []error
+	for _, account := range args.Accounts {
+		client := &pixiv.Client{
+			Cookie: account.Cookie,
+		}
+
+		if err := client.CheckSession(account.UserID); err != nil {
+			reporter.Log(fmt.Sprintf("⚠️ Skipping account %s: %v", account.UserID, err))
+			accountErrs = append(accountErrs, fmt.Errorf("account %s: %w", account.UserID, err))
+			continue
+		}
+
+		works, err := fetchBookmarks(client, account.UserID, reporter)
 		if err != nil {
-			log.Printf("Error fetching page %d: %v", i, err)
+			reporter.Log(fmt.Sprintf("⚠️ Skipping account %s: %v", account.UserID, err))
+			accountErrs = append(accountErrs, fmt.Errorf("account %s: %w", account.UserID, err))
 			continue
 		}
+		clients[account.UserID] = client
 
-		gjson.Get(bookmarkRes, "body.works").ForEach(func(_, value gjson.Result) bool {
+		for _, value := range works {
 			artworkID := int(value.Get("id").Int())
 			artistID := int(value.Get("userId").Int())
 
-			artworkList = append(artworkList, artworkID)
+			var tags []string
+			value.Get("tags").ForEach(func(_, tag gjson.Result) bool {
+				tags = append(tags, tag.String())
+				return true
+			})
+			if !args.Filters.Allow(tags) {
+				filteredCount++
+				continue
+			}
+
+			bookmark, ok := bookmarks[artworkID]
+			if !ok {
+				bookmark = &bookmarkedArtwork{
+					ID:       artworkID,
+					ArtistID: artistID,
+					Title:    value.Get("title").String(),
+				}
+				if !value.Get("isMasked").Bool() {
+					bookmark.ThumbnailUrl = value.Get("url").String()
+				}
+				bookmarks[artworkID] = bookmark
+				artworkList = append(artworkList, artworkID)
+			}
+			bookmark.BookmarkedBy = appendUnique(bookmark.BookmarkedBy, account.UserID)
+			bookmark.BookmarkID = max(bookmark.BookmarkID, value.Get("bookmarkData.id").Int())
 
 			//replace to get higher quality profile photo
 			artistPFP[artistID] = strings.Replace(value.Get("profileImageUrl").String(), "_50.", "_170.", -1)
-			return true
-		})
+		}
+	}
+
+	if len(clients) == 0 {
+		return fmt.Errorf("no account could be synced: %w", errors.Join(accountErrs...))
+	}
 
+	reporter.Log(fmt.Sprintf("Found %d unique artworks across %d account(s)", len(artworkList), len(clients)))
+	if filteredCount > 0 {
+		reporter.Log(fmt.Sprintf("Filtered out %d artworks by tag filters", filteredCount))
 	}
 
-	utils.UILog(fmt.Sprintf("Found %d artworks, Expect %d artworks", len(artworkList), totalArtworks))
+	summary.Total = len(artworkList)
+	report.Total = len(artworkList)
+	reporter.SyncStarted(len(artworkList))
 
 	for _, artworkID := range artworkList {
+		bookmark := bookmarks[artworkID]
+
 		if downloadedMap[artworkID] {
-			utils.UILog(fmt.Sprintf("\033[1;36m Skipped: %d \033[0m", artworkID))
+			if dir, ok := library.Artworks[artworkID]; ok {
+				artworkYamlFile := filepath.Join(args.Base, dir, "artwork.yaml")
+				if err := mergeBookmark(artworkYamlFile, bookmark); err != nil {
+					reporter.Log(fmt.Sprintf("⚠️ Failed to update bookmarks of %d: %v", artworkID, err))
+				}
+			}
+			reporter.Log(fmt.Sprintf("\033[1;36m Skipped: %d \033[0m", artworkID))
+			reporter.ArtworkSkipped(artworkID)
+			summary.Skipped++
+			report.Skipped++
+			continue
+		}
+
+		if tombstone, ok := tombstones[artworkID]; ok && !args.RecheckDead {
+			reporter.Log(fmt.Sprintf("🪦 Skipped lost work: %d (%s)", artworkID, tombstone.Error))
+			reporter.ArtworkSkipped(artworkID)
+			summary.Skipped++
+			report.Skipped++
+			continue
+		}
+
+		// any account that bookmarked the artwork can see it
+		accountID, client := bookmarkClient(clients, bookmark)
+		if client == nil {
+			fail(artworkID, "no account that bookmarked it has a usable session")
 			continue
 		}
 
-		dest = fmt.Sprintf("https://www.pixiv.net/ajax/illust/%d", artworkID)
+		dest := fmt.Sprintf("https://www.pixiv.net/ajax/illust/%d", artworkID)
 		illustRes, err := client.Get(dest)
 		if err != nil {
-			log.Printf("Error fetching artwork %d: %v", artworkID, err)
+			reporter.Log(fmt.Sprintf("Error fetching artwork %d: %v", artworkID, err))
+			var statusErr *pixiv.StatusError
+			if errors.As(err, &statusErr) && statusErr.Unauthorized() {
+				// the session ended mid-run: stop using the account rather
+				// than taking every remaining work for lost
+				reporter.Log(fmt.Sprintf("⚠️ Stopping account %s: pixiv refused its session", accountID))
+				delete(clients, accountID)
+			} else if errors.As(err, &statusErr) && statusErr.Gone() {
+				bury(bookmark, err.Error())
+			}
+			fail(artworkID, err.Error())
 			continue
 		}
 
-		if gjson.Get(illustRes, "error").Bool() {
-			log.Printf("API Error for artwork %d: %s", artworkID, gjson.Get(illustRes, "message").String())
+		if message := gjson.Get(illustRes, "message").String(); gjson.Get(illustRes, "error").Bool() {
+			reporter.Log(fmt.Sprintf("API Error for artwork %d: %s", artworkID, message))
+			fail(artworkID, "API Error: "+message)
+			if pixiv.GoneMessage(message) {
+				bury(bookmark, message)
+			}
 			continue
 		}
 
+		if _, ok := tombstones[artworkID]; ok {
+			reporter.Log(fmt.Sprintf("Artwork %d is available again", artworkID))
+			delete(tombstones, artworkID)
+			if err := saveTombstones(args.Base, tombstones); err != nil {
+				reporter.Log(fmt.Sprintf("⚠️ Failed to write %s: %v", tombstonesFile, err))
+			}
+		}
+
 		url := gjson.Get(illustRes, "body.urls.original").String()
 		artistID := int(gjson.Get(illustRes, "body.userId").Int())
-		artworkPath := filepath.Join(args.Base, strconv.Itoa(artistID), strconv.Itoa(int(artworkID)))
-		artistPath := filepath.Join(args.Base, strconv.Itoa(artistID))
+
+		// YAML files, also what the layout templates are filled from
+		var tagData []model.TagData
+		gjson.Get(illustRes, "body.tags.tags").ForEach(func(_, value gjson.Result) bool {
+			tagData = append(tagData, model.TagData{
+				Tag:         value.Get("tag").String(),
+				Locked:      value.Get("locked").Bool(),
+				Romaji:      value.Get("romaji").String(),
+				Translation: value.Get("translation.en").String(),
+			})
+			return true
+		})
+
+		artworkDetailData := model.ArtworkData{
+			ID:            int(gjson.Get(illustRes, "body.id").Int()),
+			Title:         gjson.Get(illustRes, "body.title").String(),
+			Description:   gjson.Get(illustRes, "body.description").String(),
+			PageCount:     int(gjson.Get(illustRes, "body.pageCount").Int()),
+			Tags:          tagData,
+			OriginalUrl:   gjson.Get(illustRes, "body.urls.original").String(),
+			ArtistId:      artistID,
+			ArtistName:    gjson.Get(illustRes, "body.userName").String(),
+			CreateDate:    gjson.Get(illustRes, "body.createDate").String(),
+			BookmarkedBy:  bookmark.BookmarkedBy,
+			XRestrict:     int(gjson.Get(illustRes, "body.xRestrict").Int()),
+			AIType:        int(gjson.Get(illustRes, "body.aiType").Int()),
+			BookmarkID:    bookmark.BookmarkID,
+			BookmarkCount: int(gjson.Get(illustRes, "body.bookmarkCount").Int()),
+			LikeCount:     int(gjson.Get(illustRes, "body.likeCount").Int()),
+			ViewCount:     int(gjson.Get(illustRes, "body.viewCount").Int()),
+		}
+
+		artistDetailData := model.ArtistData{
+			ID:      int(gjson.Get(illustRes, "body.userId").Int()),
+			Name:    gjson.Get(illustRes, "body.userName").String(),
+			Account: gjson.Get(illustRes, "body.userAccount").String(),
+		}
+
+		artworkDir, ok := library.Artworks[artworkID]
+		if !ok {
+			artworkDir = dirs.ArtworkDir(artworkDetailData)
+			library.Artworks[artworkID] = artworkDir
+		}
+		artistDir, ok := library.Artists[artistID]
+		if !ok {
+			artistDir = dirs.ArtistDir(artistDetailData)
+			library.Artists[artistID] = artistDir
+			report.NewArtists = append(report.NewArtists, ReportArtist{
+				ID:   artistID,
+				Name: artistDetailData.Name,
+			})
+		}
+
+		artworkPath := filepath.Join(args.Base, artworkDir)
+		artistPath := filepath.Join(args.Base, artistDir)
 		artworkYamlFile := filepath.Join(artworkPath, "artwork.yaml")
 		artistYamlFile := filepath.Join(artistPath, "artist.yaml")
 
-		// Create folder
+		// Create folders
+		if err := os.MkdirAll(artistPath, 0755); err != nil {
+			reporter.Log(fmt.Sprintf("⚠️ Failed to create artist directory: %v", err))
+			fail(artworkID, err.Error())
+			continue
+		}
 		if err := os.MkdirAll(artworkPath, 0755); err != nil {
-			log.Printf("⚠️ Failed to create artwork directory: %v", err)
+			reporter.Log(fmt.Sprintf("⚠️ Failed to create artwork directory: %v", err))
+			fail(artworkID, err.Error())
 			continue
 		}
 
-		utils.UILog(fmt.Sprintf("👀 %d", artworkID))
+		reporter.Log(fmt.Sprintf("👀 %d", artworkID))
 		// Download all pictures
 		pageCount := gjson.Get(illustRes, "body.pageCount").Uint()
+		reporter.ArtworkStarted(artworkID, int(pageCount))
 
 		// Track successful page downloads; only record artwork as done when all pages succeed.
 		var successCount atomic.Int32
@@ -142,7 +407,7 @@ func Sync(args SyncArgs) {
 
 		for i := uint64(0); i < pageCount; i++ { //download all pictures
 			fileExtension := url[len(url)-3:] //file extension
-			var fileName = "p" + strconv.Itoa(int(i)) + "." + fileExtension
+			var fileName = layout.PageFile(int(i)) + "." + fileExtension
 			newUrl := strings.Replace(url, "_p0.", "_p"+strconv.Itoa(int(i))+".", -1)
 
 			capI := i
@@ -168,19 +433,19 @@ func Sync(args SyncArgs) {
 						fullFilePath := filepath.Join(capArtworkPath, capFileName)
 						err := utils.ModifyPictureExtension(fullFilePath)
 						if err != nil {
-							log.Printf("⚠️ Failed to modify picture extension: %v", err)
+							reporter.Log(fmt.Sprintf("⚠️ Failed to modify picture extension: %v", err))
 							return
 						}
 
-						if capI == 0 { //copy p0 as folder picture
-							folderFileName := "folder." + capFileExt
-							folderFilePath := filepath.Join(capArtworkPath, folderFileName)
-							if err := utils.CopyFile(fullFilePath, folderFilePath); err != nil {
-								log.Printf("⚠️ Failed to create folder image: %v", err)
+						if capI == 0 { //thumbnails and folder picture from p0
+							// the extension may have just been corrected
+							if matches, _ := filepath.Glob(filepath.Join(capArtworkPath, layout.PageFile(0)+".*")); len(matches) > 0 {
+								fullFilePath = matches[0]
 							}
+							writeCovers(args.Base, artworkID, fullFilePath, capArtworkPath, capFileExt, reporter)
 						}
 					} else {
-						log.Printf("⚠️ Failed to download %s", capFileName)
+						reporter.Log(fmt.Sprintf("⚠️ Failed to download %s", capFileName))
 					}
 				},
 			})
@@ -208,10 +473,10 @@ func Sync(args SyncArgs) {
 						fullFilePath := filepath.Join(capArtistPath, "folder.jpg")
 						err := utils.ModifyPictureExtension(fullFilePath)
 						if err != nil {
-							log.Printf("⚠️ Failed to modify picture extension: %v", err)
+							reporter.Log(fmt.Sprintf("⚠️ Failed to modify picture extension: %v", err))
 						}
 					} else {
-						log.Printf("⚠️ Failed to download artist pfp: %s", capArtistPFPUrl)
+						reporter.Log(fmt.Sprintf("⚠️ Failed to download artist pfp: %s", capArtistPFPUrl))
 					}
 				},
 			})
@@ -220,7 +485,8 @@ func Sync(args SyncArgs) {
 		// Wait for all tasks (pages + pfp) of this artwork to finish
 		artworkWg.Wait()
 
-		if successCount.Load() == int32(pageCount) {
+		downloaded := successCount.Load() == int32(pageCount)
+		if downloaded {
 			downloadedMap[artworkID] = true
 			var idsToWrite []int
 			for id := range downloadedMap {
@@ -230,62 +496,150 @@ func Sync(args SyncArgs) {
 			if jsonData, err := json.MarshalIndent(idsToWrite, "", "  "); err == nil {
 				_ = os.WriteFile(downloadedRecordPath, jsonData, 0644)
 			}
-			utils.UILog(fmt.Sprintf("\033[1;32m ✅ Recorded: %d \033[0m", artworkID))
+			reporter.Log(fmt.Sprintf("\033[1;32m ✅ Recorded: %d \033[0m", artworkID))
+			reporter.ArtworkDone(artworkID)
+			summary.Downloaded++
+			report.NewArtworks = append(report.NewArtworks, artworkID)
+			report.addTags(tagData)
 		} else {
-			log.Printf("⚠️ Artwork %d: only %d/%d pages succeeded, NOT marking as downloaded",
-				artworkID, successCount.Load(), pageCount)
-		}
-
-		// YAML files
-		var tagData []model.TagData
-		gjson.Get(illustRes, "body.tags.tags").ForEach(func(_, value gjson.Result) bool {
-			tagData = append(tagData, model.TagData{
-				Tag:         value.Get("tag").String(),
-				Locked:      value.Get("locked").Bool(),
-				Romaji:      value.Get("romaji").String(),
-				Translation: value.Get("translation.en").String(),
-			})
-			return true
-		})
-
-		artworkDetailData := model.ArtworkData{
-			ID:          int(gjson.Get(illustRes, "body.id").Int()),
-			Title:       gjson.Get(illustRes, "body.title").String(),
-			Description: gjson.Get(illustRes, "body.description").String(),
-			PageCount:   int(pageCount),
-			Tags:        tagData,
-			OriginalUrl: gjson.Get(illustRes, "body.urls.original").String(),
-			ArtistId:    artistID,
-			ArtistName:  gjson.Get(illustRes, "body.userName").String(),
-			CreateDate:  gjson.Get(illustRes, "body.createDate").String(),
-		}
-
-		artistDetailData := model.ArtistData{
-			ID:      int(gjson.Get(illustRes, "body.userId").Int()),
-			Name:    gjson.Get(illustRes, "body.userName").String(),
-			Account: gjson.Get(illustRes, "body.userAccount").String(),
+			reporter.Log(fmt.Sprintf("⚠️ Artwork %d: only %d/%d pages succeeded, NOT marking as downloaded",
+				artworkID, successCount.Load(), pageCount))
+			fail(artworkID, fmt.Sprintf("only %d/%d pages succeeded", successCount.Load(), pageCount))
 		}
 
 		//write to FS
 		artworkYamlBytes, err := yaml.Marshal(artworkDetailData)
 		if err != nil {
-			log.Fatalf("Error marshaling YAML: %v", err)
+			return fmt.Errorf("error marshaling YAML: %w", err)
 		}
 		//overwrite if exists
 		err = os.WriteFile(artworkYamlFile, artworkYamlBytes, 0644)
 		if err != nil {
-			log.Fatalf("Error writing YAML file: %v", err)
+			return fmt.Errorf("error writing YAML file: %w", err)
 		}
 		artistYamlBytes, err := yaml.Marshal(artistDetailData)
 		if err != nil {
-			log.Fatalf("Error marshaling YAML: %v", err)
+			return fmt.Errorf("error marshaling YAML: %w", err)
 		}
 		//overwrite if exists
 		err = os.WriteFile(artistYamlFile, artistYamlBytes, 0644)
 		if err != nil {
-			log.Fatalf("Error writing YAML file: %v", err)
+			return fmt.Errorf("error writing YAML file: %w", err)
 		}
 
 		time.Sleep(1 * time.Second) //wait 1s
 	}
+
+	return nil
+}
+
+// writeCovers makes the thumbnails of an artwork from its first page and a
+// downscaled folder.jpg; when the page can't be decoded, folder.* is a copy of it
+func writeCovers(base string, artworkID int, firstPage, artworkPath, fileExt string, reporter utils.Reporter) {
+	if _, err := thumbs.Generate(base, artworkID, firstPage, true); err != nil {
+		reporter.Log(fmt.Sprintf("⚠️ Failed to create thumbnails: %v", err))
+		if err := utils.CopyFile(firstPage, filepath.Join(artworkPath, "folder."+fileExt)); err != nil {
+			reporter.Log(fmt.Sprintf("⚠️ Failed to create folder image: %v", err))
+		}
+		return
+	}
+
+	folderThumb := filepath.Join(base, filepath.FromSlash(thumbs.Path(artworkID, thumbs.Folder)))
+	if err := utils.CopyFile(folderThumb, filepath.Join(artworkPath, "folder.jpg")); err != nil {
+		reporter.Log(fmt.Sprintf("⚠️ Failed to create folder image: %v", err))
+	}
+}
+
+// bookmarkedArtwork is an artwork found in the bookmarks of at least one account
+type bookmarkedArtwork struct {
+	ID           int
+	ArtistID     int
+	Title        string
+	ThumbnailUrl string // empty when pixiv masks the work in the list
+	BookmarkedBy []string
+	BookmarkID   int64 // the latest bookmark of the accounts
+}
+
+// fetchBookmarks returns every public bookmark of userID
+func fetchBookmarks(client *pixiv.Client, userID string, reporter utils.Reporter) ([]gjson.Result, error) {
+	dest := fmt.Sprintf("https://www.pixiv.net/ajax/user/%s/illusts/bookmarks?tag=&offset=0&limit=%d&rest=show&lang=en", userID, limitPerPage)
+	res, err := client.Get(dest)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching initial bookmarks: %w", err)
+	}
+
+	if gjson.Get(res, "error").Bool() {
+		return nil, fmt.Errorf("API Error: %s", gjson.Get(res, "message").String())
+	}
+
+	totalArtworks := gjson.Get(res, "body.total").Int()
+	totalPages := int((totalArtworks + limitPerPage - 1) / limitPerPage)
+	reporter.Log(fmt.Sprintf("User %s: Total artworks: %d, Total pages: %d", userID, totalArtworks, totalPages))
+
+	var works []gjson.Result
+	for i := 0; i < totalPages; i++ {
+		offset := i * limitPerPage
+		reporter.Log(fmt.Sprintf("🔍 Fetching page %d/%d...", i+1, totalPages))
+
+		dest = fmt.Sprintf("https://www.pixiv.net/ajax/user/%s/illusts/bookmarks?tag=&offset=%d&limit=%d&rest=show&lang=en", userID, offset, limitPerPage)
+		bookmarkRes, err := client.Get(dest)
+		if err != nil {
+			reporter.Log(fmt.Sprintf("Error fetching page %d: %v", i, err))
+			continue
+		}
+
+		works = append(works, gjson.Get(bookmarkRes, "body.works").Array()...)
+	}
+
+	return works, nil
+}
+
+// bookmarkClient returns an account that bookmarked the artwork and still has
+// a usable session, nil when there is none
+func bookmarkClient(clients map[string]*pixiv.Client, bookmark *bookmarkedArtwork) (string, *pixiv.Client) {
+	for _, userID := range bookmark.BookmarkedBy {
+		if client, ok := clients[userID]; ok {
+			return userID, client
+		}
+	}
+	return "", nil
+}
+
+// mergeBookmark adds the bookmarking accounts to the bookmarked_by list of an
+// existing artwork.yaml and records a newer bookmark ID
+func mergeBookmark(artworkYamlFile string, bookmark *bookmarkedArtwork) error {
+	yamlBytes, err := os.ReadFile(artworkYamlFile)
+	if err != nil {
+		return err
+	}
+
+	var artworkData model.ArtworkData
+	if err := yaml.Unmarshal(yamlBytes, &artworkData); err != nil {
+		return err
+	}
+
+	merged := artworkData.BookmarkedBy
+	for _, userID := range bookmark.BookmarkedBy {
+		merged = appendUnique(merged, userID)
+	}
+	if len(merged) == len(artworkData.BookmarkedBy) && bookmark.BookmarkID <= artworkData.BookmarkID {
+		return nil
+	}
+	artworkData.BookmarkedBy = merged
+	artworkData.BookmarkID = max(artworkData.BookmarkID, bookmark.BookmarkID)
+
+	yamlBytes, err = yaml.Marshal(artworkData)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(artworkYamlFile, yamlBytes, 0644)
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if existing == value {
+			return list
+		}
+	}
+	return append(list, value)
 }
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/Magnetkopf/pGallery/internal/layout"
+	"github.com/Magnetkopf/pGallery/internal/thumbs"
+	"github.com/Magnetkopf/pGallery/utils"
+)
+
+type ThumbsArgs struct {
+	Base string
+	// Force renders every thumbnail again, not just the missing ones
+	Force bool
+	// Folders also replaces folder.* copies of p0 with a downscaled folder.jpg
+	Folders bool
+}
+
+// Thumbs backfills thumbnails for artworks synced before they existed
+func Thumbs(args ThumbsArgs) {
+	mode := utils.LockShared
+	if args.Folders {
+		mode = utils.LockExclusive
+	}
+	baseLock, err := utils.LockBase(args.Base, mode, "thumbs")
+	if err != nil {
+		log.Fatalf("Cannot make thumbnails: %v", err)
+	}
+	defer baseLock.Release()
+
+	library, err := layout.Scan(args.Base)
+	if err != nil {
+		log.Fatalf("Failed to scan %s: %v", args.Base, err)
+	}
+
+	ids := sortedKeys(library.Artworks)
+	log.Printf("Making thumbnails for %d artworks...", len(ids))
+
+	written, failed := 0, 0
+	for i, id := range ids {
+		artworkPath := filepath.Join(args.Base, library.Artworks[id])
+		matches, _ := filepath.Glob(filepath.Join(artworkPath, layout.PageFile(0)+".*"))
+		if len(matches) == 0 {
+			log.Printf("⚠️ Artwork %d: no %s.*, skipped", id, layout.PageFile(0))
+			failed++
+			continue
+		}
+
+		n, err := thumbs.Generate(args.Base, id, matches[0], args.Force)
+		if err != nil {
+			log.Printf("⚠️ Artwork %d: %v", id, err)
+			failed++
+			continue
+		}
+		written += n
+
+		if args.Folders {
+			if err := replaceFolderImage(args.Base, id, artworkPath); err != nil {
+				log.Printf("⚠️ Artwork %d: failed to replace folder image: %v", id, err)
+			}
+		}
+
+		if (i+1)%100 == 0 {
+			log.Printf("%d/%d artworks done", i+1, len(ids))
+		}
+	}
+
+	log.Printf("Wrote %d thumbnails, %d artworks failed", written, failed)
+	if _, err := buildIndex(args.Base, buildOptions{}); err != nil {
+		log.Fatalf("Build failed: %v", err)
+	}
+}
+
+// replaceFolderImage swaps folder.* for a copy of the folder-sized thumbnail
+func replaceFolderImage(base string, artworkID int, artworkPath string) error {
+	folderThumb := filepath.Join(base, filepath.FromSlash(thumbs.Path(artworkID, thumbs.Folder)))
+	if err := utils.CopyFile(folderThumb, filepath.Join(artworkPath, "folder.jpg.tmp")); err != nil {
+		return err
+	}
+
+	oldFolders, _ := filepath.Glob(filepath.Join(artworkPath, "folder.*"))
+	for _, old := range oldFolders {
+		if filepath.Base(old) != "folder.jpg.tmp" {
+			if err := os.Remove(old); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Rename(filepath.Join(artworkPath, "folder.jpg.tmp"), filepath.Join(artworkPath, "folder.jpg"))
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/Magnetkopf/pGallery/internal/model"
+)
+
+const (
+	tombstonesFile = "tombstones.json"
+	// local copies of lost works' bookmark thumbnails, named <artwork id>.<ext>
+	tombstoneThumbDir = ".tombstones"
+)
+
+// loadTombstones reads tombstones.json; a missing file means no tombstones
+func loadTombstones(base string) (map[int]*model.Tombstone, error) {
+	tombstones := make(map[int]*model.Tombstone)
+
+	content, err := os.ReadFile(filepath.Join(base, tombstonesFile))
+	if os.IsNotExist(err) {
+		return tombstones, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*model.Tombstone
+	if err := json.Unmarshal(content, &list); err != nil {
+		return nil, err
+	}
+	for _, tombstone := range list {
+		tombstones[tombstone.ID] = tombstone
+	}
+	return tombstones, nil
+}
+
+func saveTombstones(base string, tombstones map[int]*model.Tombstone) error {
+	list := make([]*model.Tombstone, 0, len(tombstones))
+	for _, tombstone := range tombstones {
+		list = append(list, tombstone)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	content, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(base, tombstonesFile), content, 0644)
+}
+
+// tombstoneThumbnail finds the local thumbnail of a lost work, relative to base
+func tombstoneThumbnail(base string, id int) string {
+	matches, _ := filepath.Glob(filepath.Join(base, tombstoneThumbDir, strconv.Itoa(id)+".*"))
+	if len(matches) == 0 {
+		return ""
+	}
+	rel, err := filepath.Rel(base, matches[0])
+	if err != nil {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
@@ -1,11 +1,20 @@
 package cli
 
 import (
+	"log"
+
+	"github.com/Magnetkopf/pGallery/utils"
 	"github.com/Magnetkopf/pGallery/web"
 )
 
 type WebUIArgs = web.ServerArgs
 
 func WebUI(args WebUIArgs) {
+	baseLock, err := utils.LockBase(args.Base, utils.LockShared, "webui")
+	if err != nil {
+		log.Fatalf("Cannot start web UI: %v", err)
+	}
+	defer baseLock.Release()
+
 	web.Start(args)
 }
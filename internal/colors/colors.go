@@ -0,0 +1,208 @@
+package colors
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Magnetkopf/pGallery/internal/model"
+)
+
+// Lab is a colour in CIE L*a*b* (D65), where Euclidean distance roughly
+// follows how different two colours look
+type Lab struct {
+	L, A, B float64
+}
+
+// paletteSamples is the side of the grid of pixels k-means runs on
+const paletteSamples = 64
+
+// Palette returns up to k dominant colours of img, largest share first. It
+// runs k-means in Lab space on a grid of sampled pixels; the seed is fixed, so
+// the same image always gives the same palette.
+func Palette(img image.Image, k int) []model.Swatch {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 || k < 1 {
+		return nil
+	}
+
+	var points []Lab
+	for sy := 0; sy < min(h, paletteSamples); sy++ {
+		y := bounds.Min.Y + sy*h/min(h, paletteSamples)
+		for sx := 0; sx < min(w, paletteSamples); sx++ {
+			x := bounds.Min.X + sx*w/min(w, paletteSamples)
+			r, g, b, a := img.At(x, y).RGBA()
+			if a < 0x8000 { // mostly transparent
+				continue
+			}
+			points = append(points, FromRGB(uint8(r>>8), uint8(g>>8), uint8(b>>8)))
+		}
+	}
+	if len(points) == 0 {
+		return nil
+	}
+	k = min(k, len(points))
+
+	centers := seedCenters(points, k, rand.New(rand.NewSource(1)))
+	assignment := make([]int, len(points))
+	for iteration := 0; iteration < 20; iteration++ {
+		changed := false
+		for i, p := range points {
+			best := nearest(p, centers)
+			if best != assignment[i] || iteration == 0 {
+				changed = changed || best != assignment[i]
+				assignment[i] = best
+			}
+		}
+
+		sums := make([]Lab, len(centers))
+		counts := make([]int, len(centers))
+		for i, p := range points {
+			c := assignment[i]
+			sums[c].L += p.L
+			sums[c].A += p.A
+			sums[c].B += p.B
+			counts[c]++
+		}
+		for c := range centers {
+			if counts[c] > 0 {
+				n := float64(counts[c])
+				centers[c] = Lab{sums[c].L / n, sums[c].A / n, sums[c].B / n}
+			}
+		}
+		if !changed && iteration > 0 {
+			break
+		}
+	}
+
+	counts := make([]int, len(centers))
+	for _, c := range assignment {
+		counts[c]++
+	}
+	var palette []model.Swatch
+	for c, center := range centers {
+		share := float64(counts[c]) / float64(len(points))
+		if share < 0.01 {
+			continue
+		}
+		palette = append(palette, model.Swatch{Hex: center.Hex(), Share: math.Round(share*1000) / 1000})
+	}
+	sort.Slice(palette, func(i, j int) bool {
+		return palette[i].Share > palette[j].Share
+	})
+	return palette
+}
+
+// seedCenters picks k starting centres the k-means++ way: each one far from
+// those already picked
+func seedCenters(points []Lab, k int, rng *rand.Rand) []Lab {
+	centers := []Lab{points[rng.Intn(len(points))]}
+	distances := make([]float64, len(points))
+	for len(centers) < k {
+		total := 0.0
+		for i, p := range points {
+			d := Distance(p, centers[nearest(p, centers)])
+			distances[i] = d * d
+			total += distances[i]
+		}
+		if total == 0 {
+			break // fewer distinct colours than k
+		}
+		target := rng.Float64() * total
+		for i, d := range distances {
+			target -= d
+			if target <= 0 {
+				centers = append(centers, points[i])
+				break
+			}
+		}
+	}
+	return centers
+}
+
+func nearest(p Lab, centers []Lab) int {
+	best, bestDistance := 0, math.MaxFloat64
+	for i, c := range centers {
+		if d := Distance(p, c); d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+	return best
+}
+
+// Distance is the CIE76 colour difference ΔE*ab; around 2.3 is just noticeable
+func Distance(x, y Lab) float64 {
+	return math.Sqrt((x.L-y.L)*(x.L-y.L) + (x.A-y.A)*(x.A-y.A) + (x.B-y.B)*(x.B-y.B))
+}
+
+// FromRGB converts an sRGB colour to Lab
+func FromRGB(r, g, b uint8) Lab {
+	lr, lg, lb := linear(r), linear(g), linear(b)
+	x := (0.4124*lr + 0.3576*lg + 0.1805*lb) / 0.95047
+	y := 0.2126*lr + 0.7152*lg + 0.0722*lb
+	z := (0.0193*lr + 0.1192*lg + 0.9505*lb) / 1.08883
+
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return Lab{L: 116*fy - 16, A: 500 * (fx - fy), B: 200 * (fy - fz)}
+}
+
+// ParseHex reads "#rrggbb" (the # is optional) into Lab
+func ParseHex(hex string) (Lab, error) {
+	hex = strings.TrimPrefix(hex, "#")
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return Lab{}, fmt.Errorf("invalid colour %q, expected #rrggbb", hex)
+	}
+	return FromRGB(uint8(value>>16), uint8(value>>8), uint8(value)), nil
+}
+
+// Hex converts back to "#rrggbb"
+func (c Lab) Hex() string {
+	fy := (c.L + 16) / 116
+	fx := fy + c.A/500
+	fz := fy - c.B/200
+	x := 0.95047 * labFInverse(fx)
+	y := labFInverse(fy)
+	z := 1.08883 * labFInverse(fz)
+
+	r := 3.2406*x - 1.5372*y - 0.4986*z
+	g := -0.9689*x + 1.8758*y + 0.0415*z
+	b := 0.0557*x - 0.2040*y + 1.0570*z
+	return fmt.Sprintf("#%02x%02x%02x", gamma(r), gamma(g), gamma(b))
+}
+
+func linear(c uint8) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func gamma(v float64) uint8 {
+	if v <= 0.0031308 {
+		v *= 12.92
+	} else {
+		v = 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	return uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+}
+
+func labF(t float64) float64 {
+	if t > 216.0/24389 {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27*t + 16) / 116
+}
+
+func labFInverse(t float64) float64 {
+	if t*t*t > 216.0/24389 {
+		return t * t * t
+	}
+	return (116*t - 16) / (24389.0 / 27)
+}
@@ -0,0 +1,88 @@
+package colors
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestHexRoundTrip(t *testing.T) {
+	for _, hex := range []string{"#000000", "#ffffff", "#ff0000", "#00ff00", "#0000ff", "#808080", "#e4007f", "#123456", "#fedcba"} {
+		lab, err := ParseHex(hex)
+		if err != nil {
+			t.Errorf("ParseHex(%q): %v", hex, err)
+			continue
+		}
+		if got := lab.Hex(); got != hex {
+			t.Errorf("ParseHex(%q).Hex() = %q", hex, got)
+		}
+	}
+}
+
+func TestParseHex(t *testing.T) {
+	tests := []struct {
+		hex string
+		ok  bool
+	}{
+		{"#ff8800", true},
+		{"ff8800", true},
+		{"#FF8800", true},
+		{"#f80", false},
+		{"#ff88001", false},
+		{"#gg8800", false},
+		{"", false},
+		{"red", false},
+	}
+	for _, tt := range tests {
+		if _, err := ParseHex(tt.hex); (err == nil) != tt.ok {
+			t.Errorf("ParseHex(%q) error = %v, want ok %v", tt.hex, err, tt.ok)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	white, _ := ParseHex("#ffffff")
+	black, _ := ParseHex("#000000")
+	if d := Distance(white, black); d < 99.9 || d > 100.1 {
+		t.Errorf("Distance(white, black) = %f, want 100", d)
+	}
+	if d := Distance(white, white); d != 0 {
+		t.Errorf("Distance(white, white) = %f, want 0", d)
+	}
+}
+
+func TestPalette(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), image.NewUniform(red), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 75, 100, 100), image.NewUniform(blue), image.Point{}, draw.Src)
+
+	palette := Palette(img, 5)
+	if len(palette) != 2 {
+		t.Fatalf("Palette = %+v, want red and blue", palette)
+	}
+	if palette[0].Hex != "#ff0000" || palette[1].Hex != "#0000ff" {
+		t.Errorf("Palette = %+v, want red then blue", palette)
+	}
+	if palette[0].Share < 0.7 || palette[0].Share > 0.8 {
+		t.Errorf("red share = %f, want about 0.75", palette[0].Share)
+	}
+
+	// the seed is fixed: the same image gives the same palette
+	again := Palette(img, 5)
+	for i := range palette {
+		if again[i] != palette[i] {
+			t.Errorf("second Palette = %+v, want %+v", again, palette)
+			break
+		}
+	}
+
+	if got := Palette(image.NewNRGBA(image.Rect(0, 0, 10, 10)), 5); got != nil {
+		t.Errorf("Palette of a transparent image = %+v, want none", got)
+	}
+	if got := Palette(img, 0); got != nil {
+		t.Errorf("Palette with k 0 = %+v, want none", got)
+	}
+}
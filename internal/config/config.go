@@ -0,0 +1,272 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Magnetkopf/pGallery/internal/index"
+	"github.com/Magnetkopf/pGallery/internal/layout"
+	"github.com/Magnetkopf/pGallery/internal/pixiv"
+	"gopkg.in/yaml.v3"
+)
+
+const FileName = "pgallery.yaml"
+
+// Filters decide which bookmarked artworks sync downloads
+type Filters struct {
+	IncludeTags []string `yaml:"include_tags,omitempty"`
+	ExcludeTags []string `yaml:"exclude_tags,omitempty"`
+}
+
+// Allow reports whether an artwork with the given tags passes the filters
+func (f Filters) Allow(tags []string) bool {
+	tagSet := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tagSet[tag] = true
+	}
+
+	for _, tag := range f.ExcludeTags {
+		if tagSet[tag] {
+			return false
+		}
+	}
+
+	if len(f.IncludeTags) == 0 {
+		return true
+	}
+	for _, tag := range f.IncludeTags {
+		if tagSet[tag] {
+			return true
+		}
+	}
+	return false
+}
+
+// Account is one pixiv user whose bookmarks feed the library
+type Account struct {
+	UserID string `yaml:"user"`
+	Cookie string `yaml:"cookie,omitempty"`
+}
+
+type Profile struct {
+	UserID      string    `yaml:"user,omitempty"`
+	Cookie      string    `yaml:"cookie,omitempty"`
+	Accounts    []Account `yaml:"accounts,omitempty"`
+	Base        string    `yaml:"base,omitempty"`
+	Downloader  string    `yaml:"downloader,omitempty"`
+	Concurrency int       `yaml:"concurrency,omitempty"`
+	Port        int       `yaml:"port,omitempty"`
+	Interval    string    `yaml:"interval,omitempty"`
+	Filters     Filters   `yaml:"filters,omitempty"`
+	// Index is the backend build writes the index to, see index.Backends
+	Index string `yaml:"index,omitempty"`
+	// Layout holds the directory templates for new downloads
+	Layout layout.Layout `yaml:"layout,omitempty"`
+}
+
+// Default holds the values used when neither the profile nor a flag sets them
+var Default = Profile{
+	Cookie:      "cookie.txt",
+	Base:        "downloads",
+	Concurrency: 5,
+	Port:        8080,
+	Interval:    "6h",
+	Layout:      layout.Default,
+}
+
+type File struct {
+	Default  string             `yaml:"default,omitempty"`
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// Resolved is a merged profile together with where it came from
+type Resolved struct {
+	Profile
+	Name   string `yaml:"-"`
+	Source string `yaml:"-"`
+}
+
+// Merge returns p with every field that is set in over replaced
+func (p Profile) Merge(over Profile) Profile {
+	if over.UserID != "" {
+		p.UserID = over.UserID
+	}
+	if over.Cookie != "" {
+		p.Cookie = over.Cookie
+	}
+	if len(over.Accounts) > 0 {
+		p.Accounts = over.Accounts
+	}
+	if over.Base != "" {
+		p.Base = over.Base
+	}
+	if over.Downloader != "" {
+		p.Downloader = over.Downloader
+	}
+	if over.Concurrency != 0 {
+		p.Concurrency = over.Concurrency
+	}
+	if over.Port != 0 {
+		p.Port = over.Port
+	}
+	if over.Interval != "" {
+		p.Interval = over.Interval
+	}
+	if over.Index != "" {
+		p.Index = over.Index
+	}
+	if len(over.Filters.IncludeTags) > 0 {
+		p.Filters.IncludeTags = over.Filters.IncludeTags
+	}
+	if len(over.Filters.ExcludeTags) > 0 {
+		p.Filters.ExcludeTags = over.Filters.ExcludeTags
+	}
+	p.Layout = p.Layout.Merge(over.Layout)
+	return p
+}
+
+// Set assigns a field by its command line flag name
+func (p *Profile) Set(flagName, value string) error {
+	switch flagName {
+	case "user":
+		p.UserID = value
+	case "cookie":
+		p.Cookie = value
+	case "base":
+		p.Base = value
+	case "downloader":
+		p.Downloader = value
+	case "concurrency":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid concurrency %q: %w", value, err)
+		}
+		p.Concurrency = n
+	case "port":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid port %q: %w", value, err)
+		}
+		p.Port = n
+	case "interval":
+		p.Interval = value
+	case "index":
+		if err := index.Validate(value); err != nil {
+			return err
+		}
+		p.Index = value
+	case "layout":
+		p.Layout.Artwork = value
+	case "artist-layout":
+		p.Layout.Artist = value
+	}
+	return nil
+}
+
+// AllAccounts lists the accounts to sync: the single user/cookie pair
+// followed by any extra accounts. Accounts without a cookie use the
+// profile's cookie.
+func (p Profile) AllAccounts() []Account {
+	var accounts []Account
+	seen := make(map[string]bool)
+	if p.UserID != "" {
+		accounts = append(accounts, Account{UserID: p.UserID, Cookie: p.Cookie})
+		seen[p.UserID] = true
+	}
+	for _, account := range p.Accounts {
+		if account.UserID == "" || seen[account.UserID] {
+			continue
+		}
+		if account.Cookie == "" {
+			account.Cookie = p.Cookie
+		}
+		accounts = append(accounts, account)
+		seen[account.UserID] = true
+	}
+	return accounts
+}
+
+// SearchPaths lists where the config file is looked for, in order
+func SearchPaths(base string) []string {
+	if base == "" {
+		base = Default.Base
+	}
+	paths := []string{filepath.Join(base, FileName)}
+	if dir, err := os.UserConfigDir(); err == nil { // honours $XDG_CONFIG_HOME
+		paths = append(paths, filepath.Join(dir, "pGallery", FileName))
+	}
+	return paths
+}
+
+// Load finds the config file and returns the named profile merged over the
+// defaults. An empty name selects the file's default profile, if any.
+// Relative paths in the profile are taken relative to the config file.
+func Load(name string, base string) (Resolved, error) {
+	resolved := Resolved{Profile: Default}
+
+	var file File
+	for _, path := range SearchPaths(base) {
+		content, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return resolved, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if err := yaml.Unmarshal(content, &file); err != nil {
+			return resolved, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		resolved.Source = path
+		break
+	}
+
+	if name == "" {
+		name = file.Default
+	}
+	if name == "" {
+		return resolved, nil
+	}
+
+	profile, ok := file.Profiles[name]
+	if !ok {
+		if resolved.Source == "" {
+			return resolved, fmt.Errorf("profile %q requested but no %s found (searched %v)", name, FileName, SearchPaths(base))
+		}
+		return resolved, fmt.Errorf("profile %q not found in %s", name, resolved.Source)
+	}
+
+	if profile.Index != "" {
+		if err := index.Validate(profile.Index); err != nil {
+			return resolved, fmt.Errorf("profile %q in %s: %w", name, resolved.Source, err)
+		}
+	}
+
+	configDir := filepath.Dir(resolved.Source)
+	profile.Cookie = cookieRelativeTo(configDir, profile.Cookie)
+	profile.Base = relativeTo(configDir, profile.Base)
+	for i := range profile.Accounts {
+		profile.Accounts[i].Cookie = cookieRelativeTo(configDir, profile.Accounts[i].Cookie)
+	}
+
+	resolved.Name = name
+	resolved.Profile = resolved.Profile.Merge(profile)
+	return resolved, nil
+}
+
+func relativeTo(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// cookieRelativeTo is relativeTo for cookie sources; env:NAME and - stay as they are
+func cookieRelativeTo(dir, source string) string {
+	if !pixiv.IsCookieFile(source) {
+		return source
+	}
+	return relativeTo(dir, source)
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCookieRelativeTo(t *testing.T) {
+	dir := filepath.FromSlash("/etc/pgallery")
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"", ""},
+		{"cookie.txt", filepath.Join(dir, "cookie.txt")},
+		{"cookies/me.json", filepath.Join(dir, "cookies/me.json")},
+		{filepath.FromSlash("/abs/cookie.txt"), filepath.FromSlash("/abs/cookie.txt")},
+		{"env:PIXIV_COOKIE", "env:PIXIV_COOKIE"},
+		{"-", "-"},
+	}
+	for _, tt := range tests {
+		if got := cookieRelativeTo(dir, tt.source); got != tt.want {
+			t.Errorf("cookieRelativeTo(%q) = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+}
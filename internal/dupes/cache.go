@@ -0,0 +1,159 @@
+package dupes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Magnetkopf/pGallery/utils"
+)
+
+// IndexFile is the perceptual-hash index of every page, kept by build next to
+// index.json; relative to base
+const IndexFile = "phash.json"
+
+// legacyHashFile is where IndexFile used to be written
+const legacyHashFile = ".cache/phash.json"
+
+// Page is one downloaded page; Path is relative to base
+type Page struct {
+	Artwork int
+	Page    int
+	Path    string
+}
+
+// PageHash is the cached hash of a page. A page is hashed again when its
+// size or modification time changes.
+type PageHash struct {
+	Artwork int       `json:"artwork"`
+	Page    int       `json:"page"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hash    string    `json:"hash,omitempty"`  // dHash as 16 hex digits
+	Crops   []string  `json:"crops,omitempty"` // CropHashes, same format
+	Error   string    `json:"error,omitempty"` // why the page could not be hashed
+}
+
+// Value returns the hash as a number; ok is false for pages that failed
+func (h PageHash) Value() (hash uint64, ok bool) {
+	if h.Hash == "" {
+		return 0, false
+	}
+	hash, err := strconv.ParseUint(h.Hash, 16, 64)
+	return hash, err == nil
+}
+
+// CropValues returns the crop hashes as numbers
+func (h PageHash) CropValues() []uint64 {
+	var hashes []uint64
+	for _, crop := range h.Crops {
+		if hash, err := strconv.ParseUint(crop, 16, 64); err == nil {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes
+}
+
+// LoadHashes reads the hash index, or where an older build left it; a
+// missing index is empty
+func LoadHashes(base string) ([]PageHash, error) {
+	content, err := os.ReadFile(filepath.Join(base, IndexFile))
+	if os.IsNotExist(err) {
+		content, err = os.ReadFile(filepath.Join(base, legacyHashFile))
+	}
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var hashes []PageHash
+	if err := json.Unmarshal(content, &hashes); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", IndexFile, err)
+	}
+	return hashes, nil
+}
+
+// UpdateHashes hashes the pages that are new or changed since the last run,
+// drops pages that are gone and writes the cache. Pages are hashed on up to
+// workers goroutines (0 for one per CPU). It returns how many pages were
+// hashed.
+func UpdateHashes(base string, pages []Page, workers int) (int, error) {
+	cached, err := LoadHashes(base)
+	if err != nil {
+		log.Printf("Warning: %v, hashing every page again", err)
+		cached = nil
+	}
+	byPath := make(map[string]PageHash, len(cached))
+	for _, hash := range cached {
+		byPath[hash.Path] = hash
+	}
+
+	results := make([]*PageHash, len(pages)) // nil for pages that are gone
+	fresh := make([]bool, len(pages))
+	utils.Parallel(len(pages), workers, func(i int) {
+		page := pages[i]
+		fullPath := filepath.Join(base, filepath.FromSlash(page.Path))
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			return
+		}
+
+		// entries from before crop hashes existed are hashed again once
+		if old, ok := byPath[page.Path]; ok && old.Size == info.Size() && old.ModTime.Equal(info.ModTime()) &&
+			(old.Crops != nil || old.Error != "") {
+			old.Artwork, old.Page = page.Artwork, page.Page
+			results[i] = &old
+			return
+		}
+
+		entry := PageHash{
+			Artwork: page.Artwork,
+			Page:    page.Page,
+			Path:    page.Path,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+		if img, err := decode(fullPath); err != nil {
+			entry.Error = err.Error()
+		} else {
+			entry.Hash = fmt.Sprintf("%016x", DHash(img))
+			for _, crop := range CropHashes(img) {
+				entry.Crops = append(entry.Crops, fmt.Sprintf("%016x", crop))
+			}
+		}
+		results[i], fresh[i] = &entry, true
+	})
+
+	hashes := make([]PageHash, 0, len(pages))
+	hashed := 0
+	for i, result := range results {
+		if result == nil {
+			continue
+		}
+		hashes = append(hashes, *result)
+		if fresh[i] {
+			hashed++
+		}
+	}
+
+	hashPath := filepath.Join(base, IndexFile)
+	content, err := json.MarshalIndent(hashes, "", "  ")
+	if err != nil {
+		return hashed, err
+	}
+	tmpPath := hashPath + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		return hashed, err
+	}
+	if err := os.Rename(tmpPath, hashPath); err != nil {
+		return hashed, err
+	}
+	os.Remove(filepath.Join(base, legacyHashFile))
+	return hashed, nil
+}
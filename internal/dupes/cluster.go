@@ -0,0 +1,197 @@
+package dupes
+
+import (
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultThreshold is the largest Hamming distance between two dHashes that
+// still counts as the same picture
+const DefaultThreshold = 6
+
+// Cluster is a group of pages from different artworks that look alike
+type Cluster struct {
+	Pages    []PageHash
+	Artworks []int // sorted
+	Distance int   // largest distance between two linked pages
+}
+
+// Key identifies the cluster by its artworks, as used by decisions
+func (c Cluster) Key() string {
+	ids := make([]string, len(c.Artworks))
+	for i, id := range c.Artworks {
+		ids[i] = strconv.Itoa(id)
+	}
+	return strings.Join(ids, ",")
+}
+
+// FindClusters links every two pages of different artworks whose hashes are
+// at most threshold bits apart and returns the connected groups. Blank pages
+// (hash 0) are left out, they would all match each other.
+func FindClusters(hashes []PageHash, threshold int) []Cluster {
+	type node struct {
+		hash  uint64
+		entry PageHash
+	}
+	var nodes []node
+	for _, entry := range hashes {
+		if hash, ok := entry.Value(); ok && hash != 0 {
+			nodes = append(nodes, node{hash: hash, entry: entry})
+		}
+	}
+
+	parent := make([]int, len(nodes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	hashValues := make([]uint64, len(nodes))
+	for i, n := range nodes {
+		hashValues[i] = n.hash
+	}
+
+	distance := make(map[int]int) // root -> largest linked distance
+	forEachPair(hashValues, threshold, func(i, j, d int) {
+		if nodes[i].entry.Artwork == nodes[j].entry.Artwork {
+			return
+		}
+		a, b := find(i), find(j)
+		largest := max(d, distance[a], distance[b])
+		if a != b {
+			parent[b] = a
+			delete(distance, b)
+		}
+		distance[a] = largest
+	})
+
+	groups := make(map[int][]PageHash)
+	for i, n := range nodes {
+		root := find(i)
+		groups[root] = append(groups[root], n.entry)
+	}
+
+	var clusters []Cluster
+	for root, pages := range groups {
+		artworks := make(map[int]bool)
+		for _, page := range pages {
+			artworks[page.Artwork] = true
+		}
+		if len(artworks) < 2 { // a page on its own
+			continue
+		}
+
+		cluster := Cluster{Pages: pages, Distance: distance[root]}
+		for id := range artworks {
+			cluster.Artworks = append(cluster.Artworks, id)
+		}
+		sort.Ints(cluster.Artworks)
+		sort.Slice(cluster.Pages, func(i, j int) bool {
+			if cluster.Pages[i].Artwork != cluster.Pages[j].Artwork {
+				return cluster.Pages[i].Artwork < cluster.Pages[j].Artwork
+			}
+			return cluster.Pages[i].Page < cluster.Pages[j].Page
+		})
+		clusters = append(clusters, cluster)
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Artworks[0] < clusters[j].Artworks[0]
+	})
+	return clusters
+}
+
+// hashBlocks is how many 16 bit blocks forEachPair splits a hash into
+const hashBlocks = 4
+
+// forEachPair calls fn once for every i < j whose hashes are at most
+// threshold bits apart. It uses multi-index hashing rather than comparing
+// every pair: two hashes that close differ in at most threshold/hashBlocks
+// bits in one of their blocks, so only hashes sharing a block within that
+// distance are compared.
+func forEachPair(hashes []uint64, threshold int, fn func(i, j, d int)) {
+	radius := threshold / hashBlocks
+	if radius >= 4 {
+		// by then nearly every block is a neighbour, comparing all is cheaper
+		for i := range hashes {
+			for j := i + 1; j < len(hashes); j++ {
+				if d := Distance(hashes[i], hashes[j]); d <= threshold {
+					fn(i, j, d)
+				}
+			}
+		}
+		return
+	}
+
+	// per block, the hashes sorted by the block's value: those with value v
+	// are ids[starts[v]:starts[v+1]]
+	type table struct {
+		starts []int32
+		ids    []int32
+	}
+	var tables [hashBlocks]table
+	for b := range tables {
+		starts := make([]int32, 1<<16+1)
+		for _, hash := range hashes {
+			starts[int(block(hash, b))+1]++
+		}
+		for v := 1; v < len(starts); v++ {
+			starts[v] += starts[v-1]
+		}
+		ids := make([]int32, len(hashes))
+		next := append([]int32(nil), starts[:1<<16]...)
+		for i, hash := range hashes {
+			key := block(hash, b)
+			ids[next[key]] = int32(i)
+			next[key]++
+		}
+		tables[b] = table{starts: starts, ids: ids}
+	}
+	masks := flipMasks(radius)
+
+	// seen[j] == i when j was already compared with i
+	seen := make([]int32, len(hashes))
+	for j := range seen {
+		seen[j] = -1
+	}
+	for i, hash := range hashes {
+		for b, t := range tables {
+			key := block(hash, b)
+			for _, mask := range masks {
+				v := key ^ mask
+				for _, j := range t.ids[t.starts[v]:t.starts[int(v)+1]] {
+					if int(j) <= i || seen[j] == int32(i) {
+						continue
+					}
+					seen[j] = int32(i)
+					if d := Distance(hash, hashes[j]); d <= threshold {
+						fn(i, int(j), d)
+					}
+				}
+			}
+		}
+	}
+}
+
+func block(hash uint64, b int) uint16 {
+	return uint16(hash >> (16 * b))
+}
+
+// flipMasks returns every 16 bit mask with at most radius bits set
+func flipMasks(radius int) []uint16 {
+	var masks []uint16
+	for mask := 0; mask <= 0xffff; mask++ {
+		if bits.OnesCount16(uint16(mask)) <= radius {
+			masks = append(masks, uint16(mask))
+		}
+	}
+	return masks
+}
@@ -0,0 +1,109 @@
+package dupes
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+// flip returns hash with n bits flipped, spread over the blocks as evenly as
+// possible, which is the hardest case for forEachPair
+func flip(hash uint64, n int) uint64 {
+	for i := range n {
+		b := i % hashBlocks
+		hash ^= 1 << (16*b + i/hashBlocks)
+	}
+	return hash
+}
+
+func TestForEachPair(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	var hashes []uint64
+	for range 40 {
+		hash := rng.Uint64()
+		hashes = append(hashes, hash)
+		for n := range 22 {
+			hashes = append(hashes, flip(hash, n))
+		}
+	}
+
+	// radius 0 to 3 use the index, 16 and up compare every pair
+	for threshold := range 21 {
+		want := make(map[[2]int]int)
+		for i := range hashes {
+			for j := i + 1; j < len(hashes); j++ {
+				if d := Distance(hashes[i], hashes[j]); d <= threshold {
+					want[[2]int{i, j}] = d
+				}
+			}
+		}
+		got := make(map[[2]int]int)
+		forEachPair(hashes, threshold, func(i, j, d int) {
+			if _, ok := got[[2]int{i, j}]; ok {
+				t.Errorf("threshold %d: pair %d,%d reported twice", threshold, i, j)
+			}
+			got[[2]int{i, j}] = d
+		})
+		if len(got) != len(want) {
+			t.Errorf("threshold %d: %d pairs, want %d", threshold, len(got), len(want))
+		}
+		for pair, d := range want {
+			if got[pair] != d {
+				t.Errorf("threshold %d: pair %v at distance %d not reported", threshold, pair, d)
+				break
+			}
+		}
+	}
+}
+
+func pageHash(artwork int, hash uint64) PageHash {
+	return PageHash{Artwork: artwork, Hash: fmt.Sprintf("%016x", hash)}
+}
+
+func TestFindClusters(t *testing.T) {
+	const hash = 0x0123456789abcdef
+	tests := []struct {
+		name      string
+		hashes    []PageHash
+		threshold int
+		want      []string // cluster keys
+		distance  int      // of the first cluster
+	}{
+		{"identical", []PageHash{pageHash(1, hash), pageHash(2, hash)}, 0, []string{"1,2"}, 0},
+		{"one bit at 0", []PageHash{pageHash(1, hash), pageHash(2, flip(hash, 1))}, 0, nil, 0},
+		{"at the threshold", []PageHash{pageHash(1, hash), pageHash(2, flip(hash, 6))}, 6, []string{"1,2"}, 6},
+		{"one over", []PageHash{pageHash(1, hash), pageHash(2, flip(hash, 7))}, 6, nil, 0},
+		{"at 15", []PageHash{pageHash(1, hash), pageHash(2, flip(hash, 15))}, 15, []string{"1,2"}, 15},
+		{"one over 15", []PageHash{pageHash(1, hash), pageHash(2, flip(hash, 16))}, 15, nil, 0},
+		{"at 16, all pairs", []PageHash{pageHash(1, hash), pageHash(2, flip(hash, 16))}, 16, []string{"1,2"}, 16},
+		{"one over 16", []PageHash{pageHash(1, hash), pageHash(2, flip(hash, 17))}, 16, nil, 0},
+		{"same artwork", []PageHash{pageHash(1, hash), pageHash(1, hash)}, 6, nil, 0},
+		{"blank pages", []PageHash{pageHash(1, 0), pageHash(2, 0)}, 6, nil, 0},
+		{"unhashed pages", []PageHash{{Artwork: 1}, {Artwork: 2}}, 6, nil, 0},
+		{
+			// 1 and 3 are 8 apart, linked through 2
+			"chain",
+			[]PageHash{pageHash(1, hash), pageHash(2, flip(hash, 4)), pageHash(3, flip(hash, 8))},
+			4, []string{"1,2,3"}, 4,
+		},
+		{
+			"two clusters",
+			[]PageHash{pageHash(1, hash), pageHash(2, hash), pageHash(3, ^uint64(hash)), pageHash(4, flip(^uint64(hash), 2))},
+			2, []string{"1,2", "3,4"}, 0,
+		},
+	}
+	for _, tt := range tests {
+		clusters := FindClusters(tt.hashes, tt.threshold)
+		var keys []string
+		for _, cluster := range clusters {
+			keys = append(keys, cluster.Key())
+		}
+		if fmt.Sprint(keys) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: clusters = %q, want %q", tt.name, keys, tt.want)
+			continue
+		}
+		if len(clusters) > 0 && clusters[0].Distance != tt.distance {
+			t.Errorf("%s: distance = %d, want %d", tt.name, clusters[0].Distance, tt.distance)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package dupes
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DecisionsFile records the reviewed clusters, relative to base
+const DecisionsFile = "dupes.json"
+
+// Decision is the outcome of reviewing a cluster. Keeper is the artwork to
+// keep, the others are duplicates of it; a Keeper of 0 means the artworks
+// are not duplicates after all.
+type Decision struct {
+	Artworks []int     `json:"artworks"`
+	Keeper   int       `json:"keeper"`
+	Decided  time.Time `json:"decided"`
+}
+
+// LoadDecisions reads dupes.json; a missing file means nothing was reviewed
+func LoadDecisions(base string) ([]Decision, error) {
+	content, err := os.ReadFile(filepath.Join(base, DecisionsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var decisions []Decision
+	if err := json.Unmarshal(content, &decisions); err != nil {
+		return nil, err
+	}
+	return decisions, nil
+}
+
+func SaveDecisions(base string, decisions []Decision) error {
+	content, err := json.MarshalIndent(decisions, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(base, DecisionsFile)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Reviewed reports whether a decision already covers every artwork of c
+func Reviewed(decisions []Decision, c Cluster) bool {
+	for _, decision := range decisions {
+		covered := make(map[int]bool, len(decision.Artworks))
+		for _, id := range decision.Artworks {
+			covered[id] = true
+		}
+		all := true
+		for _, id := range c.Artworks {
+			if !covered[id] {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
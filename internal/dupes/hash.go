@@ -0,0 +1,119 @@
+package dupes
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"os"
+)
+
+// maxSamples caps how many pixels per axis are looked at; a dHash only needs
+// a 9x8 average, sampling a large original in full adds nothing
+const maxSamples = 512
+
+// cropFraction is how much of the width and height each crop hash keeps
+const cropFraction = 0.7
+
+// HashFile decodes the image at path and returns its dHash
+func HashFile(path string) (uint64, error) {
+	img, err := decode(path)
+	if err != nil {
+		return 0, err
+	}
+	return DHash(img), nil
+}
+
+func decode(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return img, nil
+}
+
+// CropHashes are the dHashes of the centre and the four corners of img, each
+// cropFraction of its size. A cropped copy of a page seldom hashes close to
+// the whole page, but often to one of these.
+func CropHashes(img image.Image) []uint64 {
+	bounds := img.Bounds()
+	w := int(float64(bounds.Dx()) * cropFraction)
+	h := int(float64(bounds.Dy()) * cropFraction)
+	if w == 0 || h == 0 {
+		return nil
+	}
+	origins := []image.Point{
+		{bounds.Min.X + (bounds.Dx()-w)/2, bounds.Min.Y + (bounds.Dy()-h)/2},
+		{bounds.Min.X, bounds.Min.Y},
+		{bounds.Max.X - w, bounds.Min.Y},
+		{bounds.Min.X, bounds.Max.Y - h},
+		{bounds.Max.X - w, bounds.Max.Y - h},
+	}
+	hashes := make([]uint64, len(origins))
+	for i, origin := range origins {
+		hashes[i] = DHash(cropped{img, image.Rectangle{Min: origin, Max: origin.Add(image.Pt(w, h))}})
+	}
+	return hashes
+}
+
+// cropped shows only part of an image; not every image.Image has SubImage
+type cropped struct {
+	image.Image
+	rect image.Rectangle
+}
+
+func (c cropped) Bounds() image.Rectangle {
+	return c.rect
+}
+
+// DHash is a difference hash: the image is shrunk to 9x8 grey cells and each
+// bit tells whether a cell is darker than its right neighbour. Resizing,
+// recompression and small edits change few bits.
+func DHash(img image.Image) uint64 {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return 0
+	}
+
+	stepX := max(1, width/maxSamples)
+	stepY := max(1, height/maxSamples)
+
+	var sums [8][9]float64
+	var counts [8][9]int
+	for y := 0; y < height; y += stepY {
+		cellY := y * 8 / height
+		for x := 0; x < width; x += stepX {
+			cellX := x * 9 / width
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			sums[cellY][cellX] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			counts[cellY][cellX]++
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := sums[y][x] / float64(max(1, counts[y][x]))
+			right := sums[y][x+1] / float64(max(1, counts[y][x+1]))
+			hash <<= 1
+			if left < right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// Distance is the number of differing bits between two hashes
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,58 @@
+package dupes
+
+import (
+	"image"
+	"sort"
+)
+
+// DefaultSearchDistance is the largest distance Search reports by default;
+// looser than DefaultThreshold since a query is often cropped or edited
+const DefaultSearchDistance = 16
+
+// Match is a library page found for a query image
+type Match struct {
+	PageHash
+	Distance   int     // bits between the query and the page or its closest crop
+	Similarity float64 // 1 - Distance/64
+	Cropped    bool    // the query matched a crop better than the whole page
+}
+
+// Search compares query with every hashed page and returns the limit closest
+// pages, most similar first. Pages more than maxDistance bits away are left
+// out.
+func Search(hashes []PageHash, query image.Image, maxDistance, limit int) []Match {
+	queryHash := DHash(query)
+
+	var matches []Match
+	for _, entry := range hashes {
+		hash, ok := entry.Value()
+		if !ok || hash == 0 {
+			continue
+		}
+		match := Match{PageHash: entry, Distance: Distance(queryHash, hash)}
+		for _, crop := range entry.CropValues() {
+			if d := Distance(queryHash, crop); d < match.Distance {
+				match.Distance, match.Cropped = d, true
+			}
+		}
+		if match.Distance > maxDistance {
+			continue
+		}
+		match.Similarity = 1 - float64(match.Distance)/64
+		matches = append(matches, match)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		if matches[i].Artwork != matches[j].Artwork {
+			return matches[i].Artwork < matches[j].Artwork
+		}
+		return matches[i].Page < matches[j].Page
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
@@ -0,0 +1,150 @@
+package filter
+
+import (
+	"fmt"
+
+	"github.com/Magnetkopf/pGallery/internal/index"
+	"github.com/Magnetkopf/pGallery/internal/search"
+)
+
+type idSet map[string]struct{}
+
+func newSet(ids []string) idSet {
+	set := make(idSet, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	return set
+}
+
+type evaluator struct {
+	idx  index.Index
+	text *search.Index
+	all  idSet // every artwork, read once the first NOT needs it
+}
+
+// Evaluate returns the IDs of the artworks in idx that match expr, in no
+// particular order. text answers the words and phrases; it may be nil when
+// NeedsText is false.
+func Evaluate(expr Expr, idx index.Index, text *search.Index) ([]string, error) {
+	if text == nil && NeedsText(expr) {
+		return nil, fmt.Errorf("no search index to look up words in, run build")
+	}
+	set, err := expr.eval(&evaluator{idx: idx, text: text})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (e *evaluator) universe() (idSet, error) {
+	if e.all == nil {
+		ids, err := e.idx.Find(index.Query{})
+		if err != nil {
+			return nil, err
+		}
+		e.all = newSet(ids)
+	}
+	return e.all, nil
+}
+
+func (x andExpr) eval(e *evaluator) (idSet, error) {
+	// "a -b" takes b away from a rather than intersecting with all but b
+	left, right := x.left, x.right
+	if _, ok := left.(notExpr); ok {
+		left, right = right, left
+	}
+	leftSet, err := left.eval(e)
+	if err != nil || len(leftSet) == 0 {
+		return leftSet, err
+	}
+
+	if not, ok := right.(notExpr); ok {
+		excluded, err := not.expr.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		result := make(idSet)
+		for id := range leftSet {
+			if _, ok := excluded[id]; !ok {
+				result[id] = struct{}{}
+			}
+		}
+		return result, nil
+	}
+
+	rightSet, err := right.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if len(rightSet) < len(leftSet) {
+		leftSet, rightSet = rightSet, leftSet
+	}
+	result := make(idSet)
+	for id := range leftSet {
+		if _, ok := rightSet[id]; ok {
+			result[id] = struct{}{}
+		}
+	}
+	return result, nil
+}
+
+func (x orExpr) eval(e *evaluator) (idSet, error) {
+	leftSet, err := x.left.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	rightSet, err := x.right.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	result := make(idSet, len(leftSet)+len(rightSet))
+	for id := range leftSet {
+		result[id] = struct{}{}
+	}
+	for id := range rightSet {
+		result[id] = struct{}{}
+	}
+	return result, nil
+}
+
+func (x notExpr) eval(e *evaluator) (idSet, error) {
+	excluded, err := x.expr.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	all, err := e.universe()
+	if err != nil {
+		return nil, err
+	}
+	result := make(idSet)
+	for id := range all {
+		if _, ok := excluded[id]; !ok {
+			result[id] = struct{}{}
+		}
+	}
+	return result, nil
+}
+
+func (x fieldExpr) eval(e *evaluator) (idSet, error) {
+	if x.none {
+		return idSet{}, nil
+	}
+	ids, err := e.idx.Find(x.query)
+	if err != nil {
+		return nil, err
+	}
+	return newSet(ids), nil
+}
+
+func (x textExpr) eval(e *evaluator) (idSet, error) {
+	set := make(idSet)
+	for _, result := range e.text.Search(x.text) {
+		set[result.ID] = struct{}{}
+	}
+	return set, nil
+}
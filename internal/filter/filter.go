@@ -0,0 +1,321 @@
+// Package filter parses the search language of the web UI and the search
+// command, e.g. `tag:a (tag:b OR tag:c) -tag:d pages>5 date>=2023-01`, and
+// evaluates it against an index with set operations
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Magnetkopf/pGallery/internal/index"
+	"github.com/Magnetkopf/pGallery/internal/model"
+	"github.com/Magnetkopf/pGallery/internal/search"
+)
+
+// Expr is a parsed query
+type Expr interface {
+	// String writes the expression back in the query language
+	String() string
+	eval(e *evaluator) (idSet, error)
+}
+
+type andExpr struct{ left, right Expr }
+type orExpr struct{ left, right Expr }
+type notExpr struct{ expr Expr }
+
+// fieldExpr is one condition the index answers, like tag:a or pages>5
+type fieldExpr struct {
+	field, op, value string
+	query            index.Query
+	none             bool // matches nothing, like pages<1
+}
+
+// textExpr is a word or quoted phrase for the full-text index
+type textExpr struct{ text string }
+
+func (x andExpr) String() string { return x.left.String() + " " + x.right.String() }
+func (x orExpr) String() string  { return "(" + x.left.String() + " OR " + x.right.String() + ")" }
+func (x notExpr) String() string { return "-" + x.expr.String() }
+func (x fieldExpr) String() string {
+	return x.field + x.op + quote(x.value)
+}
+func (x textExpr) String() string { return quote(x.text) }
+
+func quote(value string) string {
+	if strings.ContainsAny(value, " \t\r\n()\"\\") || value == "AND" || value == "OR" || value == "NOT" {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// Parse reads a query. Terms next to each other must all match; OR (or |)
+// between them needs either, NOT or a leading "-" negates, and parentheses
+// group. NOT binds tighter than AND, AND tighter than OR.
+func Parse(input string) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok, ok := p.peek(); ok {
+		return nil, fmt.Errorf("unexpected %s at %d", describe(tok), tok.pos)
+	}
+	return expr, nil
+}
+
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.next >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.next], true
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.kind != tokenOr {
+			return left, nil
+		}
+		p.next++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.kind == tokenOr || tok.kind == tokenClose {
+			return left, nil
+		}
+		if tok.kind == tokenAnd {
+			p.next++
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("query ends where a term was expected")
+	}
+	p.next++
+	switch tok.kind {
+	case tokenNot:
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr}, nil
+	case tokenOpen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing, ok := p.peek(); !ok || closing.kind != tokenClose {
+			return nil, fmt.Errorf("missing ) for the ( at %d", tok.pos)
+		}
+		p.next++
+		return expr, nil
+	case tokenTerm:
+		if tok.field == "" {
+			if len(search.Tokens(tok.value)) == 0 {
+				return nil, fmt.Errorf("nothing to search for in %q at %d", tok.value, tok.pos)
+			}
+			return textExpr{tok.value}, nil
+		}
+		expr, err := newField(tok.field, tok.op, tok.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s at %d", err, tok.pos)
+		}
+		return expr, nil
+	}
+	return nil, fmt.Errorf("unexpected %s at %d", describe(tok), tok.pos)
+}
+
+func describe(tok token) string {
+	switch tok.kind {
+	case tokenAnd:
+		return "AND"
+	case tokenOr:
+		return "OR"
+	case tokenNot:
+		return "NOT"
+	case tokenOpen:
+		return "("
+	case tokenClose:
+		return ")"
+	}
+	return fmt.Sprintf("%q", tok.value)
+}
+
+// Fields lists the field names of the query language
+var Fields = []string{"tag", "artist", "bookmarked_by", "pages", "date", "rating", "ai"}
+
+// newField checks a field condition and turns it into an index query
+func newField(field, op, value string) (fieldExpr, error) {
+	x := fieldExpr{field: field, op: op, value: value}
+	equality := op == ":" || op == "="
+	if !equality && field != "pages" && field != "date" {
+		return x, fmt.Errorf("%s only takes %s:value", field, field)
+	}
+
+	switch field {
+	case "tag":
+		x.query.Tag = value
+	case "artist":
+		if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+			return x, fmt.Errorf("artist takes an artist ID, not %q", value)
+		}
+		x.query.Artist = value
+	case "bookmarked_by":
+		x.query.BookmarkedBy = value
+	case "pages":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return x, fmt.Errorf("pages takes a number, not %q", value)
+		}
+		// zero is open in index.Query, so conditions no artwork meets are kept apart
+		switch op {
+		case ":", "=":
+			x.query.MinPages, x.query.MaxPages, x.none = n, n, n < 1
+		case ">":
+			x.query.MinPages = n + 1
+		case ">=":
+			x.query.MinPages = n
+		case "<":
+			x.query.MaxPages, x.none = n-1, n <= 1
+		case "<=":
+			x.query.MaxPages, x.none = n, n < 1
+		}
+	case "date":
+		start, end, err := ParsePeriod(value)
+		if err != nil {
+			return x, fmt.Errorf("date: %w", err)
+		}
+		switch op {
+		case ":", "=":
+			x.query.CreatedFrom, x.query.CreatedTo = start, end
+		case ">":
+			x.query.CreatedFrom = end
+		case ">=":
+			x.query.CreatedFrom = start
+		case "<":
+			x.query.CreatedTo = start
+		case "<=":
+			x.query.CreatedTo = end
+		}
+	case "rating":
+		switch strings.ToLower(strings.ReplaceAll(value, "-", "")) {
+		case "safe", "general", "allages":
+			x.query.Rating = model.RatingSafe
+		case "r18":
+			x.query.Rating = model.RatingR18
+		case "r18g":
+			x.query.Rating = model.RatingR18G
+		default:
+			return x, fmt.Errorf("rating is safe, r18 or r18g, not %q", value)
+		}
+	case "ai":
+		switch strings.ToLower(value) {
+		case "yes", "true", "1":
+			x.query.AI = index.AIYes
+		case "no", "false", "0":
+			x.query.AI = index.AINo
+		default:
+			return x, fmt.Errorf("ai is yes or no, not %q", value)
+		}
+	default:
+		return x, fmt.Errorf("unknown field %q, expected one of %s", field, strings.Join(Fields, ", "))
+	}
+	return x, nil
+}
+
+// ParsePeriod reads a year, month or day (2023, 2023-01 or 2023-01-15) and
+// returns when it starts and when the next one starts, in the wall clock
+// time of index.ParseCreateDate
+func ParsePeriod(value string) (start, end time.Time, err error) {
+	for _, period := range []struct {
+		layout              string
+		years, months, days int
+	}{
+		{"2006", 1, 0, 0},
+		{"2006-01", 0, 1, 0},
+		{"2006-01-02", 0, 0, 1},
+	} {
+		if start, err = time.Parse(period.layout, value); err == nil {
+			return start, start.AddDate(period.years, period.months, period.days), nil
+		}
+	}
+	return start, end, fmt.Errorf("%q is not YYYY, YYYY-MM or YYYY-MM-DD", value)
+}
+
+// Text returns the words and phrases the query searches for, leaving out
+// negated ones, to rank and highlight the results by
+func Text(expr Expr) string {
+	var words []string
+	var walk func(expr Expr, negated bool)
+	walk = func(expr Expr, negated bool) {
+		switch x := expr.(type) {
+		case andExpr:
+			walk(x.left, negated)
+			walk(x.right, negated)
+		case orExpr:
+			walk(x.left, negated)
+			walk(x.right, negated)
+		case notExpr:
+			walk(x.expr, !negated)
+		case textExpr:
+			if !negated {
+				words = append(words, x.text)
+			}
+		}
+	}
+	walk(expr, false)
+	return strings.Join(words, " ")
+}
+
+// NeedsText reports whether evaluating expr takes the full-text index
+func NeedsText(expr Expr) bool {
+	switch x := expr.(type) {
+	case andExpr:
+		return NeedsText(x.left) || NeedsText(x.right)
+	case orExpr:
+		return NeedsText(x.left) || NeedsText(x.right)
+	case notExpr:
+		return NeedsText(x.expr)
+	case textExpr:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,301 @@
+package filter
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Magnetkopf/pGallery/internal/index"
+	"github.com/Magnetkopf/pGallery/internal/model"
+	"github.com/Magnetkopf/pGallery/internal/search"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string // the parsed expression written back
+	}{
+		{"fox", "fox"},
+		{"red fox", "red fox"},
+		{"red AND fox", "red fox"},
+		{"red OR fox", "(red OR fox)"},
+		{"red | fox", "(red OR fox)"},
+		{"a b | c", "(a b OR c)"},
+		{"a | b c", "(a OR b c)"},
+		{"a (b | c)", "a (b OR c)"},
+		{"-tag:x", "-tag:x"},
+		{"NOT tag:x", "-tag:x"},
+		{"NOT a b", "-a b"},
+		{"-(a | b)", "-(a OR b)"},
+		{`"blue sky"`, `"blue sky"`},
+		{`tag:"blue sky"`, `tag:"blue sky"`},
+		{`tag:"say \"hi\""`, `tag:"say \"hi\""`},
+		{"TAG:x", "tag:x"},
+		{"pages>=5 date<2023-01", "pages>=5 date<2023-01"},
+		{"東方 tag:風景", "東方 tag:風景"},
+		{`"OR"`, `"OR"`},
+		// only known fields take an operator, other words are searched for
+		{"Re:Zero", "Re:Zero"},
+		{"Fate:stay night", "Fate:stay night"},
+		{"color:red", "color:red"},
+		{"a<b", "a<b"},
+		{"Tag:x Re:Zero", "tag:x Re:Zero"},
+	}
+	for _, tt := range tests {
+		expr, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.input, err)
+			continue
+		}
+		if got := expr.String(); got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string // part of the error
+	}{
+		{"", "empty query"},
+		{"   ", "empty query"},
+		{"(a", "missing )"},
+		{"a)", `unexpected )`},
+		{"a |", "query ends"},
+		{"| a", "unexpected OR"},
+		{"NOT", "query ends"},
+		{"a AND", "query ends"},
+		{`"open`, "unterminated quote"},
+		{`tag:"open`, "unterminated quote"},
+		{"tag:", "missing value"},
+		{"tag>x", "tag only takes tag:value"},
+		{"artist:alice", "artist ID"},
+		{"pages:many", "pages takes a number"},
+		{"pages>-1", "pages takes a number"},
+		{"date:yesterday", "not YYYY"},
+		{"rating:pg13", "rating is safe, r18 or r18g"},
+		{"ai:maybe", "ai is yes or no"},
+		{`"!?"`, "nothing to search for"},
+		{"- a", "nothing to search for"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.input)
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error containing %q", tt.input, tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) = %v, want an error containing %q", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestNewField(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	tests := []struct {
+		input string
+		query index.Query
+		none  bool
+	}{
+		{"tag:a", index.Query{Tag: "a"}, false},
+		{"artist=12", index.Query{Artist: "12"}, false},
+		{"bookmarked_by:34", index.Query{BookmarkedBy: "34"}, false},
+		{"pages:3", index.Query{MinPages: 3, MaxPages: 3}, false},
+		{"pages>3", index.Query{MinPages: 4}, false},
+		{"pages>=3", index.Query{MinPages: 3}, false},
+		{"pages<3", index.Query{MaxPages: 2}, false},
+		{"pages<=3", index.Query{MaxPages: 3}, false},
+		{"pages:0", index.Query{}, true},
+		{"pages<1", index.Query{MaxPages: 0}, true},
+		{"pages<=0", index.Query{}, true},
+		{"date:2023", index.Query{CreatedFrom: day("2023-01-01"), CreatedTo: day("2024-01-01")}, false},
+		{"date:2023-12", index.Query{CreatedFrom: day("2023-12-01"), CreatedTo: day("2024-01-01")}, false},
+		{"date>2023-01-31", index.Query{CreatedFrom: day("2023-02-01")}, false},
+		{"date>=2023-01", index.Query{CreatedFrom: day("2023-01-01")}, false},
+		{"date<2023-01", index.Query{CreatedTo: day("2023-01-01")}, false},
+		{"date<=2023-01", index.Query{CreatedTo: day("2023-02-01")}, false},
+		{"rating:R-18", index.Query{Rating: model.RatingR18}, false},
+		{"rating:r18g", index.Query{Rating: model.RatingR18G}, false},
+		{"rating:all-ages", index.Query{Rating: model.RatingSafe}, false},
+		{"ai:yes", index.Query{AI: index.AIYes}, false},
+		{"ai:false", index.Query{AI: index.AINo}, false},
+	}
+	for _, tt := range tests {
+		expr, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.input, err)
+			continue
+		}
+		field, ok := expr.(fieldExpr)
+		if !ok {
+			t.Errorf("Parse(%q) = %T, want a field", tt.input, expr)
+			continue
+		}
+		if field.query != tt.query || field.none != tt.none {
+			t.Errorf("Parse(%q) = %+v none=%v, want %+v none=%v", tt.input, field.query, field.none, tt.query, tt.none)
+		}
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		value      string
+		start, end string
+		err        bool
+	}{
+		{"2023", "2023-01-01", "2024-01-01", false},
+		{"2023-02", "2023-02-01", "2023-03-01", false},
+		{"2024-02-29", "2024-02-29", "2024-03-01", false},
+		{"2023-12-31", "2023-12-31", "2024-01-01", false},
+		{"2023-13", "", "", true},
+		{"2023-02-30", "", "", true},
+		{"23", "", "", true},
+		{"2023/01", "", "", true},
+		{"", "", "", true},
+	}
+	for _, tt := range tests {
+		start, end, err := ParsePeriod(tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParsePeriod(%q) succeeded, want an error", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePeriod(%q): %v", tt.value, err)
+			continue
+		}
+		if got := start.Format("2006-01-02"); got != tt.start {
+			t.Errorf("ParsePeriod(%q) start = %s, want %s", tt.value, got, tt.start)
+		}
+		if got := end.Format("2006-01-02"); got != tt.end {
+			t.Errorf("ParsePeriod(%q) end = %s, want %s", tt.value, got, tt.end)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		input     string
+		text      string
+		needsText bool
+	}{
+		{"red fox", "red fox", true},
+		{"red -fox", "red", true},
+		{"-(red | fox) cat", "cat", true},
+		{"--fox", "fox", true},
+		{`"blue sky" tag:a`, "blue sky", true},
+		{"tag:a pages>2", "", false},
+		{"-fox", "", true},
+	}
+	for _, tt := range tests {
+		expr, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.input, err)
+			continue
+		}
+		if got := Text(expr); got != tt.text {
+			t.Errorf("Text(%q) = %q, want %q", tt.input, got, tt.text)
+		}
+		if got := NeedsText(expr); got != tt.needsText {
+			t.Errorf("NeedsText(%q) = %v, want %v", tt.input, got, tt.needsText)
+		}
+	}
+}
+
+// testIndex is three artworks:
+//
+//	1 "Red fox"    tags animal, R-18, 1 page, 2023-05
+//	2 "Blue sky"   tags landscape,    3 pages, 2024-01, AI
+//	3 "Red car"    tags vehicle,      5 pages, 2024-06, by artist 20
+func testIndex() (index.Index, *search.Index) {
+	cards := []*model.ArtworkCard{
+		{ID: "1", ArtistID: "10", Title: "Red fox", PageCount: 1, CreateDate: "2023-05-01T00:00:00+09:00", Rating: model.RatingR18},
+		{ID: "2", ArtistID: "10", Title: "Blue sky", PageCount: 3, CreateDate: "2024-01-10T00:00:00+09:00", Rating: model.RatingSafe, AI: true},
+		{ID: "3", ArtistID: "20", Title: "Red car", PageCount: 5, CreateDate: "2024-06-01T00:00:00+09:00", Rating: model.RatingSafe},
+	}
+	tags := map[string][]string{"1": {"animal", "R-18"}, "2": {"landscape"}, "3": {"vehicle"}}
+
+	store := &model.Store{
+		ArtworkIndex:  make(map[string]*model.ArtworkCard),
+		TagIndex:      make(map[string][]*model.ArtworkCard),
+		ArtistIndex:   make(map[string]*model.ArtistDetail),
+		BookmarkIndex: make(map[string][]*model.ArtworkCard),
+	}
+	docs := make(map[string]search.Document)
+	for _, card := range cards {
+		store.ArtworkIndex[card.ID] = card
+		for _, tag := range tags[card.ID] {
+			store.TagIndex[tag] = append(store.TagIndex[tag], card)
+		}
+		artist, ok := store.ArtistIndex[card.ArtistID]
+		if !ok {
+			artist = &model.ArtistDetail{}
+			store.ArtistIndex[card.ArtistID] = artist
+		}
+		artist.Artworks = append(artist.Artworks, card)
+		docs[card.ID] = search.Document{Title: card.Title, Tags: tags[card.ID]}
+	}
+	return index.NewMemory(store), search.Build(docs)
+}
+
+func TestEvaluate(t *testing.T) {
+	idx, text := testIndex()
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"red", []string{"1", "3"}},
+		{"red fox", []string{"1"}},
+		{"red -fox", []string{"3"}},
+		{"-fox red", []string{"3"}},
+		{"red | sky", []string{"1", "2", "3"}},
+		{"-red", []string{"2"}},
+		{"NOT (red | sky)", nil},
+		{"tag:animal | tag:vehicle", []string{"1", "3"}},
+		{"artist:10 -tag:animal", []string{"2"}},
+		{"pages>1", []string{"2", "3"}},
+		{"pages<1", nil},
+		{"pages:0 | tag:landscape", []string{"2"}},
+		{"date:2024", []string{"2", "3"}},
+		{"date<2024-01-10", []string{"1"}},
+		{"date<=2024-01-10", []string{"1", "2"}},
+		{"rating:r18", []string{"1"}},
+		{"ai:yes", []string{"2"}},
+		{"ai:no date>=2024", []string{"3"}},
+		{"tag:missing", nil},
+		{"Red:Fox", []string{"1"}},
+	}
+	for _, tt := range tests {
+		expr, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.input, err)
+			continue
+		}
+		got, err := Evaluate(expr, idx, text)
+		if err != nil {
+			t.Errorf("Evaluate(%q): %v", tt.input, err)
+			continue
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) && !(len(got) == 0 && len(tt.want) == 0) {
+			t.Errorf("Evaluate(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateWithoutText(t *testing.T) {
+	idx, _ := testIndex()
+	expr, err := Parse("red")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Evaluate(expr, idx, nil); err == nil {
+		t.Error("Evaluate of a word without a search index succeeded")
+	}
+}
@@ -0,0 +1,142 @@
+package filter
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenTerm tokenKind = iota
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+// token is one lexeme; a term has a field and an operator unless it is a
+// bare word or phrase for the full-text search
+type token struct {
+	kind  tokenKind
+	pos   int // byte offset in the query, for errors
+	field string
+	op    string
+	value string
+}
+
+// operators, longest first so ">=" isn't read as ">"
+var operators = []string{">=", "<=", ":", "=", ">", "<"}
+
+// lex splits a query into tokens. Values with spaces or parentheses are
+// quoted: tag:"blue sky". A "-" right before a term or parenthesis negates it.
+func lex(input string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(input); {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenOpen, pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenClose, pos: i})
+			i++
+		case c == '|':
+			tokens = append(tokens, token{kind: tokenOr, pos: i})
+			i++
+		case c == '-' && i+1 < len(input) && input[i+1] != ' ':
+			tokens = append(tokens, token{kind: tokenNot, pos: i})
+			i++
+		default:
+			tok, next, err := lexTerm(input, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tok)
+			i = next
+		}
+	}
+	return tokens, nil
+}
+
+// lexTerm reads a word, a quoted phrase or field, operator and value
+// starting at i and returns the index after it
+func lexTerm(input string, i int) (token, int, error) {
+	tok := token{kind: tokenTerm, pos: i}
+	if input[i] == '"' {
+		value, next, err := lexQuoted(input, i)
+		tok.value = value
+		return tok, next, err
+	}
+
+	// a field name is one of Fields followed by an operator; other words with
+	// a colon, like Re:Zero, are searched for as they are
+	j := i
+	for j < len(input) && (unicode.IsLetter(rune(input[j])) && input[j] < 0x80 || input[j] == '_') {
+		j++
+	}
+	if slices.Contains(Fields, strings.ToLower(input[i:j])) {
+		for _, op := range operators {
+			if strings.HasPrefix(input[j:], op) {
+				tok.field, tok.op = strings.ToLower(input[i:j]), op
+				j += len(op)
+				if j < len(input) && input[j] == '"' {
+					value, next, err := lexQuoted(input, j)
+					tok.value = value
+					return tok, next, err
+				}
+				value, next := lexBare(input, j)
+				if value == "" {
+					return tok, next, fmt.Errorf("missing value after %s%s at %d", tok.field, op, i)
+				}
+				tok.value = value
+				return tok, next, nil
+			}
+		}
+	}
+
+	value, next := lexBare(input, i)
+	switch value {
+	case "AND":
+		tok.kind = tokenAnd
+	case "OR":
+		tok.kind = tokenOr
+	case "NOT":
+		tok.kind = tokenNot
+	}
+	tok.value = value
+	return tok, next, nil
+}
+
+// lexBare reads up to the next space or closing parenthesis
+func lexBare(input string, i int) (string, int) {
+	j := i
+	for j < len(input) && !strings.ContainsRune(" \t\r\n)", rune(input[j])) {
+		j++
+	}
+	return input[i:j], j
+}
+
+// lexQuoted reads a double-quoted string starting at i; \" and \\ escape
+func lexQuoted(input string, i int) (string, int, error) {
+	var sb strings.Builder
+	for j := i + 1; j < len(input); j++ {
+		switch input[j] {
+		case '\\':
+			if j+1 < len(input) {
+				j++
+				sb.WriteByte(input[j])
+			}
+		case '"':
+			return sb.String(), j + 1, nil
+		default:
+			sb.WriteByte(input[j])
+		}
+	}
+	return "", len(input), fmt.Errorf("unterminated quote at %d", i)
+}
@@ -0,0 +1,481 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Magnetkopf/pGallery/internal/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltVersion is the layout of the buckets below
+const boltVersion = 2
+
+// Buckets of index.db. Artwork and artist IDs are keys of 8 big-endian bytes,
+// so keys sort numerically. The by_* buckets are indexes with empty values:
+// the key is what is looked up followed by the artwork ID.
+var (
+	metaBucket        = []byte("meta")        // schema_version, last_indexed
+	artworksBucket    = []byte("artworks")    // artwork ID -> card JSON
+	artistsBucket     = []byte("artists")     // artist ID -> boltArtist JSON
+	tagsBucket        = []byte("tags")        // tag -> artwork count
+	bookmarkersBucket = []byte("bookmarkers") // user ID -> artwork count
+	lostBucket        = []byte("lost")        // artwork ID -> LostArtwork JSON
+
+	byArtistBucket   = []byte("by_artist")   // artist ID, artwork ID
+	byTagBucket      = []byte("by_tag")      // tag, 0, artwork ID
+	byBookmarkBucket = []byte("by_bookmark") // user ID, 0, artwork ID
+	byDateBucket     = []byte("by_date")     // wall clock creation time in Unix seconds, artwork ID
+	byPagesBucket    = []byte("by_pages")    // page count (4 bytes), artwork ID
+	byRatingBucket   = []byte("by_rating")   // rating, 0, artwork ID
+	byAIBucket       = []byte("by_ai")       // AIYes or AINo, 0, artwork ID
+)
+
+type boltArtist struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Count int    `json:"count"`
+}
+
+// Database serves an index from index.db, reading only what a request needs
+type Database struct {
+	db *bolt.DB
+}
+
+func openBolt(base string) (*Database, error) {
+	path := filepath.Join(base, Filename(Bolt))
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to read index.db: %w", err)
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{ReadOnly: true, Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index.db: %w", err)
+	}
+
+	err = db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		if meta == nil {
+			return fmt.Errorf("index.db has no meta bucket")
+		}
+		if version, _ := strconv.Atoi(string(meta.Get([]byte("schema_version")))); version != boltVersion {
+			return fmt.Errorf("index.db schema version %d is not supported (%d), run build", version, boltVersion)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Database{db: db}, nil
+}
+
+// writeBolt writes the whole index to a new database and renames it over
+// index.db; a web UI holding the old file open keeps reading the old one
+func writeBolt(base string, store *model.Store) error {
+	path := filepath.Join(base, Filename(Bolt))
+	tmpPath := path + ".tmp"
+	os.Remove(tmpPath)
+
+	db, err := bolt.Open(tmpPath, 0644, &bolt.Options{NoSync: true, Timeout: 5 * time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to create index.db: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return fillBolt(tx, store)
+	})
+	if err == nil {
+		err = db.Sync()
+	}
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write index.db: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to write index.db: %w", err)
+	}
+	return nil
+}
+
+func fillBolt(tx *bolt.Tx, store *model.Store) error {
+	buckets := make(map[string]*bolt.Bucket)
+	for _, name := range [][]byte{metaBucket, artworksBucket, artistsBucket, tagsBucket, bookmarkersBucket, lostBucket,
+		byArtistBucket, byTagBucket, byBookmarkBucket, byDateBucket, byPagesBucket, byRatingBucket, byAIBucket} {
+		bucket, err := tx.CreateBucket(name)
+		if err != nil {
+			return err
+		}
+		buckets[string(name)] = bucket
+	}
+	put := func(bucket []byte, key, value []byte) error {
+		return buckets[string(bucket)].Put(key, value)
+	}
+
+	if err := put(metaBucket, []byte("schema_version"), []byte(strconv.Itoa(boltVersion))); err != nil {
+		return err
+	}
+	if err := put(metaBucket, []byte("last_indexed"), []byte(store.LastIndexed.Format(time.RFC3339Nano))); err != nil {
+		return err
+	}
+
+	for id, card := range store.ArtworkIndex {
+		key, ok := idKey(id)
+		if !ok {
+			log.Printf("Warning: Artwork ID %q is not a number, left out of index.db", id)
+			continue
+		}
+		value, err := json.Marshal(card)
+		if err != nil {
+			return err
+		}
+		if err := put(artworksBucket, key, value); err != nil {
+			return err
+		}
+
+		if artistKey, ok := idKey(card.ArtistID); ok {
+			if err := put(byArtistBucket, append(artistKey, key...), nil); err != nil {
+				return err
+			}
+		}
+		for _, user := range card.BookmarkedBy {
+			if err := put(byBookmarkBucket, stringKey(user, key), nil); err != nil {
+				return err
+			}
+		}
+		if created, err := ParseCreateDate(card.CreateDate); err == nil {
+			if err := put(byDateBucket, append(timeKey(created), key...), nil); err != nil {
+				return err
+			}
+		}
+		pages := make([]byte, 4)
+		binary.BigEndian.PutUint32(pages, uint32(max(0, card.PageCount)))
+		if err := put(byPagesBucket, append(pages, key...), nil); err != nil {
+			return err
+		}
+		if err := put(byRatingBucket, stringKey(card.Rating, key), nil); err != nil {
+			return err
+		}
+		if err := put(byAIBucket, stringKey(aiValue(card.AI), key), nil); err != nil {
+			return err
+		}
+	}
+
+	for tag, cards := range store.TagIndex {
+		if err := put(tagsBucket, []byte(tag), []byte(strconv.Itoa(len(cards)))); err != nil {
+			return err
+		}
+		for _, card := range cards {
+			if key, ok := idKey(card.ID); ok {
+				if err := put(byTagBucket, stringKey(tag, key), nil); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for user, cards := range store.BookmarkIndex {
+		if err := put(bookmarkersBucket, []byte(user), []byte(strconv.Itoa(len(cards)))); err != nil {
+			return err
+		}
+	}
+	for id, detail := range store.ArtistIndex {
+		key, ok := idKey(id)
+		if !ok {
+			continue
+		}
+		value, err := json.Marshal(boltArtist{Name: detail.Name, Path: detail.Path, Count: len(detail.Artworks)})
+		if err != nil {
+			return err
+		}
+		if err := put(artistsBucket, key, value); err != nil {
+			return err
+		}
+	}
+	for id, lost := range store.LostIndex {
+		key, ok := idKey(id)
+		if !ok {
+			continue
+		}
+		value, err := json.Marshal(lost)
+		if err != nil {
+			return err
+		}
+		if err := put(lostBucket, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Database) Artwork(id string) (*model.ArtworkCard, bool) {
+	cards := b.Artworks([]string{id})
+	if len(cards) == 0 {
+		return nil, false
+	}
+	return cards[0], true
+}
+
+func (b *Database) Artworks(ids []string) []*model.ArtworkCard {
+	cards := make([]*model.ArtworkCard, 0, len(ids))
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(artworksBucket)
+		for _, id := range ids {
+			key, ok := idKey(id)
+			if !ok {
+				continue
+			}
+			value := bucket.Get(key)
+			if value == nil {
+				continue
+			}
+			var card model.ArtworkCard
+			if err := json.Unmarshal(value, &card); err != nil {
+				return err
+			}
+			cards = append(cards, &card)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to read index.db: %v", err)
+	}
+	return cards
+}
+
+func (b *Database) Artist(id string) (*model.ArtistDetail, bool) {
+	key, ok := idKey(id)
+	if !ok {
+		return nil, false
+	}
+	var artist boltArtist
+	found := false
+	err := b.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(artistsBucket).Get(key)
+		if value == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(value, &artist)
+	})
+	if err != nil || !found {
+		return nil, false
+	}
+	return &model.ArtistDetail{Name: artist.Name, Path: artist.Path}, true
+}
+
+// Find intersects the ID sets of the by_* indexes, narrowest first
+func (b *Database) Find(q Query) ([]string, error) {
+	var ids map[uint64]bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		narrow := func(found map[uint64]bool) {
+			if ids == nil {
+				ids = found
+				return
+			}
+			for id := range ids {
+				if !found[id] {
+					delete(ids, id)
+				}
+			}
+		}
+
+		if q.Artist != "" {
+			artistKey, ok := idKey(q.Artist)
+			if !ok {
+				ids = map[uint64]bool{}
+				return nil
+			}
+			narrow(scanPrefix(tx.Bucket(byArtistBucket), artistKey))
+		}
+		if q.Tag != "" {
+			narrow(scanPrefix(tx.Bucket(byTagBucket), stringKey(q.Tag, nil)))
+		}
+		if q.BookmarkedBy != "" {
+			narrow(scanPrefix(tx.Bucket(byBookmarkBucket), stringKey(q.BookmarkedBy, nil)))
+		}
+		if !q.CreatedFrom.IsZero() || !q.CreatedTo.IsZero() {
+			from, to := timeKey(time.Unix(0, 0)), []byte(nil)
+			if !q.CreatedFrom.IsZero() {
+				from = timeKey(q.CreatedFrom)
+			}
+			if !q.CreatedTo.IsZero() {
+				to = timeKey(q.CreatedTo)
+			}
+			narrow(scanRange(tx.Bucket(byDateBucket), from, to))
+		}
+		if q.MinPages > 0 || q.MaxPages > 0 {
+			from, to := make([]byte, 4), []byte(nil)
+			binary.BigEndian.PutUint32(from, uint32(q.MinPages))
+			if q.MaxPages > 0 {
+				to = make([]byte, 4)
+				binary.BigEndian.PutUint32(to, uint32(q.MaxPages)+1)
+			}
+			narrow(scanRange(tx.Bucket(byPagesBucket), from, to))
+		}
+
+		if q.Rating != "" {
+			narrow(scanPrefix(tx.Bucket(byRatingBucket), stringKey(q.Rating, nil)))
+		}
+		if q.AI != "" {
+			narrow(scanPrefix(tx.Bucket(byAIBucket), stringKey(q.AI, nil)))
+		}
+
+		if ids == nil {
+			// no condition: every artwork, only the keys are read
+			ids = make(map[uint64]bool)
+			cursor := tx.Bucket(artworksBucket).Cursor()
+			for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+				ids[binary.BigEndian.Uint64(key)] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(ids))
+	for id := range ids {
+		result = append(result, strconv.FormatUint(id, 10))
+	}
+	return result, nil
+}
+
+// scanPrefix collects the artwork IDs at the end of every key with prefix
+func scanPrefix(bucket *bolt.Bucket, prefix []byte) map[uint64]bool {
+	ids := make(map[uint64]bool)
+	cursor := bucket.Cursor()
+	for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+		if len(key) == len(prefix)+8 {
+			ids[binary.BigEndian.Uint64(key[len(prefix):])] = true
+		}
+	}
+	return ids
+}
+
+// scanRange collects the artwork IDs of the keys from "from" up to, not
+// including, "to"; a nil "to" runs to the end
+func scanRange(bucket *bolt.Bucket, from, to []byte) map[uint64]bool {
+	ids := make(map[uint64]bool)
+	cursor := bucket.Cursor()
+	for key, _ := cursor.Seek(from); key != nil && (to == nil || bytes.Compare(key, to) < 0); key, _ = cursor.Next() {
+		if len(key) >= 8 {
+			ids[binary.BigEndian.Uint64(key[len(key)-8:])] = true
+		}
+	}
+	return ids
+}
+
+func (b *Database) Tags() []Entry {
+	return b.counts(tagsBucket)
+}
+
+func (b *Database) Bookmarkers() []Entry {
+	return b.counts(bookmarkersBucket)
+}
+
+func (b *Database) counts(name []byte) []Entry {
+	var entries []Entry
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(name).ForEach(func(key, value []byte) error {
+			count, _ := strconv.Atoi(string(value))
+			entries = append(entries, Entry{Value: string(key), Label: string(key), Count: count})
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Failed to read index.db: %v", err)
+	}
+	return sortEntries(entries)
+}
+
+func (b *Database) Artists() []Entry {
+	var entries []Entry
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(artistsBucket).ForEach(func(key, value []byte) error {
+			var artist boltArtist
+			if err := json.Unmarshal(value, &artist); err != nil {
+				return err
+			}
+			id := strconv.FormatUint(binary.BigEndian.Uint64(key), 10)
+			label := artist.Name
+			if label == "" {
+				label = id
+			}
+			entries = append(entries, Entry{Value: id, Label: label, Count: artist.Count})
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Failed to read index.db: %v", err)
+	}
+	return sortEntries(entries)
+}
+
+func (b *Database) Lost() []*model.LostArtwork {
+	var lost []*model.LostArtwork
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(lostBucket).ForEach(func(key, value []byte) error {
+			var artwork model.LostArtwork
+			if err := json.Unmarshal(value, &artwork); err != nil {
+				return err
+			}
+			lost = append(lost, &artwork)
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Failed to read index.db: %v", err)
+	}
+	return lost
+}
+
+func (b *Database) Info() Info {
+	var info Info
+	b.db.View(func(tx *bolt.Tx) error {
+		info.Total = tx.Bucket(artworksBucket).Stats().KeyN
+		info.LastIndexed, _ = time.Parse(time.RFC3339Nano, string(tx.Bucket(metaBucket).Get([]byte("last_indexed"))))
+		return nil
+	})
+	return info
+}
+
+func (b *Database) Close() error {
+	return b.db.Close()
+}
+
+// idKey encodes a numeric ID so that keys sort like the numbers
+func idKey(id string) ([]byte, bool) {
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, n)
+	return key, true
+}
+
+// stringKey is s, a 0 byte that ends it, then idKey
+func stringKey(s string, id []byte) []byte {
+	key := make([]byte, 0, len(s)+1+len(id))
+	key = append(key, s...)
+	key = append(key, 0)
+	return append(key, id...)
+}
+
+// timeKey encodes a time so that keys sort chronologically; times before
+// 1970 sort first
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(max(0, min(t.Unix(), math.MaxInt64))))
+	return key
+}
@@ -0,0 +1,201 @@
+package index
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Magnetkopf/pGallery/internal/model"
+)
+
+// Backends an index can be stored in
+const (
+	JSON = "json" // index.json, loaded into memory whole
+	Bolt = "bolt" // index.db, a bbolt database queried on demand
+)
+
+// Backends lists the valid backend names
+var Backends = []string{JSON, Bolt}
+
+// Index is the read side of a built index, whichever backend holds it. IDs
+// are artwork IDs as strings, like the keys of model.Store.
+type Index interface {
+	// Artwork returns the card of one artwork
+	Artwork(id string) (*model.ArtworkCard, bool)
+	// Artworks returns the cards of ids in the same order, skipping unknown IDs
+	Artworks(ids []string) []*model.ArtworkCard
+	// Artist returns an artist's name and directory; Artworks is left empty,
+	// Find with Query.Artist lists them
+	Artist(id string) (*model.ArtistDetail, bool)
+	// Find returns the IDs of the artworks that meet every condition of q
+	Find(q Query) ([]string, error)
+
+	// Tags, Artists and Bookmarkers list the values to filter on with the
+	// number of artworks of each
+	Tags() []Entry
+	Artists() []Entry
+	Bookmarkers() []Entry
+
+	// Lost lists the bookmarked works pixiv no longer serves
+	Lost() []*model.LostArtwork
+	// Info tells how big and how old the index is
+	Info() Info
+
+	Close() error
+}
+
+// Query selects artworks; the zero value selects all of them
+type Query struct {
+	Artist       string
+	Tag          string
+	BookmarkedBy string
+	// creation date range in wall clock time, see ParseCreateDate; From
+	// included, To excluded; zero is open
+	CreatedFrom, CreatedTo time.Time
+	// page count range, both included; zero is open
+	MinPages, MaxPages int
+	// Rating is model.RatingSafe, RatingR18 or RatingR18G
+	Rating string
+	// AI is AIYes or AINo
+	AI string
+}
+
+// Values of Query.AI
+const (
+	AIYes = "yes"
+	AINo  = "no"
+)
+
+// aiValue is the Query.AI value an artwork matches
+func aiValue(ai bool) string {
+	if ai {
+		return AIYes
+	}
+	return AINo
+}
+
+// Entry is a tag, artist or bookmarking user; Label is the artist's name
+type Entry struct {
+	Value string
+	Label string
+	Count int
+}
+
+type Info struct {
+	Total       int
+	LastIndexed time.Time
+}
+
+// Filename is where a backend keeps its index, relative to base
+func Filename(backend string) string {
+	if backend == Bolt {
+		return "index.db"
+	}
+	return "index.json"
+}
+
+// Validate checks a backend name
+func Validate(backend string) error {
+	for _, name := range Backends {
+		if backend == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown index backend %q, expected %s", backend, strings.Join(Backends, " or "))
+}
+
+// Detect returns the backend of the index in base: the newer of index.json
+// and index.db, JSON when there is none
+func Detect(base string) string {
+	jsonInfo, jsonErr := os.Stat(filepath.Join(base, Filename(JSON)))
+	boltInfo, boltErr := os.Stat(filepath.Join(base, Filename(Bolt)))
+	if boltErr == nil && (jsonErr != nil || boltInfo.ModTime().After(jsonInfo.ModTime())) {
+		return Bolt
+	}
+	return JSON
+}
+
+// Open opens the index of base in the given backend
+func Open(base, backend string) (Index, error) {
+	switch backend {
+	case JSON:
+		store, err := LoadJSON(base)
+		if err != nil {
+			return nil, err
+		}
+		return NewMemory(store), nil
+	case Bolt:
+		return openBolt(base)
+	}
+	return nil, Validate(backend)
+}
+
+// Write stores a freshly built index in base, replacing the old one
+// atomically so a running web UI never sees half of it
+func Write(base, backend string, store *model.Store) error {
+	switch backend {
+	case JSON:
+		return writeJSON(base, store)
+	case Bolt:
+		return writeBolt(base, store)
+	}
+	return Validate(backend)
+}
+
+// ParseCreateDate reads an RFC 3339 creation date as its wall clock time in
+// UTC, so an artwork posted on the 1st in Japan counts as the 1st, not the
+// evening before
+func ParseCreateDate(createDate string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, createDate)
+	if err != nil {
+		return t, err
+	}
+	year, month, day := t.Date()
+	return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC), nil
+}
+
+// matchDate reports whether an RFC 3339 creation date lies in q's range;
+// without a range every artwork matches, with one undated artworks don't
+func (q Query) matchDate(createDate string) bool {
+	if q.CreatedFrom.IsZero() && q.CreatedTo.IsZero() {
+		return true
+	}
+	created, err := ParseCreateDate(createDate)
+	if err != nil {
+		return false
+	}
+	return q.matchTime(created)
+}
+
+func (q Query) matchTime(created time.Time) bool {
+	if !q.CreatedFrom.IsZero() && created.Before(q.CreatedFrom) {
+		return false
+	}
+	if !q.CreatedTo.IsZero() && !created.Before(q.CreatedTo) {
+		return false
+	}
+	return true
+}
+
+// matchLabels checks the rating and AI conditions
+func (q Query) matchLabels(card *model.ArtworkCard) bool {
+	return (q.Rating == "" || card.Rating == q.Rating) && (q.AI == "" || aiValue(card.AI) == q.AI)
+}
+
+func (q Query) matchPages(pages int) bool {
+	return (q.MinPages == 0 || pages >= q.MinPages) && (q.MaxPages == 0 || pages <= q.MaxPages)
+}
+
+// sortEntries orders by count, most first, then by value
+func sortEntries(entries []Entry) []Entry {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Value < entries[j].Value
+	})
+	return entries
+}
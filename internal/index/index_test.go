@@ -0,0 +1,127 @@
+package index
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Magnetkopf/pGallery/internal/model"
+)
+
+// testStore is a small index: artist 10 with artworks 1 to 3, artist 20 with 4
+func testStore() *model.Store {
+	cards := []*model.ArtworkCard{
+		{ID: "1", ArtistID: "10", PageCount: 1, CreateDate: "2024-01-01T00:30:00+09:00", Rating: model.RatingSafe},
+		{ID: "2", ArtistID: "10", PageCount: 5, CreateDate: "2024-01-31T23:59:59+09:00", Rating: model.RatingR18, BookmarkedBy: []string{"7"}},
+		{ID: "3", ArtistID: "10", PageCount: 10, CreateDate: "2024-02-01T00:00:00Z", Rating: model.RatingSafe, AI: true},
+		{ID: "4", ArtistID: "20", PageCount: 5, Rating: model.RatingR18G, BookmarkedBy: []string{"7", "8"}},
+	}
+	store := &model.Store{
+		ArtworkIndex:  make(map[string]*model.ArtworkCard),
+		TagIndex:      make(map[string][]*model.ArtworkCard),
+		ArtistIndex:   make(map[string]*model.ArtistDetail),
+		BookmarkIndex: make(map[string][]*model.ArtworkCard),
+		LostIndex:     make(map[string]*model.LostArtwork),
+		LastIndexed:   time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+	tags := map[string][]string{"1": {"a", "ab"}, "2": {"ab"}, "3": {"a b"}, "4": {"a"}}
+	for _, card := range cards {
+		store.ArtworkIndex[card.ID] = card
+		for _, tag := range tags[card.ID] {
+			store.TagIndex[tag] = append(store.TagIndex[tag], card)
+		}
+		for _, user := range card.BookmarkedBy {
+			store.BookmarkIndex[user] = append(store.BookmarkIndex[user], card)
+		}
+		if store.ArtistIndex[card.ArtistID] == nil {
+			store.ArtistIndex[card.ArtistID] = &model.ArtistDetail{Name: "artist " + card.ArtistID, Path: card.ArtistID}
+		}
+		store.ArtistIndex[card.ArtistID].Artworks = append(store.ArtistIndex[card.ArtistID].Artworks, card)
+	}
+	return store
+}
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"all", Query{}, []string{"1", "2", "3", "4"}},
+		{"artist", Query{Artist: "10"}, []string{"1", "2", "3"}},
+		{"unknown artist", Query{Artist: "30"}, nil},
+		{"artist not a number", Query{Artist: "x"}, nil},
+		// "a" is not a prefix match of "ab" or "a b"
+		{"tag", Query{Tag: "a"}, []string{"1", "4"}},
+		{"tag with a space", Query{Tag: "a b"}, []string{"3"}},
+		{"bookmarked by", Query{BookmarkedBy: "7"}, []string{"2", "4"}},
+		{"bookmarked and tagged", Query{BookmarkedBy: "7", Tag: "ab"}, []string{"2"}},
+		// wall clock dates: 00:30 in Japan is the 1st, undated 4 is left out
+		{"from included", Query{CreatedFrom: day(2024, 1, 1)}, []string{"1", "2", "3"}},
+		{"to excluded", Query{CreatedTo: day(2024, 2, 1)}, []string{"1", "2"}},
+		{"one day", Query{CreatedFrom: day(2024, 1, 31), CreatedTo: day(2024, 2, 1)}, []string{"2"}},
+		{"min pages included", Query{MinPages: 5}, []string{"2", "3", "4"}},
+		{"max pages included", Query{MaxPages: 5}, []string{"1", "2", "4"}},
+		{"exact pages", Query{MinPages: 5, MaxPages: 5}, []string{"2", "4"}},
+		{"rating", Query{Rating: model.RatingSafe}, []string{"1", "3"}},
+		{"ai", Query{AI: AIYes}, []string{"3"}},
+		{"not ai", Query{AI: AINo, Artist: "10"}, []string{"1", "2"}},
+		{"nothing left", Query{Rating: model.RatingR18G, Artist: "10"}, nil},
+	}
+
+	base := t.TempDir()
+	for _, backend := range Backends {
+		if err := Write(base, backend, testStore()); err != nil {
+			t.Fatal(err)
+		}
+		index, err := Open(base, backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer index.Close()
+
+		for _, tt := range tests {
+			got, err := index.Find(tt.query)
+			if err != nil {
+				t.Errorf("%s: %s: %v", backend, tt.name, err)
+				continue
+			}
+			slices.SortFunc(got, func(a, b string) int {
+				if model.IDLess(a, b) {
+					return -1
+				}
+				return 1
+			})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("%s: %s: Find = %q, want %q", backend, tt.name, got, tt.want)
+			}
+		}
+
+		if info := index.Info(); info.Total != 4 || !info.LastIndexed.Equal(day(2024, 3, 1)) {
+			t.Errorf("%s: Info = %+v", backend, info)
+		}
+		if tags := index.Tags(); len(tags) != 3 || tags[0] != (Entry{Value: "a", Label: "a", Count: 2}) {
+			t.Errorf("%s: Tags = %+v", backend, tags)
+		}
+		if artists := index.Artists(); len(artists) != 2 || artists[0] != (Entry{Value: "10", Label: "artist 10", Count: 3}) {
+			t.Errorf("%s: Artists = %+v", backend, artists)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	base := t.TempDir()
+	if got := Detect(base); got != JSON {
+		t.Errorf("Detect without an index = %q, want %q", got, JSON)
+	}
+	if err := Write(base, Bolt, testStore()); err != nil {
+		t.Fatal(err)
+	}
+	if got := Detect(base); got != Bolt {
+		t.Errorf("Detect with index.db = %q, want %q", got, Bolt)
+	}
+}
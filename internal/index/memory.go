@@ -0,0 +1,202 @@
+package index
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Magnetkopf/pGallery/internal/model"
+)
+
+// Memory serves an index held in memory, as loaded from index.json
+type Memory struct {
+	store *model.Store
+}
+
+// NewMemory wraps a store; it must not be changed afterwards
+func NewMemory(store *model.Store) *Memory {
+	if store.ArtworkIndex == nil {
+		store.ArtworkIndex = make(map[string]*model.ArtworkCard)
+	}
+	return &Memory{store: store}
+}
+
+// LoadJSON reads index.json from base
+func LoadJSON(base string) (*model.Store, error) {
+	indexPath := filepath.Join(base, Filename(JSON))
+	fileContent, err := os.ReadFile(indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index.json: %w", err)
+	}
+
+	var store model.Store
+	if err := json.Unmarshal(fileContent, &store); err != nil {
+		return nil, fmt.Errorf("failed to parse index.json: %w", err)
+	}
+
+	// indexes built before layouts existed have no paths, they used the default layout
+	for _, card := range store.ArtworkIndex {
+		if card.Path == "" {
+			card.Path = card.ArtistID + "/" + card.ID
+		}
+	}
+	for artistID, artist := range store.ArtistIndex {
+		if artist.Path == "" {
+			artist.Path = artistID
+		}
+	}
+	return &store, nil
+}
+
+func writeJSON(base string, store *model.Store) error {
+	indexPath := filepath.Join(base, Filename(JSON))
+	indexBytes, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal index: %w", err)
+	}
+
+	tmpPath := indexPath + ".tmp"
+	if err := os.WriteFile(tmpPath, indexBytes, 0644); err != nil {
+		return fmt.Errorf("failed to write index.json: %w", err)
+	}
+	if err := os.Rename(tmpPath, indexPath); err != nil {
+		return fmt.Errorf("failed to write index.json: %w", err)
+	}
+	return nil
+}
+
+func (m *Memory) Artwork(id string) (*model.ArtworkCard, bool) {
+	card, ok := m.store.ArtworkIndex[id]
+	return card, ok
+}
+
+func (m *Memory) Artworks(ids []string) []*model.ArtworkCard {
+	cards := make([]*model.ArtworkCard, 0, len(ids))
+	for _, id := range ids {
+		if card, ok := m.store.ArtworkIndex[id]; ok {
+			cards = append(cards, card)
+		}
+	}
+	return cards
+}
+
+func (m *Memory) Artist(id string) (*model.ArtistDetail, bool) {
+	detail, ok := m.store.ArtistIndex[id]
+	if !ok {
+		return nil, false
+	}
+	return &model.ArtistDetail{Name: detail.Name, Path: detail.Path}, true
+}
+
+func (m *Memory) Find(q Query) ([]string, error) {
+	// start from the smallest list a condition points at
+	var candidates []*model.ArtworkCard
+	all := true
+	narrow := func(cards []*model.ArtworkCard) {
+		if all || len(cards) < len(candidates) {
+			candidates, all = cards, false
+		}
+	}
+	if q.Artist != "" {
+		var cards []*model.ArtworkCard
+		if detail, ok := m.store.ArtistIndex[q.Artist]; ok {
+			cards = detail.Artworks
+		}
+		narrow(cards)
+	}
+	if q.Tag != "" {
+		narrow(m.store.TagIndex[q.Tag])
+	}
+	if q.BookmarkedBy != "" {
+		narrow(m.store.BookmarkIndex[q.BookmarkedBy])
+	}
+	if all {
+		candidates = make([]*model.ArtworkCard, 0, len(m.store.ArtworkIndex))
+		for _, card := range m.store.ArtworkIndex {
+			candidates = append(candidates, card)
+		}
+	}
+
+	var tagged map[*model.ArtworkCard]bool
+	if q.Tag != "" {
+		tagged = make(map[*model.ArtworkCard]bool, len(m.store.TagIndex[q.Tag]))
+		for _, card := range m.store.TagIndex[q.Tag] {
+			tagged[card] = true
+		}
+	}
+
+	var ids []string
+	for _, card := range candidates {
+		if m.match(card, q, tagged) {
+			ids = append(ids, card.ID)
+		}
+	}
+	return ids, nil
+}
+
+func (m *Memory) match(card *model.ArtworkCard, q Query, tagged map[*model.ArtworkCard]bool) bool {
+	if q.Artist != "" && card.ArtistID != q.Artist {
+		return false
+	}
+	if q.Tag != "" && !tagged[card] {
+		return false
+	}
+	if q.BookmarkedBy != "" && !containsString(card.BookmarkedBy, q.BookmarkedBy) {
+		return false
+	}
+	return q.matchPages(card.PageCount) && q.matchDate(card.CreateDate) && q.matchLabels(card)
+}
+
+func (m *Memory) Tags() []Entry {
+	entries := make([]Entry, 0, len(m.store.TagIndex))
+	for tag, cards := range m.store.TagIndex {
+		entries = append(entries, Entry{Value: tag, Label: tag, Count: len(cards)})
+	}
+	return sortEntries(entries)
+}
+
+func (m *Memory) Artists() []Entry {
+	entries := make([]Entry, 0, len(m.store.ArtistIndex))
+	for id, detail := range m.store.ArtistIndex {
+		label := detail.Name
+		if label == "" {
+			label = id
+		}
+		entries = append(entries, Entry{Value: id, Label: label, Count: len(detail.Artworks)})
+	}
+	return sortEntries(entries)
+}
+
+func (m *Memory) Bookmarkers() []Entry {
+	entries := make([]Entry, 0, len(m.store.BookmarkIndex))
+	for user, cards := range m.store.BookmarkIndex {
+		entries = append(entries, Entry{Value: user, Label: user, Count: len(cards)})
+	}
+	return sortEntries(entries)
+}
+
+func (m *Memory) Lost() []*model.LostArtwork {
+	lost := make([]*model.LostArtwork, 0, len(m.store.LostIndex))
+	for _, artwork := range m.store.LostIndex {
+		lost = append(lost, artwork)
+	}
+	return lost
+}
+
+func (m *Memory) Info() Info {
+	return Info{Total: len(m.store.ArtworkIndex), LastIndexed: m.store.LastIndexed}
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
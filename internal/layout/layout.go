@@ -0,0 +1,341 @@
+package layout
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Magnetkopf/pGallery/internal/model"
+)
+
+// Layout decides where artworks and artists live under the base directory.
+// Both fields are templates relative to base, "/" separates directories.
+//
+// Placeholders:
+//
+//	{id}             artwork ID
+//	{title}          artwork title
+//	{pages}          page count
+//	{artist_id}      artist ID
+//	{artist_name}    artist name
+//	{artist_account} artist account (artist template only)
+//	{date:FORMAT}    creation date as a Go time layout, e.g. {date:2006-01}
+type Layout struct {
+	Artist  string `yaml:"artist,omitempty"`
+	Artwork string `yaml:"artwork,omitempty"`
+}
+
+// Default is the layout pGallery always used: <artist_id>/<artwork_id>
+var Default = Layout{
+	Artist:  "{artist_id}",
+	Artwork: "{artist_id}/{id}",
+}
+
+// maxSegment keeps directory names within the 255 byte limit of common filesystems
+const maxSegment = 200
+
+// Merge returns l with every template that is set in over replaced
+func (l Layout) Merge(over Layout) Layout {
+	if over.Artist != "" {
+		l.Artist = over.Artist
+	}
+	if over.Artwork != "" {
+		l.Artwork = over.Artwork
+	}
+	return l
+}
+
+// Validate checks both templates for unknown placeholders, and that every
+// artwork gets a directory of its own
+func (l Layout) Validate() error {
+	artist := model.ArtistData{}
+	artwork := model.ArtworkData{}
+	if _, err := expand(l.Artist, artistFields(artist)); err != nil {
+		return fmt.Errorf("artist layout: %w", err)
+	}
+	if _, err := expand(l.Artwork, artworkFields(artwork)); err != nil {
+		return fmt.Errorf("artwork layout: %w", err)
+	}
+	// without the IDs, artworks with the same title or artists with the same
+	// name would overwrite each other's files
+	if !strings.Contains(l.Artwork, "{id}") {
+		return fmt.Errorf("artwork layout: %q must contain {id}", l.Artwork)
+	}
+	if !strings.Contains(l.Artist, "{artist_id}") {
+		return fmt.Errorf("artist layout: %q must contain {artist_id}", l.Artist)
+	}
+	if mayEnclose(l.Artwork, l.Artist) {
+		return fmt.Errorf("artwork layout: %q can expand to an artist directory or one above it", l.Artwork)
+	}
+	// the scan skips the reports, and everything in there with them
+	if mayEnclose(ReportsDir, l.Artist) {
+		return fmt.Errorf("artist layout: %q can expand to %s/, which holds the sync reports", l.Artist, ReportsDir)
+	}
+	if mayEnclose(ReportsDir, l.Artwork) {
+		return fmt.Errorf("artwork layout: %q can expand to %s/, which holds the sync reports", l.Artwork, ReportsDir)
+	}
+	return nil
+}
+
+// mayEnclose reports whether, for some artist and artwork, a directory of the
+// outer template could be one of the inner template or a parent of it
+func mayEnclose(outer, inner string) bool {
+	innerParts := strings.Split(inner, "/")
+	outerParts := strings.Split(outer, "/")
+	if len(outerParts) > len(innerParts) {
+		return false
+	}
+	for i, part := range outerParts {
+		if !mayMatch(pattern(part), pattern(innerParts[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// patternItem is a literal rune of a template, or a run of placeholder value
+type patternItem struct {
+	r      rune
+	class  int // 0 for a literal, else digitsClass or anyClass
+	repeat bool
+}
+
+const (
+	digitsClass = iota + 1
+	anyClass
+)
+
+// numericFields are the placeholders whose values are all digits
+var numericFields = map[string]bool{"id": true, "pages": true, "artist_id": true}
+
+// pattern turns a directory name template into the runes it can expand to:
+// numeric placeholders are one or more digits, the others any runes
+func pattern(segment string) []patternItem {
+	var items []patternItem
+	rest := segment
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		end := strings.IndexByte(rest, '}')
+		if open != 0 || end < 0 {
+			r, size := utf8.DecodeRuneInString(rest)
+			items = append(items, patternItem{r: r})
+			rest = rest[size:]
+			continue
+		}
+		name, _, _ := strings.Cut(rest[1:end], ":")
+		if numericFields[name] {
+			items = append(items, patternItem{class: digitsClass}, patternItem{class: digitsClass, repeat: true})
+		} else {
+			items = append(items, patternItem{class: anyClass, repeat: true})
+		}
+		rest = rest[end+1:]
+	}
+	return items
+}
+
+// accepts reports whether a and b have a rune in common
+func (a patternItem) accepts(b patternItem) bool {
+	switch {
+	case a.class == 0 && b.class == 0:
+		return a.r == b.r
+	case a.class == 0:
+		return b.class == anyClass || '0' <= a.r && a.r <= '9'
+	case b.class == 0:
+		return b.accepts(a)
+	}
+	return true
+}
+
+// mayMatch reports whether some string fits both patterns, walking them side
+// by side; repeated items may match nothing or stay put after a rune
+func mayMatch(a, b []patternItem) bool {
+	type state struct{ i, j int }
+	seen := make(map[state]bool)
+	queue := []state{{0, 0}}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		if s.i == len(a) && s.j == len(b) {
+			return true
+		}
+		if s.i < len(a) && a[s.i].repeat {
+			queue = append(queue, state{s.i + 1, s.j})
+		}
+		if s.j < len(b) && b[s.j].repeat {
+			queue = append(queue, state{s.i, s.j + 1})
+		}
+		if s.i == len(a) || s.j == len(b) || !a[s.i].accepts(b[s.j]) {
+			continue
+		}
+		next := state{s.i + 1, s.j + 1}
+		if a[s.i].repeat {
+			next.i = s.i
+		}
+		if b[s.j].repeat {
+			next.j = s.j
+		}
+		queue = append(queue, next)
+	}
+	return false
+}
+
+// ArtistDir returns the directory of an artist, relative to base
+func (l Layout) ArtistDir(artist model.ArtistData) string {
+	dir, err := expand(l.Artist, artistFields(artist))
+	if err != nil {
+		dir, _ = expand(Default.Artist, artistFields(artist))
+	}
+	return dir
+}
+
+// ArtworkDir returns the directory of an artwork, relative to base
+func (l Layout) ArtworkDir(artwork model.ArtworkData) string {
+	dir, err := expand(l.Artwork, artworkFields(artwork))
+	if err != nil {
+		dir, _ = expand(Default.Artwork, artworkFields(artwork))
+	}
+	return dir
+}
+
+// PageFile is the file name of page n, without the extension
+func PageFile(n int) string {
+	return "p" + strconv.Itoa(n)
+}
+
+// PageNumber parses a file name written by PageFile, e.g. "p3.jpg"
+func PageNumber(name string) (int, bool) {
+	stem, _, hasExt := strings.Cut(name, ".")
+	if !hasExt || !strings.HasPrefix(stem, "p") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(stem[1:])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+func artistFields(artist model.ArtistData) map[string]string {
+	return map[string]string{
+		"artist_id":      strconv.Itoa(artist.ID),
+		"artist_name":    artist.Name,
+		"artist_account": artist.Account,
+	}
+}
+
+func artworkFields(artwork model.ArtworkData) map[string]string {
+	return map[string]string{
+		"id":          strconv.Itoa(artwork.ID),
+		"title":       artwork.Title,
+		"pages":       strconv.Itoa(artwork.PageCount),
+		"artist_id":   strconv.Itoa(artwork.ArtistId),
+		"artist_name": artwork.ArtistName,
+		"date":        artwork.CreateDate,
+	}
+}
+
+// expand fills in the placeholders of tmpl and returns a clean relative path
+func expand(tmpl string, fields map[string]string) (string, error) {
+	if strings.TrimSpace(tmpl) == "" {
+		return "", fmt.Errorf("empty template")
+	}
+
+	var segments []string
+	for _, part := range strings.Split(tmpl, "/") {
+		if part == "" {
+			return "", fmt.Errorf("empty directory name in %q", tmpl)
+		}
+
+		var b strings.Builder
+		rest := part
+		for {
+			open := strings.IndexByte(rest, '{')
+			if open < 0 {
+				b.WriteString(rest)
+				break
+			}
+			end := strings.IndexByte(rest[open:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unclosed placeholder in %q", tmpl)
+			}
+			b.WriteString(rest[:open])
+
+			value, err := field(rest[open+1:open+end], fields)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(Sanitize(value))
+			rest = rest[open+end+1:]
+		}
+
+		// cleanSegment turns "." and ".." into "_", nothing escapes base
+		segments = append(segments, cleanSegment(b.String()))
+	}
+	return filepath.Join(segments...), nil
+}
+
+func field(name string, fields map[string]string) (string, error) {
+	name, format, hasFormat := strings.Cut(name, ":")
+	value, ok := fields[name]
+	if !ok {
+		return "", fmt.Errorf("unknown placeholder {%s}", name)
+	}
+	if name != "date" {
+		if hasFormat {
+			return "", fmt.Errorf("placeholder {%s} takes no format", name)
+		}
+		return value, nil
+	}
+
+	if !hasFormat {
+		format = "2006-01-02"
+	}
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "unknown", nil
+	}
+	return date.Format(format), nil
+}
+
+// Sanitize turns s into something every common filesystem accepts as part of
+// a file name: path separators and reserved characters become "_", control
+// characters are dropped
+func Sanitize(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			continue
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			b.WriteRune('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// cleanSegment trims leading dots, which would hide the directory, and what
+// Windows refuses at the end of a name, and caps the length
+func cleanSegment(segment string) string {
+	segment = strings.TrimLeft(strings.TrimSpace(segment), ".")
+	if len(segment) > maxSegment {
+		cut := maxSegment
+		for cut > 0 && !utf8.RuneStart(segment[cut]) {
+			cut--
+		}
+		segment = segment[:cut]
+	}
+	segment = strings.TrimRight(segment, ". ")
+	if segment == "" {
+		return "_"
+	}
+	return segment
+}
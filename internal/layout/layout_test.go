@@ -0,0 +1,199 @@
+package layout
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Magnetkopf/pGallery/internal/model"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"a/b", "a_b"},
+		{`a\b`, "a_b"},
+		{`what?*:"<>|`, "what_______"},
+		{"tab\there\nnewline\x7f", "tabherenewline"},
+		{"東方 Project", "東方 Project"},
+		{"..", ".."}, // left to cleanSegment
+	}
+	for _, tt := range tests {
+		if got := Sanitize(tt.in); got != tt.want {
+			t.Errorf("Sanitize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCleanSegment(t *testing.T) {
+	long := strings.Repeat("あ", 100) // 300 bytes
+	tests := []struct {
+		in, want string
+	}{
+		{"name", "name"},
+		{".hidden", "hidden"},
+		{"..", "_"},
+		{".", "_"},
+		{"", "_"},
+		{"  spaced  ", "spaced"},
+		{"trailing. . ", "trailing"},
+		{long, strings.Repeat("あ", 66)}, // cut at 200 bytes, on a rune boundary
+	}
+	for _, tt := range tests {
+		if got := cleanSegment(tt.in); got != tt.want {
+			t.Errorf("cleanSegment(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestArtworkDir(t *testing.T) {
+	artwork := model.ArtworkData{
+		ID:         123,
+		Title:      "Sky/Sea: part 1",
+		PageCount:  3,
+		ArtistId:   45,
+		ArtistName: ".Alice",
+		CreateDate: "2023-04-05T06:07:08+09:00",
+	}
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{"{artist_id}/{id}", "45/123"},
+		{"{artist_name} ({artist_id})/{id} {title}", "Alice (45)/123 Sky_Sea_ part 1"},
+		{"{date:2006}/{date:01}/{id}", "2023/04/123"},
+		{"{date}/{id}-{pages}p", "2023-04-05/123-3p"},
+		{"../{id}", "_/123"},
+		// falls back to the default layout on errors
+		{"{nope}/{id}", "45/123"},
+		{"{id", "45/123"},
+	}
+	for _, tt := range tests {
+		l := Layout{Artist: "{artist_id}", Artwork: tt.tmpl}
+		if got := l.ArtworkDir(artwork); got != filepath.FromSlash(tt.want) {
+			t.Errorf("ArtworkDir(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+
+	undated := artwork
+	undated.CreateDate = ""
+	l := Layout{Artwork: "{date:2006}/{id}"}
+	if got := l.ArtworkDir(undated); got != filepath.FromSlash("unknown/123") {
+		t.Errorf("ArtworkDir without a date = %q, want unknown/123", got)
+	}
+}
+
+func TestArtistDir(t *testing.T) {
+	artist := model.ArtistData{ID: 45, Name: "A<l>ice", Account: "alice_45"}
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{"{artist_id}", "45"},
+		{"{artist_name}", "A_l_ice"},
+		{"artists/{artist_account}", "artists/alice_45"},
+		{"{title}", "45"}, // not an artist placeholder
+	}
+	for _, tt := range tests {
+		l := Layout{Artist: tt.tmpl}
+		if got := l.ArtistDir(artist); got != filepath.FromSlash(tt.want) {
+			t.Errorf("ArtistDir(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		layout Layout
+		err    string // empty when valid
+	}{
+		{Default, ""},
+		{Layout{Artist: "{artist_name} ({artist_id})", Artwork: "{artist_name} ({artist_id})/{date:2006}/{id} {title}"}, ""},
+		{Layout{Artist: "artists/{artist_id}", Artwork: "{artist_id}/{id}"}, ""},
+		{Layout{Artist: "artists/{artist_id}", Artwork: "works/{id}"}, ""},
+		{Layout{Artist: "{artist_id}", Artwork: "works/{id}"}, ""},
+		{Layout{Artist: "a{artist_id}", Artwork: "w{id}"}, ""},
+		{Layout{Artist: "{artist_id}/profile", Artwork: "{artist_id}/{id}"}, ""},
+		{Layout{Artist: "", Artwork: "{id}"}, "artist layout: empty template"},
+		{Layout{Artist: "{artist_id}", Artwork: ""}, "artwork layout: empty template"},
+		{Layout{Artist: "{artist_id}//x", Artwork: "{id}"}, "empty directory name"},
+		{Layout{Artist: "{artist_id}", Artwork: "{artist_id}/{nope}"}, "unknown placeholder {nope}"},
+		{Layout{Artist: "{artist_id}", Artwork: "{id"}, "unclosed placeholder"},
+		{Layout{Artist: "{artist_id}", Artwork: "{title:x}/{id}"}, "takes no format"},
+		{Layout{Artist: "{id}", Artwork: "{artist_id}/{id}"}, "artist layout: unknown placeholder {id}"},
+		{Layout{Artist: "{artist_id}", Artwork: "{artist_id}"}, "must contain {id}"},
+		{Layout{Artist: "{artist_name}", Artwork: "{artist_name}/{id}"}, "must contain {artist_id}"},
+		{Layout{Artist: "{artist_account}", Artwork: "{id}"}, "must contain {artist_id}"},
+		// an artwork directory that could be an artist's, or hold one
+		{Layout{Artist: "{artist_id}", Artwork: "{id}"}, "can expand to an artist directory"},
+		{Layout{Artist: "{artist_id}", Artwork: "{id}{title}"}, "can expand to an artist directory"},
+		{Layout{Artist: "{artist_id}", Artwork: "{title}{id}"}, "can expand to an artist directory"},
+		{Layout{Artist: "{artist_name}/{artist_id}", Artwork: "{title}/{id}"}, "can expand to an artist directory"},
+		{Layout{Artist: "a{artist_id}/x", Artwork: "{title}{id}"}, "can expand to an artist directory"},
+		{Layout{Artist: "x/{artist_id}", Artwork: "x/{id}"}, "can expand to an artist directory"},
+		{Layout{Artist: "{artist_id}", Artwork: "{date:2006}{id}"}, "can expand to an artist directory"},
+		// nothing may land in the reports directory, which the scan skips
+		{Layout{Artist: "{artist_name}/{artist_id}", Artwork: "{artist_name}/{artist_id}/{id}"}, "artist layout: \"{artist_name}/{artist_id}\" can expand to reports/"},
+		{Layout{Artist: "reports/{artist_id}", Artwork: "{artist_id}/{id}"}, "artist layout: \"reports/{artist_id}\" can expand to reports/"},
+		{Layout{Artist: "{artist_id}", Artwork: "{title}/{id}"}, "artwork layout: \"{title}/{id}\" can expand to reports/"},
+		{Layout{Artist: "{artist_id}", Artwork: "re{title}s/{id}"}, "can expand to reports/"},
+		{Layout{Artist: "{artist_id}", Artwork: "report/{id}"}, ""},
+		{Layout{Artist: "{artist_name}", Artwork: "{artist_name}/{title}"}, "must contain {id}"},
+		{Layout{Artist: "{artist_id}/{id}", Artwork: "{artist_id}/{id}"}, "artist layout: unknown placeholder {id}"},
+	}
+	for _, tt := range tests {
+		err := tt.layout.Validate()
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("Validate(%+v): %v", tt.layout, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Validate(%+v) = %v, want an error containing %q", tt.layout, err, tt.err)
+		}
+	}
+}
+
+func TestPageNumber(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		ok   bool
+	}{
+		{"p0.jpg", 0, true},
+		{"p12.png", 12, true},
+		{PageFile(7) + ".gif", 7, true},
+		{"p.jpg", 0, false},
+		{"p1", 0, false},
+		{"p-1.jpg", 0, false},
+		{"folder.jpg", 0, false},
+		{"q1.jpg", 0, false},
+	}
+	for _, tt := range tests {
+		n, ok := PageNumber(tt.name)
+		if n != tt.n || ok != tt.ok {
+			t.Errorf("PageNumber(%q) = %d, %v, want %d, %v", tt.name, n, ok, tt.n, tt.ok)
+		}
+	}
+}
+
+func TestSkipDir(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{".cache", true},
+		{".tombstones", true},
+		{"reports", true},
+		{"reports2", false},
+		{"45", false},
+	}
+	for _, tt := range tests {
+		if got := skipDir(tt.name); got != tt.want {
+			t.Errorf("skipDir(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
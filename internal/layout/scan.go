@@ -0,0 +1,251 @@
+package layout
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Magnetkopf/pGallery/internal/model"
+	"github.com/Magnetkopf/pGallery/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Library is where the artworks and artists already on disk are, whatever
+// layout they were saved with. Paths are relative to base.
+type Library struct {
+	Artworks map[int]string
+	Artists  map[int]string
+	// Broken are directories with pages but no artwork.yaml that names an
+	// artwork, e.g. left by a download that was cut off; sorted
+	Broken []string
+}
+
+// ReportsDir holds the sync reports, relative to base
+const ReportsDir = "reports"
+
+// skipDir reports whether a top level directory of base holds pGallery's own
+// files rather than artworks
+func skipDir(name string) bool {
+	// cleanSegment trims leading dots, and Validate refuses layouts that
+	// could put anything in ReportsDir
+	return strings.HasPrefix(name, ".") || name == ReportsDir
+}
+
+// ScanCache remembers the ID in every artwork.yaml and artist.yaml by
+// directory, so a later scan need not read them again
+type ScanCache struct {
+	Artworks map[string]ScanEntry `json:"artworks"`
+	Artists  map[string]ScanEntry `json:"artists"`
+}
+
+// ScanEntry is valid while the yaml file keeps its size and modification
+// time. DirModTime is that of the directory, for artworks only.
+type ScanEntry struct {
+	ID         int       `json:"id"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	DirModTime time.Time `json:"dir_mod_time,omitempty"`
+}
+
+// Scan walks base and finds every artwork.yaml and artist.yaml. A missing
+// base is an empty library.
+func Scan(base string) (*Library, error) {
+	library, _, err := ScanCached(base, ScanCache{}, 0)
+	return library, err
+}
+
+// ScanCached is Scan reusing what old knows: unchanged yaml files are not
+// parsed, and artwork directories that didn't change since are not listed at
+// all. Directories are read on up to workers goroutines (0 for one per CPU).
+// It returns the cache for the next scan.
+func ScanCached(base string, old ScanCache, workers int) (*Library, ScanCache, error) {
+	s := &scanner{
+		base: filepath.Clean(base),
+		old:  old,
+		sem:  make(chan struct{}, utils.Workers(workers)),
+		library: &Library{
+			Artworks: make(map[int]string),
+			Artists:  make(map[int]string),
+		},
+		next: ScanCache{
+			Artworks: make(map[string]ScanEntry),
+			Artists:  make(map[string]ScanEntry),
+		},
+	}
+
+	if _, err := os.Stat(base); os.IsNotExist(err) {
+		return s.library, s.next, nil
+	}
+	s.wg.Add(1)
+	s.visit(s.base)
+	s.wg.Wait()
+
+	if s.err != nil {
+		return nil, old, s.err
+	}
+	sort.Strings(s.library.Broken)
+	return s.library, s.next, nil
+}
+
+// scanner reads directories concurrently, each on its own goroutine, with
+// sem bounding how many are being read at once
+type scanner struct {
+	base string
+	old  ScanCache
+	sem  chan struct{}
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex
+	library *Library
+	next    ScanCache
+	err     error
+}
+
+func (s *scanner) visit(path string) {
+	defer s.wg.Done()
+
+	s.sem <- struct{}{}
+	subdirs, err := s.readDir(path)
+	<-s.sem
+	if err != nil {
+		s.mu.Lock()
+		if s.err == nil {
+			s.err = err
+		}
+		s.mu.Unlock()
+		return
+	}
+
+	for _, subdir := range subdirs {
+		s.wg.Add(1)
+		go s.visit(subdir)
+	}
+}
+
+// readDir records the yaml files in path and returns the subdirectories that
+// still need a visit
+func (s *scanner) readDir(path string) ([]string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var subdirs []string
+	hasPage, hasArtwork := false, false
+	for _, entry := range entries {
+		entryPath := filepath.Join(path, entry.Name())
+		if entry.IsDir() {
+			if path == s.base && skipDir(entry.Name()) {
+				continue
+			}
+			if !s.reuseArtworkDir(entryPath, entry) {
+				subdirs = append(subdirs, entryPath)
+			}
+			continue
+		}
+
+		if _, ok := PageNumber(entry.Name()); ok {
+			hasPage = true
+		}
+		switch entry.Name() {
+		case "artwork.yaml":
+			rel := relDir(s.base, entryPath)
+			scanned, ok := scanYaml(entryPath, s.old.Artworks[rel], func(content []byte) int {
+				var artwork model.ArtworkData
+				yaml.Unmarshal(content, &artwork)
+				return artwork.ID
+			})
+			if ok {
+				if dirInfo, err := os.Stat(path); err == nil {
+					scanned.DirModTime = dirInfo.ModTime()
+				}
+				s.add(s.library.Artworks, s.next.Artworks, rel, scanned)
+				hasArtwork = true
+			}
+		case "artist.yaml":
+			rel := relDir(s.base, entryPath)
+			if scanned, ok := scanYaml(entryPath, s.old.Artists[rel], func(content []byte) int {
+				var artist model.ArtistData
+				yaml.Unmarshal(content, &artist)
+				return artist.ID
+			}); ok {
+				s.add(s.library.Artists, s.next.Artists, rel, scanned)
+			}
+		}
+	}
+	if hasPage && !hasArtwork && path != s.base {
+		s.mu.Lock()
+		s.library.Broken = append(s.library.Broken, relPath(s.base, path))
+		s.mu.Unlock()
+	}
+	return subdirs, nil
+}
+
+// reuseArtworkDir records a cached artwork directory that didn't change since
+// the last scan; an artwork directory holds nothing else to find
+func (s *scanner) reuseArtworkDir(path string, entry fs.DirEntry) bool {
+	rel := relPath(s.base, path)
+	cached, ok := s.old.Artworks[rel]
+	if _, isArtist := s.old.Artists[rel]; !ok || isArtist {
+		return false
+	}
+	dirInfo, err := entry.Info()
+	if err != nil || !dirInfo.ModTime().Equal(cached.DirModTime) {
+		return false
+	}
+	yamlInfo, err := os.Stat(filepath.Join(path, "artwork.yaml"))
+	if err != nil || !cached.matches(yamlInfo) {
+		return false
+	}
+	s.add(s.library.Artworks, s.next.Artworks, rel, cached)
+	return true
+}
+
+// add records a directory. When two share an ID, the lexically last one wins
+// whichever was read first, as with a sequential walk.
+func (s *scanner) add(dirs map[int]string, cache map[string]ScanEntry, rel string, entry ScanEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if other, ok := dirs[entry.ID]; !ok || other < rel {
+		dirs[entry.ID] = rel
+	}
+	cache[rel] = entry
+}
+
+// scanYaml returns the ID in the yaml file at path, from cached when the file
+// is unchanged; ok is false for unreadable files and those without an ID
+func scanYaml(path string, cached ScanEntry, parseID func([]byte) int) (ScanEntry, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ScanEntry{}, false
+	}
+	if cached.ID != 0 && cached.matches(info) {
+		return cached, true
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ScanEntry{}, false
+	}
+	scanned := ScanEntry{ID: parseID(content), Size: info.Size(), ModTime: info.ModTime()}
+	return scanned, scanned.ID != 0
+}
+
+func (e ScanEntry) matches(info fs.FileInfo) bool {
+	return e.Size == info.Size() && e.ModTime.Equal(info.ModTime())
+}
+
+func relDir(base, path string) string {
+	return relPath(base, filepath.Dir(path))
+}
+
+func relPath(base, path string) string {
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
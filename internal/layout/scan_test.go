@@ -0,0 +1,62 @@
+package layout
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	base := t.TempDir()
+	files := map[string]string{
+		"10/artist.yaml":          "id: 10\n",
+		"10/folder.jpg":           "",
+		"10/123/artwork.yaml":     "id: 123\n",
+		"10/123/p0.jpg":           "",
+		"10/124/p0.jpg":           "", // cut off before artwork.yaml
+		"10/125/artwork.yaml":     "id: [\n",
+		"10/125/p0.png":           "",
+		"10/126/artwork.yaml":     "title: no id\n",
+		"10/126/p0.png":           "",
+		"10/127/artwork.yaml":     "id: 127\n", // no pages yet, but named
+		"10/notes/readme.md":      "",
+		"reports/20/artwork.yaml": "id: 20\n", // the sync reports
+		".cache/thumbs/1/p0.jpg":  "",
+		".reports/9/artwork.yaml": "id: 9\n",
+		"p0.jpg":                  "", // pages straight in base aren't an artwork
+	}
+	for name, content := range files {
+		path := filepath.Join(base, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	library, err := Scan(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantArtworks := map[int]string{
+		123: filepath.FromSlash("10/123"),
+		127: filepath.FromSlash("10/127"),
+	}
+	if len(library.Artworks) != len(wantArtworks) {
+		t.Errorf("Artworks = %v, want %v", library.Artworks, wantArtworks)
+	}
+	for id, dir := range wantArtworks {
+		if library.Artworks[id] != dir {
+			t.Errorf("Artworks[%d] = %q, want %q", id, library.Artworks[id], dir)
+		}
+	}
+	if library.Artists[10] != "10" || len(library.Artists) != 1 {
+		t.Errorf("Artists = %v, want 10 in 10", library.Artists)
+	}
+	wantBroken := []string{filepath.FromSlash("10/124"), filepath.FromSlash("10/125"), filepath.FromSlash("10/126")}
+	if !slices.Equal(library.Broken, wantBroken) {
+		t.Errorf("Broken = %q, want %q", library.Broken, wantBroken)
+	}
+}
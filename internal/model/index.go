@@ -1,25 +1,87 @@
 package model
 
-import "time"
+import (
+	"strconv"
+	"time"
+)
 
 type ArtworkCard struct {
 	ID        string `json:"id"`
 	ArtistID  string `json:"artist_id"`
 	Title     string `json:"title"`
 	PageCount int    `json:"page_count"`
-	Thumbnail string `json:"thumbnail"`
+	Thumbnail string `json:"thumbnail"` // grid thumbnail, or the first page when there is none
+	Path      string `json:"path"`      // artwork directory, relative to base
+	// creation date as written by pixiv, RFC 3339
+	CreateDate string `json:"create_date,omitempty"`
+	Rating     string `json:"rating,omitempty"` // RatingSafe, RatingR18 or RatingR18G
+	AI         bool   `json:"ai,omitempty"`     // AI-generated
+	// see ArtworkData
+	BookmarkID    int64 `json:"bookmark_id,omitempty"`
+	BookmarkCount int   `json:"bookmark_count,omitempty"`
+	LikeCount     int   `json:"like_count,omitempty"`
+	ViewCount     int   `json:"view_count,omitempty"`
+
+	// generated thumbnails by size name, relative to base
+	Thumbnails map[string]string `json:"thumbnails,omitempty"`
+	// one per page, zero for pages that are missing or can't be read
+	Pages []PageInfo `json:"pages,omitempty"`
+	// dominant colours, largest share first
+	Colors []Swatch `json:"colors,omitempty"`
+
+	BookmarkedBy []string `json:"bookmarked_by,omitempty"`
+}
+
+// Size returns the size in bytes of all pages that could be read
+func (c *ArtworkCard) Size() int64 {
+	var size int64
+	for _, page := range c.Pages {
+		size += page.Size
+	}
+	return size
+}
+
+type PageInfo struct {
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Aspect float64 `json:"aspect"` // width / height
+	Size   int64   `json:"size"`   // bytes
+}
+
+// IDLess orders artwork IDs as numbers, so "9" comes before "10"
+func IDLess(a, b string) bool {
+	x, errA := strconv.ParseUint(a, 10, 64)
+	y, errB := strconv.ParseUint(b, 10, 64)
+	if errA != nil || errB != nil || x == y {
+		return a < b
+	}
+	return x < y
+}
+
+// Swatch is one colour of a palette and the share of the image it covers
+type Swatch struct {
+	Hex   string  `json:"hex"` // #rrggbb
+	Share float64 `json:"share"`
 }
 
 type ArtistDetail struct {
 	Name     string         `json:"name"`
+	Path     string         `json:"path"` // artist directory, empty without artist.yaml
 	Artworks []*ArtworkCard `json:"artworks"`
 }
 
 // use string for key seems not the best practice bruh
+//
+// In memory every index points at the cards of ArtworkIndex; index.json
+// stores them once, see indexfile.go
 type Store struct {
 	ArtworkIndex map[string]*ArtworkCard   `json:"artwork_index"`
 	TagIndex     map[string][]*ArtworkCard `json:"tag_index"`
 	ArtistIndex  map[string]*ArtistDetail  `json:"artist_index"`
+	// bookmarking user ID -> artworks
+	BookmarkIndex map[string][]*ArtworkCard `json:"bookmark_index"`
+	// artwork ID -> bookmarked works pixiv no longer serves
+	LostIndex map[string]*LostArtwork `json:"lost_index"`
 
 	LastIndexed time.Time
 }
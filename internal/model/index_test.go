@@ -0,0 +1,23 @@
+package model
+
+import "testing"
+
+func TestIDLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"9", "10", true},
+		{"10", "9", false},
+		{"10", "10", false},
+		{"123456789", "1000000000", true},
+		{"007", "8", true},
+		{"abc", "10", false}, // not numbers: as strings
+		{"10", "abc", true},
+	}
+	for _, tt := range tests {
+		if got := IDLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("IDLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// IndexVersion is the schema version of the index.json written by build.
+//
+//	1 (no schema_version field) every index held full copies of the cards
+//	2 cards are stored once in artwork_index, other indexes list artwork IDs
+const IndexVersion = 2
+
+// indexFile is index.json on disk
+type indexFile struct {
+	SchemaVersion int                     `json:"schema_version"`
+	ArtworkIndex  map[string]*ArtworkCard `json:"artwork_index"`
+	TagIndex      map[string][]string     `json:"tag_index"`
+	ArtistIndex   map[string]*artistFile  `json:"artist_index"`
+	BookmarkIndex map[string][]string     `json:"bookmark_index"`
+	LostIndex     map[string]*LostArtwork `json:"lost_index"`
+
+	LastIndexed time.Time
+}
+
+type artistFile struct {
+	Name     string   `json:"name"`
+	Path     string   `json:"path"`
+	Artworks []string `json:"artworks"` // artwork IDs
+}
+
+// legacyIndex is index.json before schema versions
+type legacyIndex struct {
+	ArtworkIndex  map[string]*ArtworkCard   `json:"artwork_index"`
+	TagIndex      map[string][]*ArtworkCard `json:"tag_index"`
+	ArtistIndex   map[string]*ArtistDetail  `json:"artist_index"`
+	BookmarkIndex map[string][]*ArtworkCard `json:"bookmark_index"`
+	LostIndex     map[string]*LostArtwork   `json:"lost_index"`
+
+	LastIndexed time.Time
+}
+
+// MarshalJSON writes the store in the current schema, each card once
+func (s Store) MarshalJSON() ([]byte, error) {
+	file := indexFile{
+		SchemaVersion: IndexVersion,
+		ArtworkIndex:  s.ArtworkIndex,
+		TagIndex:      make(map[string][]string, len(s.TagIndex)),
+		ArtistIndex:   make(map[string]*artistFile, len(s.ArtistIndex)),
+		BookmarkIndex: make(map[string][]string, len(s.BookmarkIndex)),
+		LostIndex:     s.LostIndex,
+		LastIndexed:   s.LastIndexed,
+	}
+	for tag, cards := range s.TagIndex {
+		file.TagIndex[tag] = cardIDs(cards)
+	}
+	for id, artist := range s.ArtistIndex {
+		file.ArtistIndex[id] = &artistFile{Name: artist.Name, Path: artist.Path, Artworks: cardIDs(artist.Artworks)}
+	}
+	for user, cards := range s.BookmarkIndex {
+		file.BookmarkIndex[user] = cardIDs(cards)
+	}
+	return json.Marshal(file)
+}
+
+// UnmarshalJSON reads any schema version up to IndexVersion and links every
+// index to the cards in ArtworkIndex
+func (s *Store) UnmarshalJSON(data []byte) error {
+	var version struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &version); err != nil {
+		return err
+	}
+
+	switch {
+	case version.SchemaVersion > IndexVersion:
+		return fmt.Errorf("index schema version %d is newer than this pGallery supports (%d)", version.SchemaVersion, IndexVersion)
+	case version.SchemaVersion <= 1:
+		var legacy legacyIndex
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return err
+		}
+		*s = legacy.upgrade()
+		return nil
+	}
+
+	var file indexFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	*s = Store{
+		ArtworkIndex:  file.ArtworkIndex,
+		TagIndex:      make(map[string][]*ArtworkCard, len(file.TagIndex)),
+		ArtistIndex:   make(map[string]*ArtistDetail, len(file.ArtistIndex)),
+		BookmarkIndex: make(map[string][]*ArtworkCard, len(file.BookmarkIndex)),
+		LostIndex:     file.LostIndex,
+		LastIndexed:   file.LastIndexed,
+	}
+	if s.ArtworkIndex == nil {
+		s.ArtworkIndex = make(map[string]*ArtworkCard)
+	}
+	for tag, ids := range file.TagIndex {
+		s.TagIndex[tag] = s.resolve(ids)
+	}
+	for id, artist := range file.ArtistIndex {
+		s.ArtistIndex[id] = &ArtistDetail{Name: artist.Name, Path: artist.Path, Artworks: s.resolve(artist.Artworks)}
+	}
+	for user, ids := range file.BookmarkIndex {
+		s.BookmarkIndex[user] = s.resolve(ids)
+	}
+	return nil
+}
+
+// upgrade replaces the copies in a legacy index by the cards of ArtworkIndex
+func (l legacyIndex) upgrade() Store {
+	s := Store{
+		ArtworkIndex:  l.ArtworkIndex,
+		TagIndex:      make(map[string][]*ArtworkCard, len(l.TagIndex)),
+		ArtistIndex:   l.ArtistIndex,
+		BookmarkIndex: make(map[string][]*ArtworkCard, len(l.BookmarkIndex)),
+		LostIndex:     l.LostIndex,
+		LastIndexed:   l.LastIndexed,
+	}
+	if s.ArtworkIndex == nil {
+		s.ArtworkIndex = make(map[string]*ArtworkCard)
+	}
+	if s.ArtistIndex == nil {
+		s.ArtistIndex = make(map[string]*ArtistDetail)
+	}
+	for tag, cards := range l.TagIndex {
+		s.TagIndex[tag] = s.resolve(cardIDs(cards))
+	}
+	for _, artist := range s.ArtistIndex {
+		artist.Artworks = s.resolve(cardIDs(artist.Artworks))
+	}
+	for user, cards := range l.BookmarkIndex {
+		s.BookmarkIndex[user] = s.resolve(cardIDs(cards))
+	}
+	return s
+}
+
+// resolve looks artwork IDs up in ArtworkIndex, skipping unknown ones
+func (s *Store) resolve(ids []string) []*ArtworkCard {
+	cards := make([]*ArtworkCard, 0, len(ids))
+	for _, id := range ids {
+		if card, ok := s.ArtworkIndex[id]; ok {
+			cards = append(cards, card)
+		}
+	}
+	return cards
+}
+
+func cardIDs(cards []*ArtworkCard) []string {
+	ids := make([]string, len(cards))
+	for i, card := range cards {
+		ids[i] = card.ID
+	}
+	return ids
+}
@@ -0,0 +1,143 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// sameCards reports whether cards are exactly the cards of ids in s, by pointer
+func sameCards(s *Store, cards []*ArtworkCard, ids ...string) bool {
+	if len(cards) != len(ids) {
+		return false
+	}
+	for i, id := range ids {
+		if cards[i] != s.ArtworkIndex[id] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestUnmarshalLegacyIndex(t *testing.T) {
+	// version 1 had no schema_version and full copies of the cards everywhere;
+	// artwork 3 is only in the tag index, as after a half-finished edit
+	legacy := `{
+		"artwork_index": {
+			"1": {"id": "1", "artist_id": "10", "title": "one", "page_count": 1},
+			"2": {"id": "2", "artist_id": "10", "title": "two", "page_count": 2}
+		},
+		"tag_index": {
+			"a": [{"id": "1", "title": "stale copy"}, {"id": "2"}],
+			"b": [{"id": "3"}]
+		},
+		"artist_index": {
+			"10": {"name": "Alice", "path": "10", "artworks": [{"id": "2"}, {"id": "1"}]}
+		},
+		"bookmark_index": {
+			"99": [{"id": "2"}]
+		},
+		"lost_index": {
+			"5": {"id": 5, "title": "gone"}
+		}
+	}`
+
+	var s Store
+	if err := json.Unmarshal([]byte(legacy), &s); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.ArtworkIndex) != 2 || s.ArtworkIndex["1"].Title != "one" {
+		t.Fatalf("artwork index = %+v", s.ArtworkIndex)
+	}
+	tests := []struct {
+		name  string
+		cards []*ArtworkCard
+		ids   []string
+	}{
+		{"tag a", s.TagIndex["a"], []string{"1", "2"}},
+		{"tag b", s.TagIndex["b"], nil},
+		{"artist 10", s.ArtistIndex["10"].Artworks, []string{"2", "1"}},
+		{"bookmarks of 99", s.BookmarkIndex["99"], []string{"2"}},
+	}
+	for _, tt := range tests {
+		if !sameCards(&s, tt.cards, tt.ids...) {
+			t.Errorf("%s: got %d cards, want the cards of %v from artwork_index", tt.name, len(tt.cards), tt.ids)
+		}
+	}
+	if s.ArtistIndex["10"].Name != "Alice" || s.ArtistIndex["10"].Path != "10" {
+		t.Errorf("artist 10 = %+v", s.ArtistIndex["10"])
+	}
+	if s.LostIndex["5"] == nil || s.LostIndex["5"].Title != "gone" {
+		t.Errorf("lost index = %+v", s.LostIndex)
+	}
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	one := &ArtworkCard{ID: "1", ArtistID: "10", Title: "one"}
+	two := &ArtworkCard{ID: "2", ArtistID: "10", Title: "two"}
+	store := Store{
+		ArtworkIndex:  map[string]*ArtworkCard{"1": one, "2": two},
+		TagIndex:      map[string][]*ArtworkCard{"a": {one, two}},
+		ArtistIndex:   map[string]*ArtistDetail{"10": {Name: "Alice", Path: "alice", Artworks: []*ArtworkCard{two, one}}},
+		BookmarkIndex: map[string][]*ArtworkCard{"99": {one}},
+	}
+
+	data, err := json.Marshal(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"schema_version":2`) {
+		t.Errorf("marshalled index has no schema_version 2: %s", data)
+	}
+	if strings.Count(string(data), `"title":"one"`) != 1 {
+		t.Errorf("card 1 is stored more than once: %s", data)
+	}
+
+	var s Store
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatal(err)
+	}
+	if !sameCards(&s, s.TagIndex["a"], "1", "2") ||
+		!sameCards(&s, s.ArtistIndex["10"].Artworks, "2", "1") ||
+		!sameCards(&s, s.BookmarkIndex["99"], "1") {
+		t.Errorf("indexes don't point at the cards of artwork_index: %+v", s)
+	}
+	if s.ArtistIndex["10"].Path != "alice" {
+		t.Errorf("artist path = %q, want alice", s.ArtistIndex["10"].Path)
+	}
+}
+
+func TestUnmarshalIndexErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"newer schema", `{"schema_version": 3}`, "newer than this pGallery supports"},
+		{"not JSON", `{"artwork_index":`, "unexpected end"},
+		{"wrong version type", `{"schema_version": "2"}`, "cannot unmarshal"},
+		{"legacy with wrong types", `{"tag_index": {"a": "1"}}`, "cannot unmarshal"},
+		{"current with wrong types", `{"schema_version": 2, "tag_index": {"a": [1]}}`, "cannot unmarshal"},
+	}
+	for _, tt := range tests {
+		var s Store
+		err := json.Unmarshal([]byte(tt.data), &s)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalEmptyIndex(t *testing.T) {
+	for _, data := range []string{`{}`, `{"schema_version": 2}`} {
+		var s Store
+		if err := json.Unmarshal([]byte(data), &s); err != nil {
+			t.Errorf("%s: %v", data, err)
+			continue
+		}
+		if s.ArtworkIndex == nil {
+			t.Errorf("%s: artwork index is nil", data)
+		}
+	}
+}
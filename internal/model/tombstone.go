@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// Tombstone records a bookmarked artwork that pixiv no longer serves
+// (deleted, private or masked), kept in tombstones.json
+type Tombstone struct {
+	ID           int       `json:"id"`
+	ArtistID     int       `json:"artist_id"`
+	Title        string    `json:"title"`
+	ThumbnailUrl string    `json:"thumbnail_url"`
+	Error        string    `json:"error"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastChecked  time.Time `json:"last_checked"`
+}
+
+// LostArtwork is a tombstone as shown by the web UI
+type LostArtwork struct {
+	Tombstone
+	Thumbnail string `json:"thumbnail"` // local copy, relative to base
+}
@@ -17,6 +17,61 @@ type ArtworkData struct {
 	ArtistId    int       `yaml:"artist_id"`
 	ArtistName  string    `yaml:"artist_name"`
 	CreateDate  string    `yaml:"create_date"`
+	// pixiv user IDs of the synced accounts that bookmarked this artwork
+	BookmarkedBy []string `yaml:"bookmarked_by,omitempty"`
+	// pixiv's xRestrict: 0 all ages, 1 R-18, 2 R-18G
+	XRestrict int `yaml:"x_restrict,omitempty"`
+	// pixiv's aiType: 1 not AI-generated, 2 AI-generated, 0 unknown
+	AIType int `yaml:"ai_type,omitempty"`
+	// pixiv's bookmark ID of the latest bookmark by a synced account; they
+	// grow over time, so they order artworks by when they were bookmarked
+	BookmarkID int64 `yaml:"bookmark_id,omitempty"`
+	// popularity on pixiv when the artwork was downloaded
+	BookmarkCount int `yaml:"bookmark_count,omitempty"`
+	LikeCount     int `yaml:"like_count,omitempty"`
+	ViewCount     int `yaml:"view_count,omitempty"`
+}
+
+// Ratings of an artwork
+const (
+	RatingSafe = "safe"
+	RatingR18  = "r18"
+	RatingR18G = "r18g"
+)
+
+// Rating returns RatingSafe, RatingR18 or RatingR18G. Artworks synced before
+// x_restrict was recorded are rated by pixiv's R-18 and R-18G tags.
+func (a ArtworkData) Rating() string {
+	switch a.XRestrict {
+	case 1:
+		return RatingR18
+	case 2:
+		return RatingR18G
+	}
+	rating := RatingSafe
+	for _, tag := range a.Tags {
+		switch tag.Tag {
+		case "R-18G":
+			return RatingR18G
+		case "R-18":
+			rating = RatingR18
+		}
+	}
+	return rating
+}
+
+// IsAI reports whether the artwork is marked AI-generated. Artworks synced
+// before ai_type was recorded count when they have pixiv's AI生成 tag.
+func (a ArtworkData) IsAI() bool {
+	if a.AIType != 0 {
+		return a.AIType == 2
+	}
+	for _, tag := range a.Tags {
+		if tag.Tag == "AI生成" {
+			return true
+		}
+	}
+	return false
 }
 
 type ArtistData struct {
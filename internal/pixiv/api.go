@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+
+	"github.com/tidwall/gjson"
 )
 
 type Client struct {
@@ -30,7 +33,12 @@ func (c *Client) Get(dest string) (string, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		// the ajax API explains refusals in a JSON body
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return "", &StatusError{
+			Code:    resp.StatusCode,
+			Message: gjson.Get(string(body), "message").String(),
+		}
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -40,3 +48,86 @@ func (c *Client) Get(dest string) (string, error) {
 
 	return string(body), nil
 }
+
+// StatusError is a non-200 response from pixiv
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("unexpected status code: %d (%s)", e.Code, e.Message)
+	}
+	return fmt.Sprintf("unexpected status code: %d", e.Code)
+}
+
+// Gone reports whether the work was deleted or made private, so asking again
+// later won't help
+func (e *StatusError) Gone() bool {
+	return e.Code == http.StatusNotFound || GoneMessage(e.Message)
+}
+
+// Unauthorized reports whether pixiv refused the session itself, e.g. because
+// the cookie expired during a run
+func (e *StatusError) Unauthorized() bool {
+	return (e.Code == http.StatusUnauthorized || e.Code == http.StatusForbidden) && !GoneMessage(e.Message)
+}
+
+// goneMessages are parts of the messages pixiv explains deleted and private
+// works with, in the languages the API answers in
+var goneMessages = []string{
+	"削除", "非公開", "存在しない",
+	"deleted", "private", "does not exist",
+}
+
+// GoneMessage reports whether an API error message says the work was
+// deleted or made private
+func GoneMessage(message string) bool {
+	message = strings.ToLower(message)
+	for _, part := range goneMessages {
+		if strings.Contains(message, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// SessionError means the cookie does not belong to a usable pixiv session
+type SessionError struct {
+	Reason string
+}
+
+func (e *SessionError) Error() string {
+	return e.Reason
+}
+
+// WhoAmI returns the user ID of the account the cookie is logged in as
+func (c *Client) WhoAmI() (string, error) {
+	res, err := c.Get("https://www.pixiv.net/touch/ajax/user/self/status?lang=en")
+	if err != nil {
+		return "", fmt.Errorf("failed to check session: %w", err)
+	}
+
+	if gjson.Get(res, "error").Bool() {
+		return "", &SessionError{Reason: "cookie rejected by pixiv: " + gjson.Get(res, "message").String()}
+	}
+
+	userID := gjson.Get(res, "body.user_status.user_id").String()
+	if userID == "" || userID == "0" {
+		return "", &SessionError{Reason: "cookie expired or not logged in, export a fresh one from your browser"}
+	}
+	return userID, nil
+}
+
+// CheckSession verifies that the cookie is logged in as userID
+func (c *Client) CheckSession(userID string) error {
+	self, err := c.WhoAmI()
+	if err != nil {
+		return err
+	}
+	if self != userID {
+		return &SessionError{Reason: fmt.Sprintf("wrong user: cookie is logged in as %s, not %s", self, userID)}
+	}
+	return nil
+}
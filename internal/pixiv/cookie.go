@@ -0,0 +1,171 @@
+package pixiv
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	stdinOnce   sync.Once
+	stdinCookie []byte
+	stdinErr    error
+)
+
+// LoadCookie reads a cookie from source and returns it as a Cookie header
+// value. source is "env:NAME" for an environment variable, "-" for stdin, or
+// a file path. The content may be a raw header value, a Netscape cookies.txt
+// or a browser-exported JSON cookie list; only pixiv.net cookies are kept.
+func LoadCookie(source string) (string, error) {
+	var data []byte
+	switch {
+	case strings.HasPrefix(source, "env:"):
+		name := strings.TrimPrefix(source, "env:")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		data = []byte(value)
+	case source == "-":
+		// several accounts may point at stdin, it can only be read once
+		stdinOnce.Do(func() {
+			stdinCookie, stdinErr = io.ReadAll(os.Stdin)
+		})
+		if stdinErr != nil {
+			return "", fmt.Errorf("failed to read cookie from stdin: %w", stdinErr)
+		}
+		data = stdinCookie
+	default:
+		var err error
+		data, err = os.ReadFile(source)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	cookie, err := ParseCookie(data)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", source, err)
+	}
+	return cookie, nil
+}
+
+// IsCookieFile reports whether a LoadCookie source is a file path, not an
+// environment variable or stdin
+func IsCookieFile(source string) bool {
+	return !strings.HasPrefix(source, "env:") && source != "-"
+}
+
+// ParseCookie detects the cookie format of data and converts it to a Cookie header value
+func ParseCookie(data []byte) (string, error) {
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return "", errors.New("cookie is empty")
+	}
+
+	switch {
+	case strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{"):
+		return parseJSONCookies(text)
+	case isNetscapeCookies(text):
+		return parseNetscapeCookies(text)
+	default:
+		// raw header value, possibly copied together with the header name
+		if len(text) > 7 && strings.EqualFold(text[:7], "cookie:") {
+			text = strings.TrimSpace(text[7:])
+		}
+		return text, nil
+	}
+}
+
+type exportedCookie struct {
+	Name           string  `json:"name"`
+	Value          string  `json:"value"`
+	Domain         string  `json:"domain"`
+	ExpirationDate float64 `json:"expirationDate"`
+}
+
+// parseJSONCookies reads the cookie lists written by browser extensions,
+// either a bare array or an object with a "cookies" array
+func parseJSONCookies(text string) (string, error) {
+	var cookies []exportedCookie
+	if strings.HasPrefix(text, "{") {
+		var wrapper struct {
+			Cookies []exportedCookie `json:"cookies"`
+		}
+		if err := json.Unmarshal([]byte(text), &wrapper); err != nil {
+			return "", fmt.Errorf("invalid JSON cookie file: %w", err)
+		}
+		cookies = wrapper.Cookies
+	} else if err := json.Unmarshal([]byte(text), &cookies); err != nil {
+		return "", fmt.Errorf("invalid JSON cookie file: %w", err)
+	}
+
+	var pairs []string
+	for _, c := range cookies {
+		if !isPixivDomain(c.Domain) || c.Name == "" {
+			continue
+		}
+		if c.ExpirationDate > 0 && time.Unix(int64(c.ExpirationDate), 0).Before(time.Now()) {
+			continue
+		}
+		pairs = append(pairs, c.Name+"="+c.Value)
+	}
+	return joinPixivCookies(pairs)
+}
+
+func isNetscapeCookies(text string) bool {
+	if strings.HasPrefix(text, "# Netscape HTTP Cookie File") || strings.HasPrefix(text, "# HTTP Cookie File") {
+		return true
+	}
+	line, _, _ := strings.Cut(text, "\n")
+	return len(strings.Split(strings.TrimRight(line, "\r"), "\t")) == 7
+}
+
+// parseNetscapeCookies reads the cookies.txt format used by curl, wget and yt-dlp:
+// domain, subdomains, path, secure, expiry, name, value separated by tabs
+func parseNetscapeCookies(text string) (string, error) {
+	var pairs []string
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			continue
+		}
+		if !isPixivDomain(fields[0]) {
+			continue
+		}
+		if expiry, err := strconv.ParseInt(fields[4], 10, 64); err == nil && expiry > 0 && time.Unix(expiry, 0).Before(time.Now()) {
+			continue
+		}
+		pairs = append(pairs, fields[5]+"="+fields[6])
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return joinPixivCookies(pairs)
+}
+
+func joinPixivCookies(pairs []string) (string, error) {
+	if len(pairs) == 0 {
+		return "", errors.New("no unexpired pixiv.net cookies found")
+	}
+	return strings.Join(pairs, "; "), nil
+}
+
+func isPixivDomain(domain string) bool {
+	domain = strings.TrimPrefix(strings.ToLower(domain), ".")
+	return domain == "pixiv.net" || strings.HasSuffix(domain, ".pixiv.net")
+}
@@ -0,0 +1,142 @@
+package pixiv
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseCookie(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"raw", "PHPSESSID=123_abc; device_token=x", "PHPSESSID=123_abc; device_token=x"},
+		{"raw with header name", "Cookie: PHPSESSID=123_abc", "PHPSESSID=123_abc"},
+		{"raw with spaces", "  \n PHPSESSID=1 \n", "PHPSESSID=1"},
+		{
+			"netscape",
+			"# Netscape HTTP Cookie File\n" +
+				".pixiv.net\tTRUE\t/\tTRUE\t0\tPHPSESSID\t123_abc\n" +
+				"#HttpOnly_.pixiv.net\tTRUE\t/\tTRUE\t0\tdevice_token\tx\n" +
+				".example.com\tTRUE\t/\tFALSE\t0\tother\ty\n",
+			"PHPSESSID=123_abc; device_token=x",
+		},
+		{
+			"netscape without header, CRLF",
+			"www.pixiv.net\tFALSE\t/\tTRUE\t0\tPHPSESSID\t1\r\n",
+			"PHPSESSID=1",
+		},
+		{
+			"netscape skips expired",
+			".pixiv.net\tTRUE\t/\tTRUE\t1\told\tgone\n.pixiv.net\tTRUE\t/\tTRUE\t4102444800\tPHPSESSID\t1\n",
+			"PHPSESSID=1",
+		},
+		{
+			"json array",
+			`[{"name":"PHPSESSID","value":"1","domain":".pixiv.net"},{"name":"x","value":"2","domain":"example.com"}]`,
+			"PHPSESSID=1",
+		},
+		{
+			"json object",
+			`{"cookies":[{"name":"PHPSESSID","value":"1","domain":"WWW.PIXIV.NET","expirationDate":4102444800.5}]}`,
+			"PHPSESSID=1",
+		},
+		{
+			"json skips expired",
+			`[{"name":"old","value":"0","domain":".pixiv.net","expirationDate":1},{"name":"PHPSESSID","value":"1","domain":"pixiv.net"}]`,
+			"PHPSESSID=1",
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseCookie([]byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseCookieErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"empty", "", "cookie is empty"},
+		{"blank", " \n\t", "cookie is empty"},
+		{"broken json", `[{"name":`, "invalid JSON cookie file"},
+		{"broken json object", `{"cookies":1}`, "invalid JSON cookie file"},
+		{"json without pixiv", `[{"name":"a","value":"1","domain":"notpixiv.net"}]`, "no unexpired pixiv.net cookies"},
+		{"netscape without pixiv", "# Netscape HTTP Cookie File\n.example.com\tTRUE\t/\tFALSE\t0\ta\t1\n", "no unexpired pixiv.net cookies"},
+		{"netscape all expired", ".pixiv.net\tTRUE\t/\tTRUE\t1\tPHPSESSID\t1\n", "no unexpired pixiv.net cookies"},
+	}
+	for _, tt := range tests {
+		_, err := ParseCookie([]byte(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestLoadCookie(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "cookie.txt")
+	if err := os.WriteFile(file, []byte("PHPSESSID=from_file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PGALLERY_TEST_COOKIE", "Cookie: PHPSESSID=from_env")
+	t.Setenv("PGALLERY_TEST_EMPTY", "")
+
+	tests := []struct {
+		source string
+		want   string
+		err    string
+	}{
+		{file, "PHPSESSID=from_file", ""},
+		{"env:PGALLERY_TEST_COOKIE", "PHPSESSID=from_env", ""},
+		{"env:PGALLERY_TEST_UNSET", "", "PGALLERY_TEST_UNSET is not set"},
+		{"env:PGALLERY_TEST_EMPTY", "", "cookie is empty"},
+		{filepath.Join(dir, "missing.txt"), "", "missing.txt"},
+	}
+	for _, tt := range tests {
+		got, err := LoadCookie(tt.source)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("LoadCookie(%q): got error %v, want one containing %q", tt.source, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("LoadCookie(%q): %v", tt.source, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("LoadCookie(%q) = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestIsCookieFile(t *testing.T) {
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{"cookie.txt", true},
+		{"/abs/cookie.txt", true},
+		{"env.txt", true},
+		{"env:PIXIV_COOKIE", false},
+		{"-", false},
+		{"-cookie.txt", true},
+	}
+	for _, tt := range tests {
+		if got := IsCookieFile(tt.source); got != tt.want {
+			t.Errorf("IsCookieFile(%q) = %v, want %v", tt.source, got, tt.want)
+		}
+	}
+}
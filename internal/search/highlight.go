@@ -0,0 +1,98 @@
+package search
+
+// Fragment is a piece of text, Match when it matches the query
+type Fragment struct {
+	Text  string
+	Match bool
+}
+
+// Highlighter finds the terms of a query in text, the way Search matches
+// them: words from their start to the end of the word, CJK characters
+// wherever they are
+type Highlighter struct {
+	terms []term
+}
+
+func NewHighlighter(query string) Highlighter {
+	return Highlighter{terms: queryTerms(query)}
+}
+
+// marks tells for every rune of text whether it is part of a match
+func (h Highlighter) marks(runes []rune) []bool {
+	norm := make([]rune, len(runes))
+	for i, r := range runes {
+		norm[i] = normalize(r)
+	}
+
+	marks := make([]bool, len(runes))
+	for _, t := range h.terms {
+		needle := []rune(t.text)
+		word := isWord(needle[0])
+		for i := 0; i+len(needle) <= len(norm); i++ {
+			if string(norm[i:i+len(needle)]) != t.text {
+				continue
+			}
+			end := i + len(needle)
+			if word {
+				if i > 0 && isWord(norm[i-1]) {
+					continue
+				}
+				for end < len(norm) && isWord(norm[end]) {
+					end++
+				}
+			}
+			for j := i; j < end; j++ {
+				marks[j] = true
+			}
+		}
+	}
+	return marks
+}
+
+// Highlight splits text into fragments that do and don't match
+func (h Highlighter) Highlight(text string) []Fragment {
+	runes := []rune(text)
+	return fragments(runes, h.marks(runes))
+}
+
+// Snippet returns about width characters of text around the first match,
+// with "…" where it was cut; ok is false when nothing matches
+func (h Highlighter) Snippet(text string, width int) (snippet []Fragment, ok bool) {
+	runes := []rune(text)
+	marks := h.marks(runes)
+	first := -1
+	for i, marked := range marks {
+		if marked {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return nil, false
+	}
+
+	start := max(0, first-width/3)
+	end := min(len(runes), start+width)
+	start = max(0, end-width)
+	snippet = fragments(runes[start:end], marks[start:end])
+	if start > 0 {
+		snippet = append([]Fragment{{Text: "…"}}, snippet...)
+	}
+	if end < len(runes) {
+		snippet = append(snippet, Fragment{Text: "…"})
+	}
+	return snippet, true
+}
+
+func fragments(runes []rune, marks []bool) []Fragment {
+	var result []Fragment
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && marks[j] == marks[i] {
+			j++
+		}
+		result = append(result, Fragment{Text: string(runes[i:j]), Match: marks[i]})
+		i = j
+	}
+	return result
+}
@@ -0,0 +1,289 @@
+// Package search is the full-text index over artwork titles, descriptions and
+// tags that build writes and the web UI searches
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Magnetkopf/pGallery/internal/model"
+)
+
+// File holds the index written by build, relative to base
+const File = ".cache/search.json"
+
+// indexVersion changes whenever the format of File or the tokenisation does
+const indexVersion = 1
+
+// How much a term counts by the field it is found in
+const (
+	titleWeight       = 3
+	tagWeight         = 2
+	descriptionWeight = 1
+)
+
+// BM25 parameters: k1 caps what repeating a term adds, b how much long
+// documents are held back
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// prefixFactor is what a word found only as the start of a longer one counts
+const prefixFactor = 0.5
+
+// Document is the searchable text of an artwork
+type Document struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"` // plain text
+	// tag, romaji and English translation of every tag, when set
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Posting is an artwork a term occurs in, with the field weights of every
+// occurrence added up
+type Posting struct {
+	ID     string  `json:"id"`
+	Weight float64 `json:"w"`
+}
+
+// Index maps terms to the artworks they occur in
+type Index struct {
+	Version int                  `json:"version"`
+	Docs    map[string]Document  `json:"docs"`
+	Lengths map[string]float64   `json:"lengths"` // weighted term count per artwork
+	Terms   map[string][]Posting `json:"terms"`
+
+	sorted    []string // the keys of Terms, for prefix lookups
+	avgLength float64
+}
+
+// Result is a matching artwork; a higher score is a better match
+type Result struct {
+	ID    string
+	Score float64
+}
+
+var (
+	lineBreak = regexp.MustCompile(`(?i)<br\s*/?>`)
+	htmlTag   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// NewDocument collects the searchable text of an artwork. pixiv descriptions
+// are HTML, only their text is kept.
+func NewDocument(data model.ArtworkData) Document {
+	description := lineBreak.ReplaceAllString(data.Description, "\n")
+	description = html.UnescapeString(htmlTag.ReplaceAllString(description, ""))
+	doc := Document{Title: data.Title, Description: strings.TrimSpace(description)}
+	for _, tag := range data.Tags {
+		for _, text := range []string{tag.Tag, tag.Romaji, tag.Translation} {
+			if text != "" {
+				doc.Tags = append(doc.Tags, text)
+			}
+		}
+	}
+	return doc
+}
+
+// Build indexes docs by artwork ID
+func Build(docs map[string]Document) *Index {
+	idx := &Index{
+		Version: indexVersion,
+		Docs:    docs,
+		Lengths: make(map[string]float64, len(docs)),
+		Terms:   make(map[string][]Posting),
+	}
+	for id, doc := range docs {
+		weights := make(map[string]float64)
+		length := 0.0
+		add := func(text string, weight float64) {
+			for _, token := range Tokens(text) {
+				weights[token] += weight
+				length += weight
+			}
+		}
+		add(doc.Title, titleWeight)
+		for _, tag := range doc.Tags {
+			add(tag, tagWeight)
+		}
+		add(doc.Description, descriptionWeight)
+
+		idx.Lengths[id] = length
+		for token, weight := range weights {
+			idx.Terms[token] = append(idx.Terms[token], Posting{ID: id, Weight: weight})
+		}
+	}
+	// the same documents always give the same file
+	for _, postings := range idx.Terms {
+		sort.Slice(postings, func(i, j int) bool {
+			return postings[i].ID < postings[j].ID
+		})
+	}
+	idx.prepare()
+	return idx
+}
+
+func (idx *Index) prepare() {
+	idx.sorted = make([]string, 0, len(idx.Terms))
+	for token := range idx.Terms {
+		idx.sorted = append(idx.sorted, token)
+	}
+	sort.Strings(idx.sorted)
+
+	total := 0.0
+	for _, length := range idx.Lengths {
+		total += length
+	}
+	idx.avgLength = 1
+	if len(idx.Lengths) > 0 && total > 0 {
+		idx.avgLength = total / float64(len(idx.Lengths))
+	}
+}
+
+// Write builds the index of docs and writes it to File
+func Write(base string, docs map[string]Document) error {
+	path := filepath.Join(base, File)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	content, err := json.Marshal(Build(docs))
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Load reads the index written by build
+func Load(base string) (*Index, error) {
+	content, err := os.ReadFile(filepath.Join(base, File))
+	if err != nil {
+		return nil, fmt.Errorf("no search index, run build: %w", err)
+	}
+	var idx Index
+	if err := json.Unmarshal(content, &idx); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", File, err)
+	}
+	if idx.Version != indexVersion {
+		return nil, fmt.Errorf("%s has version %d, expected %d, run build", File, idx.Version, indexVersion)
+	}
+	idx.prepare()
+	return &idx, nil
+}
+
+// Doc returns the searchable text of an artwork
+func (idx *Index) Doc(id string) (Document, bool) {
+	doc, ok := idx.Docs[id]
+	return doc, ok
+}
+
+// Search returns the artworks that contain every term of query, best match
+// first. Terms are found as whole words, words also as the start of a longer
+// word (counting less); Japanese needs all bigrams of the query.
+func (idx *Index) Search(query string) []Result {
+	terms := queryTerms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	var scores map[string]float64
+	for _, t := range terms {
+		found := idx.termScores(t)
+		if scores == nil {
+			scores = found
+		} else {
+			for id := range scores {
+				if score, ok := found[id]; ok {
+					scores[id] += score
+				} else {
+					delete(scores, id)
+				}
+			}
+		}
+		if len(scores) == 0 {
+			return nil
+		}
+	}
+
+	results := make([]Result, 0, len(scores))
+	for id, score := range scores {
+		results = append(results, Result{ID: id, Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return model.IDLess(results[j].ID, results[i].ID)
+	})
+	return results
+}
+
+// termScores returns the BM25 score of one query term per artwork; with
+// several matching terms the best one counts
+func (idx *Index) termScores(t term) map[string]float64 {
+	scores := make(map[string]float64)
+	n := float64(len(idx.Lengths))
+	match := func(token string, factor float64) {
+		postings := idx.Terms[token]
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for _, p := range postings {
+			norm := 1 - b + b*idx.Lengths[p.ID]/idx.avgLength
+			score := factor * idf * p.Weight * (k1 + 1) / (p.Weight + k1*norm)
+			if score > scores[p.ID] {
+				scores[p.ID] = score
+			}
+		}
+	}
+
+	match(t.text, 1)
+	if t.prefix {
+		// a single CJK character is indexed inside bigrams, those are full matches
+		factor := prefixFactor
+		if r, _ := utf8.DecodeRuneInString(t.text); isCJK(r) {
+			factor = 1
+		}
+		for i := sort.SearchStrings(idx.sorted, t.text); i < len(idx.sorted) && strings.HasPrefix(idx.sorted[i], t.text); i++ {
+			if idx.sorted[i] != t.text {
+				match(idx.sorted[i], factor)
+			}
+		}
+	}
+	return scores
+}
+
+// Rank orders ids by how well they match query, best first; those that
+// don't contain every term, such as ones found through an OR, follow by
+// descending ID
+func (idx *Index) Rank(query string, ids []string) []string {
+	left := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		left[id] = true
+	}
+	ranked := make([]string, 0, len(ids))
+	for _, result := range idx.Search(query) {
+		if left[result.ID] {
+			ranked = append(ranked, result.ID)
+			delete(left, result.ID)
+		}
+	}
+	rest := make([]string, 0, len(left))
+	for id := range left {
+		rest = append(rest, id)
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		return model.IDLess(rest[j], rest[i])
+	})
+	return append(ranked, rest...)
+}
@@ -0,0 +1,112 @@
+package search
+
+import (
+	"unicode"
+)
+
+// term is a search term of a query; a prefix term also matches the indexed
+// terms that start with it
+type term struct {
+	text   string
+	prefix bool
+}
+
+// segment is a run of runes that belong together, a word or a run of CJK
+// characters; start and end are rune offsets
+type segment struct {
+	start, end int
+	cjk        bool
+}
+
+// normalize folds a rune for matching: lower case, and full-width Latin
+// letters and digits to their ASCII forms
+func normalize(r rune) rune {
+	if r >= 0xFF01 && r <= 0xFF5E {
+		r -= 0xFEE0
+	}
+	return unicode.ToLower(r)
+}
+
+func normalizeRunes(text string) []rune {
+	runes := []rune(text)
+	for i, r := range runes {
+		runes[i] = normalize(r)
+	}
+	return runes
+}
+
+// isCJK reports whether r is written without spaces between words
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		r == 'ー' // prolonged sound mark, script Common
+}
+
+// isWord reports whether r is part of a space separated word
+func isWord(r rune) bool {
+	return (unicode.IsLetter(r) || unicode.IsDigit(r)) && !isCJK(r)
+}
+
+// segments splits normalized runes into words and CJK runs, dropping
+// everything else
+func segments(runes []rune) []segment {
+	var segs []segment
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		if !isWord(r) && !isCJK(r) {
+			i++
+			continue
+		}
+		cjk := isCJK(r)
+		start := i
+		for i < len(runes) && (cjk && isCJK(runes[i]) || !cjk && isWord(runes[i])) {
+			i++
+		}
+		segs = append(segs, segment{start: start, end: i, cjk: cjk})
+	}
+	return segs
+}
+
+// Tokens splits text into the terms it is indexed under: lower-cased words
+// of letters and digits, and overlapping character bigrams of Japanese,
+// Chinese and Korean text, which isn't split into words by spaces. The last
+// character of a CJK run is a term of its own, so every character starts a
+// term and a one character query finds it.
+func Tokens(text string) []string {
+	runes := normalizeRunes(text)
+	var tokens []string
+	for _, seg := range segments(runes) {
+		if !seg.cjk {
+			tokens = append(tokens, string(runes[seg.start:seg.end]))
+			continue
+		}
+		for i := seg.start; i+1 < seg.end; i++ {
+			tokens = append(tokens, string(runes[i:i+2]))
+		}
+		tokens = append(tokens, string(runes[seg.end-1]))
+	}
+	return tokens
+}
+
+// queryTerms splits a query like Tokens, except that words and single CJK
+// characters match as prefixes and runs of CJK text only by their bigrams
+func queryTerms(query string) []term {
+	runes := normalizeRunes(query)
+	var terms []term
+	seen := make(map[term]bool)
+	add := func(t term) {
+		if !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	for _, seg := range segments(runes) {
+		if !seg.cjk || seg.end-seg.start == 1 {
+			add(term{text: string(runes[seg.start:seg.end]), prefix: true})
+			continue
+		}
+		for i := seg.start; i+1 < seg.end; i++ {
+			add(term{text: string(runes[i : i+2])})
+		}
+	}
+	return terms
+}
@@ -0,0 +1,106 @@
+package search
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"Red Fox", []string{"red", "fox"}},
+		{"  blue-sky, 2023!  ", []string{"blue", "sky", "2023"}},
+		{"ＡＢＣ１２３", []string{"abc123"}},
+		{"Été", []string{"été"}},
+		{"東方", []string{"東方", "方"}},
+		{"東方Project", []string{"東方", "方", "project"}},
+		{"猫", []string{"猫"}},
+		{"初音ミク", []string{"初音", "音ミ", "ミク", "ク"}},
+		{"ラーメン", []string{"ラー", "ーメ", "メン", "ン"}},
+		{"한국어", []string{"한국", "국어", "어"}},
+		{"風景 空", []string{"風景", "景", "空"}},
+		{"a・b", []string{"a", "b"}},
+		{"!?", nil},
+	}
+	for _, tt := range tests {
+		if got := Tokens(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("Tokens(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestQueryTerms(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []term
+	}{
+		{"", nil},
+		{"Fox", []term{{"fox", true}}},
+		{"fox FOX", []term{{"fox", true}}},
+		{"猫", []term{{"猫", true}}},
+		{"東方", []term{{"東方", false}}},
+		{"初音ミク", []term{{"初音", false}, {"音ミ", false}, {"ミク", false}}},
+		{"東方 red", []term{{"東方", false}, {"red", true}}},
+		{"ＦＯＸ", []term{{"fox", true}}},
+		{"...", nil},
+	}
+	for _, tt := range tests {
+		if got := queryTerms(tt.query); !slices.Equal(got, tt.want) {
+			t.Errorf("queryTerms(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	idx := Build(map[string]Document{
+		"9":   {Title: "Red fox"},
+		"10":  {Title: "Red fox"},
+		"11":  {Title: "Fox", Tags: []string{"red"}},
+		"200": {Title: "東方Project 風景"},
+		"300": {Title: "Foxglove"},
+		"400": {Description: "a red fox"},
+	})
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// same score: higher ID first, as numbers
+		{"red fox", []string{"10", "9", "11", "400"}},
+		{"東方", []string{"200"}},
+		{"方", []string{"200"}},
+		{"風景", []string{"200"}},
+		{"東風", nil},
+		{"project", []string{"200"}},
+		// words match as the start of longer ones
+		{"foxg", []string{"300"}},
+		{"glove", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, result := range idx.Search(tt.query) {
+			got = append(got, result.ID)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	idx := Build(map[string]Document{
+		"9":   {Title: "red"},
+		"10":  {Title: "blue"},
+		"80":  {Title: "green"},
+		"100": {Title: "red red"},
+	})
+	// those that don't match follow by descending numeric ID
+	got := idx.Rank("red", []string{"9", "10", "80", "100"})
+	want := []string{"100", "9", "80", "10"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Rank = %v, want %v", got, want)
+	}
+}
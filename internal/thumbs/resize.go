@@ -0,0 +1,94 @@
+package thumbs
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// flatten converts img to RGBA on a white background, so transparent PNGs
+// don't turn black as JPEG
+func flatten(img image.Image, rect image.Rectangle) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Over)
+	return dst
+}
+
+// contribution is the weight of one source pixel in one target pixel
+type contribution struct {
+	index  int
+	weight float32
+}
+
+// areaWeights maps each of dstLen pixels to the source pixels it covers,
+// weighted by how much of them it covers
+func areaWeights(srcLen, dstLen int) [][]contribution {
+	scale := float64(srcLen) / float64(dstLen)
+	weights := make([][]contribution, dstLen)
+	for i := range weights {
+		start, end := float64(i)*scale, float64(i+1)*scale
+		for j := int(start); j < srcLen && float64(j) < end; j++ {
+			overlap := min(end, float64(j+1)) - max(start, float64(j))
+			if overlap > 0 {
+				weights[i] = append(weights[i], contribution{index: j, weight: float32(overlap / scale)})
+			}
+		}
+	}
+	return weights
+}
+
+// resize shrinks src to width x height by averaging the covered area of
+// every target pixel, which keeps fine lines instead of dropping them
+func resize(src *image.RGBA, width, height int) *image.RGBA {
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+	xWeights := areaWeights(srcW, width)
+	yWeights := areaWeights(srcH, height)
+
+	// horizontal pass into a float buffer, srcH rows of width pixels
+	rows := make([]float32, srcH*width*3)
+	for y := 0; y < srcH; y++ {
+		line := src.Pix[y*src.Stride:]
+		out := rows[y*width*3:]
+		for x, contributions := range xWeights {
+			var r, g, b float32
+			for _, c := range contributions {
+				p := line[c.index*4:]
+				r += float32(p[0]) * c.weight
+				g += float32(p[1]) * c.weight
+				b += float32(p[2]) * c.weight
+			}
+			out[x*3], out[x*3+1], out[x*3+2] = r, g, b
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, contributions := range yWeights {
+		line := dst.Pix[y*dst.Stride:]
+		for x := 0; x < width; x++ {
+			var r, g, b float32
+			for _, c := range contributions {
+				p := rows[(c.index*width+x)*3:]
+				r += p[0] * c.weight
+				g += p[1] * c.weight
+				b += p[2] * c.weight
+			}
+			line[x*4] = clamp(r)
+			line[x*4+1] = clamp(g)
+			line[x*4+2] = clamp(b)
+			line[x*4+3] = 0xff
+		}
+	}
+	return dst
+}
+
+func clamp(v float32) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	default:
+		return uint8(v + 0.5)
+	}
+}
@@ -0,0 +1,165 @@
+package thumbs
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Dir holds the thumbnails, one folder per artwork, relative to base. They are
+// keyed by artwork ID so a relayout doesn't have to move them.
+const Dir = ".cache/thumbs"
+
+const jpegQuality = 85
+
+// Size is one thumbnail size. Crop fills Width x Height exactly, cutting off
+// what sticks out; otherwise the image is fitted inside. Images are never
+// enlarged.
+type Size struct {
+	Name   string
+	Width  int
+	Height int
+	Crop   bool
+}
+
+var Sizes = []Size{
+	{Name: "grid", Width: 440, Height: 586, Crop: true}, // 3:4 cards of the web grid, at 2x
+	{Name: "small", Width: 320, Height: 320},
+	{Name: "medium", Width: 1200, Height: 1200},
+}
+
+// Folder is the size used for folder.jpg, the cover image file managers show
+const Folder = "medium"
+
+// Path is where a thumbnail of an artwork's first page is, relative to base
+func Path(artworkID int, size string) string {
+	return Dir + "/" + strconv.Itoa(artworkID) + "/" + size + ".jpg"
+}
+
+// Find returns the thumbnails of an artwork that exist, by size name
+func Find(base string, artworkID int) map[string]string {
+	found := make(map[string]string)
+	for _, size := range Sizes {
+		path := Path(artworkID, size.Name)
+		if _, err := os.Stat(filepath.Join(base, filepath.FromSlash(path))); err == nil {
+			found[size.Name] = path
+		}
+	}
+	return found
+}
+
+// Generate writes the thumbnails of an artwork from source, its first page.
+// Sizes that exist already are kept unless force is set. It returns how many
+// were written.
+func Generate(base string, artworkID int, source string, force bool) (int, error) {
+	var missing []Size
+	for _, size := range Sizes {
+		path := filepath.Join(base, filepath.FromSlash(Path(artworkID, size.Name)))
+		if _, err := os.Stat(path); force || err != nil {
+			missing = append(missing, size)
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+
+	img, err := Decode(source)
+	if err != nil {
+		return 0, err
+	}
+
+	dir := filepath.Join(base, filepath.FromSlash(Dir), strconv.Itoa(artworkID))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+	for i, size := range missing {
+		if err := writeJPEG(filepath.Join(dir, size.Name+".jpg"), Render(img, size)); err != nil {
+			return i, err
+		}
+	}
+	return len(missing), nil
+}
+
+// Decode reads the image at path
+func Decode(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return img, nil
+}
+
+// Encode writes img as "jpeg" or "png"
+func Encode(w io.Writer, img image.Image, format string) error {
+	if format == "png" {
+		return png.Encode(w, img)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+}
+
+// Render scales img down to size
+func Render(img image.Image, size Size) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return img
+	}
+
+	rect := bounds
+	if size.Crop {
+		// cut the sides of wide images, and the bottom of tall ones more than
+		// the top, where faces usually are
+		target := float64(size.Width) / float64(size.Height)
+		if float64(w)/float64(h) > target {
+			cropW := int(float64(h) * target)
+			x0 := bounds.Min.X + (w-cropW)/2
+			rect = image.Rect(x0, bounds.Min.Y, x0+cropW, bounds.Max.Y)
+		} else {
+			cropH := int(float64(w) / target)
+			y0 := bounds.Min.Y + (h-cropH)/4
+			rect = image.Rect(bounds.Min.X, y0, bounds.Max.X, y0+cropH)
+		}
+		w, h = rect.Dx(), rect.Dy()
+	}
+
+	scale := min(float64(size.Width)/float64(w), float64(size.Height)/float64(h), 1)
+	width := max(1, int(float64(w)*scale+0.5))
+	height := max(1, int(float64(h)*scale+0.5))
+
+	flat := flatten(img, rect)
+	if width == w && height == h {
+		return flat
+	}
+	return resize(flat, width, height)
+}
+
+// writeJPEG writes through a temporary file, so a reader never sees half a thumbnail
+func writeJPEG(path string, img image.Image) error {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := Encode(f, img, "jpeg"); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
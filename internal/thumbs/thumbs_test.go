@@ -0,0 +1,104 @@
+package thumbs
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+func TestRenderSize(t *testing.T) {
+	grid := Size{Width: 440, Height: 586, Crop: true}
+	small := Size{Width: 320, Height: 320}
+	tests := []struct {
+		name          string
+		width, height int
+		size          Size
+		wantW, wantH  int
+	}{
+		{"wide, cropped to the sides", 2000, 1000, grid, 440, 586},
+		{"tall, cropped at the bottom", 1000, 3000, grid, 440, 586},
+		{"exact", 880, 1172, grid, 440, 586},
+		{"smaller crop, not enlarged", 300, 1200, grid, 300, 399},
+		{"wide fitted", 1000, 500, small, 320, 160},
+		{"tall fitted", 500, 1000, small, 160, 320},
+		{"small kept", 100, 50, small, 100, 50},
+		{"a line stays a pixel", 10000, 1, small, 320, 1},
+	}
+	for _, tt := range tests {
+		got := Render(image.NewRGBA(image.Rect(0, 0, tt.width, tt.height)), tt.size).Bounds()
+		if got.Dx() != tt.wantW || got.Dy() != tt.wantH {
+			t.Errorf("%s: %dx%d to %dx%d, want %dx%d", tt.name, tt.width, tt.height, got.Dx(), got.Dy(), tt.wantW, tt.wantH)
+		}
+	}
+}
+
+// bands is a w x h image in red above y0, blue from y0 to y1 and green below
+func bands(w, h, y0, y1 int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, image.Rect(0, 0, w, y0), image.NewUniform(color.RGBA{R: 255, A: 255}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, y0, w, y1), image.NewUniform(color.RGBA{B: 255, A: 255}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, y1, w, h), image.NewUniform(color.RGBA{G: 255, A: 255}), image.Point{}, draw.Src)
+	return img
+}
+
+func TestRenderCrop(t *testing.T) {
+	// 300x1200 into 3:4 keeps 399 rows; a quarter of the 801 cut goes from
+	// the top, rows 200 to 598
+	size := Size{Width: 440, Height: 586, Crop: true}
+	blue := color.RGBA{B: 255, A: 255}
+	got := Render(bands(300, 1200, 200, 599), size)
+	for _, y := range []int{0, got.Bounds().Dy() - 1} {
+		if c := got.At(0, y); c != blue {
+			t.Errorf("row %d = %v, want the blue band", y, c)
+		}
+	}
+
+	// the same in an image whose bounds don't start at 0,0
+	sub := bands(300, 1300, 300, 699).SubImage(image.Rect(0, 100, 300, 1300))
+	got = Render(sub, size)
+	for _, y := range []int{0, got.Bounds().Dy() - 1} {
+		if c := got.At(0, y); c != blue {
+			t.Errorf("sub-image row %d = %v, want the blue band", y, c)
+		}
+	}
+}
+
+func TestRenderFlattens(t *testing.T) {
+	// transparent pixels turn white, not black
+	got := Render(image.NewNRGBA(image.Rect(0, 0, 4, 4)), Size{Width: 2, Height: 2})
+	if c := color.RGBAModel.Convert(got.At(1, 1)); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("transparent pixel = %v, want white", c)
+	}
+}
+
+func TestResize(t *testing.T) {
+	// a one pixel black line on white is kept as grey instead of dropped
+	src := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	for y := range 4 {
+		src.Set(1, y, color.Black)
+	}
+	got := resize(src, 2, 2)
+	if c := got.RGBAAt(0, 0); c != (color.RGBA{128, 128, 128, 255}) {
+		t.Errorf("pixel over the line = %v, want grey", c)
+	}
+	if c := got.RGBAAt(1, 0); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("pixel next to it = %v, want white", c)
+	}
+}
+
+func TestAreaWeights(t *testing.T) {
+	for _, tt := range [][2]int{{10, 3}, {3, 3}, {1000, 7}, {7, 5}, {586, 440}} {
+		for i, contributions := range areaWeights(tt[0], tt[1]) {
+			var sum float64
+			for _, c := range contributions {
+				sum += float64(c.weight)
+			}
+			if math.Abs(sum-1) > 1e-5 {
+				t.Errorf("areaWeights(%d, %d)[%d] adds up to %f, want 1", tt[0], tt[1], i, sum)
+			}
+		}
+	}
+}
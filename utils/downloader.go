@@ -35,36 +35,36 @@ var aria2cAvailable bool
 var checkAria2cOnce sync.Once
 
 // Download chooses aria2c or built-in downloader to download file
-func Download(args DownloaderArgs) bool {
+func Download(args DownloaderArgs, reporter Reporter) bool {
 	checkAria2cOnce.Do(func() {
-		aria2cAvailable = checkAria2c()
+		aria2cAvailable = checkAria2c(reporter)
 	})
 
 	if args.Downloader == "aria2c" && aria2cAvailable {
-		return useAria2c(args)
+		return useAria2c(args, reporter)
 	}
 
-	err := simpleDownload(args)
+	err := simpleDownload(args, reporter)
 	if err != nil {
-		log.Printf("Failed to download %s: %v", args.Url, err)
+		reporter.Log(fmt.Sprintf("Failed to download %s: %v", args.Url, err))
 		return false
 	}
 	return true
 }
 
 // checkAria2c checks if aria2c is available in PATH
-func checkAria2c() bool {
+func checkAria2c(reporter Reporter) bool {
 	cmd := exec.Command("aria2c", "--version")
 	err := cmd.Run()
 	if err != nil {
-		log.Printf("aria2c not found, falling back to built-in downloader: %v", err)
+		reporter.Log(fmt.Sprintf("aria2c not found, falling back to built-in downloader: %v", err))
 		return false
 	}
 	return true
 }
 
 // useAria2c calls aria2c for downloading
-func useAria2c(args DownloaderArgs) bool {
+func useAria2c(args DownloaderArgs, reporter Reporter) bool {
 	for attempts := 0; attempts < downloadMaxRetries; attempts++ {
 		cmd := exec.Command("aria2c",
 			"--allow-overwrite=true",
@@ -80,7 +80,7 @@ func useAria2c(args DownloaderArgs) bool {
 		if err == nil {
 			return true
 		} else {
-			log.Printf("Failed to download %s (attempt %d/%d), retrying in %s... (%v)", args.Url, attempts+1, downloadMaxRetries, downloadRetryDelay, err)
+			reporter.Log(fmt.Sprintf("Failed to download %s (attempt %d/%d), retrying in %s... (%v)", args.Url, attempts+1, downloadMaxRetries, downloadRetryDelay, err))
 			time.Sleep(downloadRetryDelay)
 		}
 	}
@@ -89,21 +89,21 @@ func useAria2c(args DownloaderArgs) bool {
 }
 
 // simpleDownload uses built-in downloader to download file, retrying on network errors.
-func simpleDownload(args DownloaderArgs) error {
+func simpleDownload(args DownloaderArgs, reporter Reporter) error {
 	var lastErr error
 	for attempt := 1; attempt <= downloadMaxRetries; attempt++ {
-		lastErr = simpleDownloadOnce(args)
+		lastErr = simpleDownloadOnce(args, reporter)
 		if lastErr == nil {
 			return nil
 		}
-		log.Printf("⚠️ Download attempt %d/%d failed for %s: %v — retrying in %s...",
-			attempt, downloadMaxRetries, args.ID, lastErr, downloadRetryDelay)
+		reporter.Log(fmt.Sprintf("⚠️ Download attempt %d/%d failed for %s: %v — retrying in %s...",
+			attempt, downloadMaxRetries, args.ID, lastErr, downloadRetryDelay))
 		time.Sleep(downloadRetryDelay)
 	}
 	return fmt.Errorf("😢 Gave up after %d attempts: %w", downloadMaxRetries, lastErr)
 }
 // simpleDownloadOnce performs a single download attempt.
-func simpleDownloadOnce(args DownloaderArgs) error {
+func simpleDownloadOnce(args DownloaderArgs, reporter Reporter) error {
 
 	//send head request
 	req, err := http.NewRequest("HEAD", args.Url, nil)
@@ -127,7 +127,7 @@ func simpleDownloadOnce(args DownloaderArgs) error {
 	contentLength := resp.Header.Get("Content-Length")
 	fileSize, _ := strconv.ParseInt(contentLength, 10, 64)
 	if fileSize > 0 {
-		UILog(fmt.Sprintf("📦 %s: %.2f MB", args.ID, float64(fileSize)/1024/1024))
+		reporter.Log(fmt.Sprintf("📦 %s: %.2f MB", args.ID, float64(fileSize)/1024/1024))
 	}
 
 	//ensure directory exists
@@ -182,30 +182,23 @@ func simpleDownloadOnce(args DownloaderArgs) error {
 		//start download
 		go func(id int, start, end int64) {
 			defer wg.Done()
-			if err := downloadPart(ctx, id, args.Url, args.Referer, start, end, outFile, progressChan); err != nil {
+			if err := downloadPart(ctx, id, args.Url, args.Referer, start, end, outFile, progressChan, reporter); err != nil {
 				errChan <- err
 				cancel() // cancel other parts if one fails
 			}
 		}(i, startByte, endByte)
 	}
 
-	UIAddDownload(args.ID)
+	reporter.DownloadStarted(args.ID, fileSize)
 	doneChan := make(chan bool)
 	go func() {
+		// size may be unknown (0), reporters then only see bytes
 		var totalDownloaded int64
-		if fileSize > 0 {
-			for n := range progressChan {
-				totalDownloaded += n
-				percent := float64(totalDownloaded) / float64(fileSize) * 100
-				UIUpdateDownload(args.ID, percent)
-			}
-		} else {
-			// fallback if size unknown
-			for range progressChan {
-				UIUpdateDownload(args.ID, 0)
-			}
+		for n := range progressChan {
+			totalDownloaded += n
+			reporter.DownloadProgress(args.ID, totalDownloaded, fileSize)
 		}
-		UIRemoveDownload(args.ID)
+		reporter.DownloadFinished(args.ID)
 		doneChan <- true
 	}()
 
@@ -224,7 +217,7 @@ func simpleDownloadOnce(args DownloaderArgs) error {
 
 // downloadPart downloads a byte-range segment of the file, retrying from the
 // last written offset on transient network errors.
-func downloadPart(ctx context.Context, id int, url string, referer string, start, end int64, file *os.File, progress chan<- int64) error {
+func downloadPart(ctx context.Context, id int, url string, referer string, start, end int64, file *os.File, progress chan<- int64, reporter Reporter) error {
 	var written int64 = 0
 
 	for attempt := 1; attempt <= downloadMaxRetries; attempt++ {
@@ -249,8 +242,8 @@ func downloadPart(ctx context.Context, id int, url string, referer string, start
 			if ctx.Err() != nil {
 				return ctx.Err()
 			}
-			log.Printf("⚠️  Part %d network error (attempt %d/%d): %v — retrying in %s...",
-				id, attempt, downloadMaxRetries, err, downloadRetryDelay)
+			reporter.Log(fmt.Sprintf("⚠️  Part %d network error (attempt %d/%d): %v — retrying in %s...",
+				id, attempt, downloadMaxRetries, err, downloadRetryDelay))
 			time.Sleep(downloadRetryDelay)
 			continue
 		}
@@ -268,7 +261,7 @@ func downloadPart(ctx context.Context, id int, url string, referer string, start
 			if nr > 0 {
 				_, ew := file.WriteAt(buf[0:nr], start+written)
 				if ew != nil {
-					log.Printf("Error writing part %d: %v", id, ew)
+					reporter.Log(fmt.Sprintf("Error writing part %d: %v", id, ew))
 					resp.Body.Close()
 					return ew
 				}
@@ -292,8 +285,8 @@ func downloadPart(ctx context.Context, id int, url string, referer string, start
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
-		log.Printf("⚠️  Part %d read error (attempt %d/%d): %v — retrying in %s...",
-			id, attempt, downloadMaxRetries, partErr, downloadRetryDelay)
+		reporter.Log(fmt.Sprintf("⚠️  Part %d read error (attempt %d/%d): %v — retrying in %s...",
+			id, attempt, downloadMaxRetries, partErr, downloadRetryDelay))
 		time.Sleep(downloadRetryDelay)
 	}
 
@@ -308,14 +301,16 @@ type DownloadTask struct {
 
 // DownloadManager handles concurrent downloads
 type DownloadManager struct {
-	tasks chan DownloadTask
-	wg    sync.WaitGroup
+	tasks    chan DownloadTask
+	wg       sync.WaitGroup
+	reporter Reporter
 }
 
 // NewDownloadManager creates a new download manager with a worker pool
-func NewDownloadManager(workers int) *DownloadManager {
+func NewDownloadManager(workers int, reporter Reporter) *DownloadManager {
 	dm := &DownloadManager{
-		tasks: make(chan DownloadTask, 100), // buffered channel
+		tasks:    make(chan DownloadTask, 100), // buffered channel
+		reporter: reporter,
 	}
 
 	for i := 0; i < workers; i++ {
@@ -329,7 +324,7 @@ func NewDownloadManager(workers int) *DownloadManager {
 func (dm *DownloadManager) worker() {
 	defer dm.wg.Done()
 	for task := range dm.tasks {
-		success := Download(task.Args)
+		success := Download(task.Args, dm.reporter)
 		if task.OnComplete != nil {
 			task.OnComplete(success)
 		}
@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type LockMode string
+
+const (
+	LockShared    LockMode = "shared"
+	LockExclusive LockMode = "exclusive"
+)
+
+const (
+	lockFileName   = ".pgallery.lock"
+	lockGuardStale = 10 * time.Second
+	lockGuardWait  = 50 * time.Millisecond
+)
+
+// LockHolder describes one process holding the base directory lock
+type LockHolder struct {
+	PID     int       `json:"pid"`
+	Command string    `json:"command"`
+	Since   time.Time `json:"since"`
+}
+
+type lockState struct {
+	Mode    LockMode     `json:"mode"`
+	Holders []LockHolder `json:"holders"`
+}
+
+// BaseLock is a held lock on a library base directory
+type BaseLock struct {
+	path string
+	pid  int
+}
+
+// LockedError is returned when the base directory is held by another process
+type LockedError struct {
+	Path    string
+	Mode    LockMode
+	Holders []LockHolder
+}
+
+func (e *LockedError) Error() string {
+	var holders []string
+	for _, h := range e.Holders {
+		holders = append(holders, fmt.Sprintf("%s (pid %d, since %s)", h.Command, h.PID, h.Since.Format(time.DateTime)))
+	}
+	return fmt.Sprintf("library is locked (%s) by %s; wait for it to finish, or delete %s if that process is gone",
+		e.Mode, strings.Join(holders, ", "), e.Path)
+}
+
+// LockBase takes the lock on base for command. Exclusive locks conflict with
+// everything, shared locks only with an exclusive holder. Holders whose
+// process no longer exists are dropped, so a crashed run never blocks forever.
+func LockBase(base string, mode LockMode, command string) (*BaseLock, error) {
+	l := &BaseLock{
+		path: filepath.Join(base, lockFileName),
+		pid:  os.Getpid(),
+	}
+
+	var lockedErr error
+	err := l.withGuard(func(state *lockState) error {
+		alive := state.Holders[:0]
+		for _, h := range state.Holders {
+			if processAlive(h.PID) {
+				alive = append(alive, h)
+			} else {
+				log.Printf("Taking over stale lock from %s (pid %d)", h.Command, h.PID)
+			}
+		}
+		state.Holders = alive
+
+		if len(state.Holders) > 0 && (mode == LockExclusive || state.Mode == LockExclusive) {
+			lockedErr = &LockedError{Path: l.path, Mode: state.Mode, Holders: state.Holders}
+			return nil
+		}
+
+		state.Mode = mode
+		state.Holders = append(state.Holders, LockHolder{
+			PID:     l.pid,
+			Command: command,
+			Since:   time.Now(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if lockedErr != nil {
+		return nil, lockedErr
+	}
+	return l, nil
+}
+
+// Release drops this process from the lock, removing the file when no holders remain
+func (l *BaseLock) Release() error {
+	return l.withGuard(func(state *lockState) error {
+		holders := state.Holders[:0]
+		for _, h := range state.Holders {
+			if h.PID != l.pid {
+				holders = append(holders, h)
+			}
+		}
+		state.Holders = holders
+		return nil
+	})
+}
+
+// withGuard serialises read-modify-write of the lock file through an
+// O_EXCL guard file
+func (l *BaseLock) withGuard(fn func(state *lockState) error) error {
+	guardPath := l.path + ".guard"
+	for {
+		guard, err := os.OpenFile(guardPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			guard.Close()
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("failed to create lock guard: %w", err)
+		}
+		// a guard is only held for a few milliseconds, an old one is left over from a crash
+		if info, statErr := os.Stat(guardPath); statErr == nil && time.Since(info.ModTime()) > lockGuardStale {
+			_ = os.Remove(guardPath)
+			continue
+		}
+		time.Sleep(lockGuardWait)
+	}
+	defer os.Remove(guardPath)
+
+	var state lockState
+	if content, err := os.ReadFile(l.path); err == nil {
+		if err := json.Unmarshal(content, &state); err != nil {
+			log.Printf("Ignoring unreadable lock file %s: %v", l.path, err)
+			state = lockState{}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read lock file: %w", err)
+	}
+
+	if err := fn(&state); err != nil {
+		return err
+	}
+
+	if len(state.Holders) == 0 {
+		if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove lock file: %w", err)
+		}
+		return nil
+	}
+
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(l.path, content, 0644); err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return nil
+}
+
+// processAlive reports whether pid refers to a running process
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	if pid == os.Getpid() {
+		return true
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// holdLock writes a lock file as if pid held the base in mode
+func holdLock(t *testing.T, base string, mode LockMode, pid int) {
+	t.Helper()
+	state := lockState{Mode: mode, Holders: []LockHolder{{PID: pid, Command: "other", Since: time.Now()}}}
+	content, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, lockFileName), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockBase(t *testing.T) {
+	// the parent process is alive for as long as the test runs, no process
+	// has a PID this high
+	alive, gone := os.Getppid(), 1<<30
+	tests := []struct {
+		name   string
+		held   LockMode // by another process, empty for none
+		pid    int
+		mode   LockMode
+		locked bool
+	}{
+		{"free, shared", "", 0, LockShared, false},
+		{"free, exclusive", "", 0, LockExclusive, false},
+		{"shared with shared", LockShared, alive, LockShared, false},
+		{"exclusive with shared", LockShared, alive, LockExclusive, true},
+		{"shared with exclusive", LockExclusive, alive, LockShared, true},
+		{"exclusive with exclusive", LockExclusive, alive, LockExclusive, true},
+		{"stale exclusive holder", LockExclusive, gone, LockExclusive, false},
+	}
+	for _, tt := range tests {
+		base := t.TempDir()
+		if tt.held != "" {
+			holdLock(t, base, tt.held, tt.pid)
+		}
+		lock, err := LockBase(base, tt.mode, "test")
+		var lockedErr *LockedError
+		if locked := errors.As(err, &lockedErr); locked != tt.locked {
+			t.Errorf("%s: LockBase error = %v, want locked %v", tt.name, err, tt.locked)
+			continue
+		}
+		if tt.locked {
+			if lockedErr.Mode != tt.held || len(lockedErr.Holders) != 1 || lockedErr.Holders[0].PID != tt.pid {
+				t.Errorf("%s: LockedError = %+v", tt.name, lockedErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: LockBase: %v", tt.name, err)
+			continue
+		}
+
+		// releasing leaves the other holder, or removes the file
+		if err := lock.Release(); err != nil {
+			t.Errorf("%s: Release: %v", tt.name, err)
+		}
+		_, statErr := os.Stat(filepath.Join(base, lockFileName))
+		if wantFile := tt.held == LockShared; (statErr == nil) != wantFile {
+			t.Errorf("%s: lock file after Release: %v, want it kept %v", tt.name, statErr, wantFile)
+		}
+		if _, err := os.Stat(filepath.Join(base, lockFileName+".guard")); err == nil {
+			t.Errorf("%s: guard file left", tt.name)
+		}
+	}
+}
+
+func TestLockBaseStaleGuard(t *testing.T) {
+	base := t.TempDir()
+	guard := filepath.Join(base, lockFileName+".guard")
+	if err := os.WriteFile(guard, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * lockGuardStale)
+	if err := os.Chtimes(guard, old, old); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := LockBase(base, LockExclusive, "test")
+	if err != nil {
+		t.Fatalf("LockBase with a stale guard: %v", err)
+	}
+	lock.Release()
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Workers returns n, or the number of CPUs when n is not positive
+func Workers(n int) int {
+	if n > 0 {
+		return n
+	}
+	return runtime.NumCPU()
+}
+
+// Parallel calls fn(i) for every i in [0, n) on at most workers goroutines
+// and returns when all calls are done. fn must only write to its own i, so
+// results come out in the same order however the calls were scheduled.
+func Parallel(n, workers int, fn func(i int)) {
+	workers = min(Workers(workers), n)
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
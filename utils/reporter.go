@@ -0,0 +1,413 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Reporter receives the progress of a sync run. Sync and DownloadManager are
+// handed one explicitly, so several runs can report to different places.
+type Reporter interface {
+	Log(msg string)
+
+	SyncStarted(artworks int)
+	ArtworkStarted(id int, pages int)
+	ArtworkSkipped(id int)
+	ArtworkDone(id int)
+	ArtworkFailed(id int, reason string)
+
+	DownloadStarted(task string, size int64)
+	DownloadProgress(task string, written, size int64)
+	DownloadFinished(task string)
+
+	Summary(summary Summary)
+	// Close flushes the output; the reporter must not be used afterwards
+	Close()
+}
+
+// OutputMode selects how sync reports progress
+type OutputMode string
+
+const (
+	// OutputText repaints the terminal, or writes plain log lines when stdout is not a TTY
+	OutputText OutputMode = "text"
+	// OutputJSON writes one JSON event per line to stdout, logs go to stderr
+	OutputJSON OutputMode = "json"
+)
+
+// NewReporter returns the reporter for mode, writing to stdout
+func NewReporter(mode OutputMode) Reporter {
+	switch {
+	case mode == OutputJSON:
+		return NewJSONReporter(os.Stdout)
+	case !IsTerminal(os.Stdout):
+		return NewPlainReporter(os.Stderr)
+	default:
+		return NewTerminalReporter(os.Stdout)
+	}
+}
+
+// IsTerminal reports whether f is attached to a terminal
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Summary is the outcome of a sync run
+type Summary struct {
+	Total      int     `json:"total"`
+	Downloaded int     `json:"downloaded"`
+	Skipped    int     `json:"skipped"`
+	Failed     int     `json:"failed"`
+	Seconds    float64 `json:"seconds"`
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("Done: %d downloaded, %d skipped, %d failed of %d in %.0fs",
+		s.Downloaded, s.Skipped, s.Failed, s.Total, s.Seconds)
+}
+
+// Event is a structured progress event, as written by JSONReporter and sent by ChannelReporter
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"event"`
+	Message string    `json:"message,omitempty"`
+	Total   int       `json:"total,omitempty"`
+	Artwork int       `json:"artwork,omitempty"`
+	Pages   int       `json:"pages,omitempty"`
+	Task    string    `json:"task,omitempty"`
+	Bytes   int64     `json:"bytes,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	Percent float64   `json:"percent,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Summary *Summary  `json:"summary,omitempty"`
+}
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+func stripANSI(msg string) string {
+	return ansiEscape.ReplaceAllString(msg, "")
+}
+
+// PlainReporter writes timestamped log lines, for cron jobs and journals
+type PlainReporter struct {
+	logger *log.Logger
+}
+
+func NewPlainReporter(w io.Writer) *PlainReporter {
+	return &PlainReporter{logger: log.New(w, "", log.LstdFlags)}
+}
+
+func (r *PlainReporter) Log(msg string)                                    { r.logger.Print(stripANSI(msg)) }
+func (r *PlainReporter) SyncStarted(artworks int)                          { r.logger.Printf("Syncing %d artworks", artworks) }
+func (r *PlainReporter) ArtworkStarted(id int, pages int)                  {}
+func (r *PlainReporter) ArtworkSkipped(id int)                             {}
+func (r *PlainReporter) ArtworkDone(id int)                                {}
+func (r *PlainReporter) ArtworkFailed(id int, reason string)               {}
+func (r *PlainReporter) DownloadStarted(task string, size int64)           {}
+func (r *PlainReporter) DownloadProgress(task string, written, size int64) {}
+func (r *PlainReporter) DownloadFinished(task string)                      {}
+func (r *PlainReporter) Summary(summary Summary)                           { r.logger.Print(summary) }
+func (r *PlainReporter) Close()                                            {}
+
+// eventReporter turns reporter calls into Events. Page progress is thinned
+// out to one event per 10%.
+type eventReporter struct {
+	mu      sync.Mutex
+	send    func(Event)
+	logs    *log.Logger // logs go here instead of becoming events, if set
+	deciles map[string]int
+}
+
+func (r *eventReporter) emit(event Event) {
+	event.Time = time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.send(event)
+}
+
+func (r *eventReporter) Log(msg string) {
+	if r.logs != nil {
+		r.logs.Print(stripANSI(msg))
+		return
+	}
+	r.emit(Event{Type: "log", Message: stripANSI(msg)})
+}
+
+func (r *eventReporter) SyncStarted(artworks int) {
+	r.emit(Event{Type: "sync_started", Total: artworks})
+}
+
+func (r *eventReporter) ArtworkStarted(id int, pages int) {
+	r.emit(Event{Type: "artwork_started", Artwork: id, Pages: pages})
+}
+
+func (r *eventReporter) ArtworkSkipped(id int) {
+	r.emit(Event{Type: "artwork_skipped", Artwork: id})
+}
+
+func (r *eventReporter) ArtworkDone(id int) {
+	r.emit(Event{Type: "artwork_done", Artwork: id})
+}
+
+func (r *eventReporter) ArtworkFailed(id int, reason string) {
+	r.emit(Event{Type: "artwork_failed", Artwork: id, Reason: reason})
+}
+
+func (r *eventReporter) DownloadStarted(task string, size int64) {
+	r.mu.Lock()
+	r.deciles[task] = 0
+	r.mu.Unlock()
+	r.emit(Event{Type: "page_progress", Task: task, Size: size})
+}
+
+func (r *eventReporter) DownloadProgress(task string, written, size int64) {
+	if size <= 0 {
+		return
+	}
+	percent := float64(written) / float64(size) * 100
+
+	r.mu.Lock()
+	decile := int(percent / 10)
+	report := decile > r.deciles[task]
+	r.deciles[task] = decile
+	r.mu.Unlock()
+
+	if report {
+		r.emit(Event{Type: "page_progress", Task: task, Bytes: written, Size: size, Percent: percent})
+	}
+}
+
+func (r *eventReporter) DownloadFinished(task string) {
+	r.mu.Lock()
+	delete(r.deciles, task)
+	r.mu.Unlock()
+}
+
+func (r *eventReporter) Summary(summary Summary) {
+	r.emit(Event{Type: "summary", Summary: &summary})
+}
+
+// JSONReporter writes one JSON event per line, for other tools to consume
+type JSONReporter struct {
+	eventReporter
+}
+
+func NewJSONReporter(w io.Writer) *JSONReporter {
+	encoder := json.NewEncoder(w)
+	return &JSONReporter{eventReporter{
+		send:    func(event Event) { _ = encoder.Encode(event) },
+		logs:    log.New(os.Stderr, "", log.LstdFlags),
+		deciles: make(map[string]int),
+	}}
+}
+
+func (r *JSONReporter) Close() {}
+
+// ChannelReporter sends every event, logs included, on Events. Sends block,
+// so the receiver has to keep up; Events is closed by Close, and events
+// reported after that, e.g. by a worker still logging, are dropped.
+type ChannelReporter struct {
+	eventReporter
+	Events <-chan Event
+	events chan Event
+	closed bool
+}
+
+func NewChannelReporter(buffer int) *ChannelReporter {
+	r := &ChannelReporter{
+		eventReporter: eventReporter{deciles: make(map[string]int)},
+		events:        make(chan Event, buffer),
+	}
+	r.Events = r.events
+	// emit holds mu, as Close does, so closed can't change during a send
+	r.send = func(event Event) {
+		if !r.closed {
+			r.events <- event
+		}
+	}
+	return r
+}
+
+func (r *ChannelReporter) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		r.closed = true
+		close(r.events)
+	}
+}
+
+// MultiReporter forwards every call to all of its reporters
+type MultiReporter []Reporter
+
+func (m MultiReporter) Log(msg string) {
+	for _, r := range m {
+		r.Log(msg)
+	}
+}
+
+func (m MultiReporter) SyncStarted(artworks int) {
+	for _, r := range m {
+		r.SyncStarted(artworks)
+	}
+}
+
+func (m MultiReporter) ArtworkStarted(id int, pages int) {
+	for _, r := range m {
+		r.ArtworkStarted(id, pages)
+	}
+}
+
+func (m MultiReporter) ArtworkSkipped(id int) {
+	for _, r := range m {
+		r.ArtworkSkipped(id)
+	}
+}
+
+func (m MultiReporter) ArtworkDone(id int) {
+	for _, r := range m {
+		r.ArtworkDone(id)
+	}
+}
+
+func (m MultiReporter) ArtworkFailed(id int, reason string) {
+	for _, r := range m {
+		r.ArtworkFailed(id, reason)
+	}
+}
+
+func (m MultiReporter) DownloadStarted(task string, size int64) {
+	for _, r := range m {
+		r.DownloadStarted(task, size)
+	}
+}
+
+func (m MultiReporter) DownloadProgress(task string, written, size int64) {
+	for _, r := range m {
+		r.DownloadProgress(task, written, size)
+	}
+}
+
+func (m MultiReporter) DownloadFinished(task string) {
+	for _, r := range m {
+		r.DownloadFinished(task)
+	}
+}
+
+func (m MultiReporter) Summary(summary Summary) {
+	for _, r := range m {
+		r.Summary(summary)
+	}
+}
+
+func (m MultiReporter) Close() {
+	for _, r := range m {
+		r.Close()
+	}
+}
+
+// Progress is a snapshot of a sync run as kept by MemoryReporter
+type Progress struct {
+	Artworks  int
+	Started   int
+	Done      int
+	Skipped   int
+	Failed    int
+	Downloads map[string]float64 // active task -> percent
+	Logs      []string           // most recent last
+	Summary   *Summary
+}
+
+// MemoryReporter keeps the state of the run in memory for others to poll,
+// e.g. the web UI
+type MemoryReporter struct {
+	mu       sync.Mutex
+	progress Progress
+	maxLogs  int
+}
+
+func NewMemoryReporter(maxLogs int) *MemoryReporter {
+	return &MemoryReporter{
+		progress: Progress{Downloads: make(map[string]float64)},
+		maxLogs:  maxLogs,
+	}
+}
+
+// Snapshot returns a copy of the current progress
+func (r *MemoryReporter) Snapshot() Progress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	snapshot := r.progress
+	snapshot.Downloads = make(map[string]float64, len(r.progress.Downloads))
+	for task, percent := range r.progress.Downloads {
+		snapshot.Downloads[task] = percent
+	}
+	snapshot.Logs = append([]string(nil), r.progress.Logs...)
+	return snapshot
+}
+
+func (r *MemoryReporter) update(fn func(p *Progress)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(&r.progress)
+}
+
+func (r *MemoryReporter) Log(msg string) {
+	r.update(func(p *Progress) {
+		p.Logs = append(p.Logs, stripANSI(msg))
+		if len(p.Logs) > r.maxLogs {
+			p.Logs = p.Logs[len(p.Logs)-r.maxLogs:]
+		}
+	})
+}
+
+func (r *MemoryReporter) SyncStarted(artworks int) {
+	r.update(func(p *Progress) { p.Artworks = artworks })
+}
+
+func (r *MemoryReporter) ArtworkStarted(id int, pages int) {
+	r.update(func(p *Progress) { p.Started++ })
+}
+
+func (r *MemoryReporter) ArtworkSkipped(id int) {
+	r.update(func(p *Progress) { p.Skipped++ })
+}
+
+func (r *MemoryReporter) ArtworkDone(id int) {
+	r.update(func(p *Progress) { p.Done++ })
+}
+
+func (r *MemoryReporter) ArtworkFailed(id int, reason string) {
+	r.update(func(p *Progress) { p.Failed++ })
+}
+
+func (r *MemoryReporter) DownloadStarted(task string, size int64) {
+	r.update(func(p *Progress) { p.Downloads[task] = 0 })
+}
+
+func (r *MemoryReporter) DownloadProgress(task string, written, size int64) {
+	if size <= 0 {
+		return
+	}
+	r.update(func(p *Progress) { p.Downloads[task] = float64(written) / float64(size) * 100 })
+}
+
+func (r *MemoryReporter) DownloadFinished(task string) {
+	r.update(func(p *Progress) { delete(p.Downloads, task) })
+}
+
+func (r *MemoryReporter) Summary(summary Summary) {
+	r.update(func(p *Progress) { p.Summary = &summary })
+}
+
+func (r *MemoryReporter) Close() {}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestChannelReporterClose(t *testing.T) {
+	r := NewChannelReporter(16)
+	r.SyncStarted(2)
+	r.Log("\033[1;32mhello\033[0m")
+	r.Close()
+
+	var events []Event
+	for event := range r.Events {
+		events = append(events, event)
+	}
+	if len(events) != 2 || events[0].Type != "sync_started" || events[1].Message != "hello" {
+		t.Fatalf("events = %+v, want sync_started and the log without colours", events)
+	}
+
+	// late reports from workers still running are dropped, not a panic
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Log("late")
+			r.ArtworkDone(1)
+			r.DownloadProgress("1_p0.jpg", 10, 10)
+		}()
+	}
+	wg.Wait()
+	r.Close()
+}
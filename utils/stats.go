@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const speedWindow = 5 * time.Second
+
+type speedSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// Stats adds up run-wide counters from reporter events. It is not safe for
+// concurrent use, the owning reporter serialises access.
+type Stats struct {
+	Start        time.Time
+	Artworks     int
+	ArtworksDone int
+	Failed       int
+	Skipped      int
+	Pages        int // pages of the artworks started so far
+	PagesDone    int
+	Bytes        int64
+
+	artworkPages map[int]int
+	written      map[string]int64
+	samples      []speedSample
+}
+
+func NewStats() *Stats {
+	return &Stats{
+		Start:        time.Now(),
+		artworkPages: make(map[int]int),
+		written:      make(map[string]int64),
+	}
+}
+
+func (s *Stats) SyncStarted(artworks int) {
+	s.Artworks = artworks
+}
+
+func (s *Stats) ArtworkStarted(id int, pages int) {
+	s.artworkPages[id] = pages
+	s.Pages += pages
+}
+
+func (s *Stats) ArtworkSkipped(id int) {
+	s.Skipped++
+}
+
+func (s *Stats) ArtworkDone(id int) {
+	s.ArtworksDone++
+	s.PagesDone += s.artworkPages[id]
+	delete(s.artworkPages, id)
+}
+
+func (s *Stats) ArtworkFailed(id int, reason string) {
+	s.Failed++
+	delete(s.artworkPages, id)
+}
+
+func (s *Stats) DownloadStarted(task string, size int64) {
+	// a retry starts over, keep counting from what is already on the wire
+	s.written[task] = 0
+}
+
+func (s *Stats) DownloadProgress(task string, written, size int64) {
+	s.Bytes += written - s.written[task]
+	s.written[task] = written
+}
+
+func (s *Stats) DownloadFinished(task string) {
+	delete(s.written, task)
+}
+
+// Processed is the number of artworks that are finished one way or another
+func (s *Stats) Processed() int {
+	return s.ArtworksDone + s.Failed + s.Skipped
+}
+
+// Speed returns the download rate in bytes per second over the last few seconds
+func (s *Stats) Speed() float64 {
+	now := time.Now()
+	s.samples = append(s.samples, speedSample{at: now, bytes: s.Bytes})
+	for len(s.samples) > 1 && now.Sub(s.samples[0].at) > speedWindow {
+		s.samples = s.samples[1:]
+	}
+
+	oldest := s.samples[0]
+	elapsed := now.Sub(oldest.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Bytes-oldest.bytes) / elapsed
+}
+
+// ETA estimates the remaining time from the average time per downloaded or
+// failed artwork; skipped ones cost nothing. ok is false until there is data.
+func (s *Stats) ETA() (eta time.Duration, ok bool) {
+	worked := s.ArtworksDone + s.Failed
+	remaining := s.Artworks - s.Processed()
+	if worked == 0 || remaining <= 0 {
+		return 0, remaining <= 0 && s.Artworks > 0
+	}
+	perArtwork := time.Since(s.Start) / time.Duration(worked)
+	return perArtwork * time.Duration(remaining), true
+}
+
+// Header renders the one-line overview shown above the download bars
+func (s *Stats) Header() string {
+	eta := "--"
+	if d, ok := s.ETA(); ok {
+		eta = d.Round(time.Second).String()
+	}
+	return fmt.Sprintf("Artworks %d/%d · Pages %d/%d · %.1f MB · %.2f MB/s · ETA %s · ✗ %d failed · ↷ %d skipped",
+		s.Processed(), s.Artworks, s.PagesDone, s.Pages,
+		float64(s.Bytes)/1024/1024, s.Speed()/1024/1024, eta, s.Failed, s.Skipped)
+}
+
+// StatsReporter is a Reporter that only keeps Stats, for callers that want
+// run-wide numbers alongside their real output
+type StatsReporter struct {
+	mu    sync.Mutex
+	stats *Stats
+}
+
+func NewStatsReporter() *StatsReporter {
+	return &StatsReporter{stats: NewStats()}
+}
+
+// Stats returns a copy of the counters so far
+func (r *StatsReporter) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return *r.stats
+}
+
+func (r *StatsReporter) update(fn func(s *Stats)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(r.stats)
+}
+
+func (r *StatsReporter) Log(msg string) {}
+
+func (r *StatsReporter) SyncStarted(artworks int) {
+	r.update(func(s *Stats) { s.SyncStarted(artworks) })
+}
+
+func (r *StatsReporter) ArtworkStarted(id int, pages int) {
+	r.update(func(s *Stats) { s.ArtworkStarted(id, pages) })
+}
+
+func (r *StatsReporter) ArtworkSkipped(id int) {
+	r.update(func(s *Stats) { s.ArtworkSkipped(id) })
+}
+
+func (r *StatsReporter) ArtworkDone(id int) {
+	r.update(func(s *Stats) { s.ArtworkDone(id) })
+}
+
+func (r *StatsReporter) ArtworkFailed(id int, reason string) {
+	r.update(func(s *Stats) { s.ArtworkFailed(id, reason) })
+}
+
+func (r *StatsReporter) DownloadStarted(task string, size int64) {
+	r.update(func(s *Stats) { s.DownloadStarted(task, size) })
+}
+
+func (r *StatsReporter) DownloadProgress(task string, written, size int64) {
+	r.update(func(s *Stats) { s.DownloadProgress(task, written, size) })
+}
+
+func (r *StatsReporter) DownloadFinished(task string) {
+	r.update(func(s *Stats) { s.DownloadFinished(task) })
+}
+
+func (r *StatsReporter) Summary(summary Summary) {}
+func (r *StatsReporter) Close()                  {}
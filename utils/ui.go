@@ -3,6 +3,7 @@ package utils
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -10,122 +11,177 @@ import (
 	"time"
 )
 
-var (
-	uiMu          sync.Mutex
+// TerminalReporter repaints the terminal with scrolling logs and a bar per download
+type TerminalReporter struct {
+	mu            sync.Mutex
+	out           io.Writer
 	activeTasks   []string
 	taskProgress  map[string]float64
 	logs          []string
-	uiTicker      *time.Ticker
-	uiStop        chan struct{}
+	ticker        *time.Ticker
+	stop          chan struct{}
+	done          chan struct{}
 	linesRendered int
-)
+	stats         *Stats
+}
 
-// LogInterceptor redirects log outputs to UILog
-type LogInterceptor struct{}
+// LogInterceptor redirects log outputs to a TerminalReporter
+type LogInterceptor struct {
+	reporter *TerminalReporter
+}
 
 func (l *LogInterceptor) Write(p []byte) (n int, err error) {
-	// Strip trailing newline as UILog adds it when rendering
+	// Strip trailing newline as Log adds it when rendering
 	msg := string(bytes.TrimSuffix(p, []byte("\n")))
-	UILog(msg)
+	l.reporter.Log(msg)
 	return len(p), nil
 }
 
-// InitUI starts the periodic UI renderer
-func InitUI() {
-	activeTasks = make([]string, 0)
-	taskProgress = make(map[string]float64)
-	logs = make([]string, 0)
-	uiStop = make(chan struct{})
-	uiTicker = time.NewTicker(200 * time.Millisecond)
+// NewTerminalReporter starts the periodic renderer. While it runs, the
+// standard logger is redirected into it so log lines don't tear the bars.
+func NewTerminalReporter(out io.Writer) *TerminalReporter {
+	r := &TerminalReporter{
+		out:          out,
+		activeTasks:  make([]string, 0),
+		taskProgress: make(map[string]float64),
+		logs:         make([]string, 0),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+		ticker:       time.NewTicker(200 * time.Millisecond),
+		stats:        NewStats(),
+	}
 
 	// Redirect standard logger to our UI manager
-	log.SetOutput(&LogInterceptor{})
+	log.SetOutput(&LogInterceptor{reporter: r})
 
 	go func() {
+		defer close(r.done)
 		for {
 			select {
-			case <-uiTicker.C:
-				renderUI()
-			case <-uiStop:
-				renderUI()
+			case <-r.ticker.C:
+				r.render()
+			case <-r.stop:
+				r.render()
 				return
 			}
 		}
 	}()
+
+	return r
 }
 
-// StopUI stops the periodic renderer
-func StopUI() {
-	if uiTicker != nil {
-		uiTicker.Stop()
-	}
-	if uiStop != nil {
-		close(uiStop)
-	}
+// Close stops the periodic renderer
+func (r *TerminalReporter) Close() {
+	r.ticker.Stop()
+	close(r.stop)
+	<-r.done
 	log.SetOutput(os.Stderr)
 }
 
-// UILog appends a scrolling log message
-func UILog(msg string) {
-	uiMu.Lock()
-	defer uiMu.Unlock()
-	logs = append(logs, msg)
+// Log appends a scrolling log message
+func (r *TerminalReporter) Log(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logs = append(r.logs, msg)
+}
+
+func (r *TerminalReporter) SyncStarted(artworks int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.SyncStarted(artworks)
+}
+
+func (r *TerminalReporter) ArtworkStarted(id int, pages int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.ArtworkStarted(id, pages)
 }
 
-// UIAddDownload registers a new download task
-func UIAddDownload(id string) {
-	uiMu.Lock()
-	defer uiMu.Unlock()
-	for _, existing := range activeTasks {
-		if existing == id {
+func (r *TerminalReporter) ArtworkSkipped(id int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.ArtworkSkipped(id)
+}
+
+func (r *TerminalReporter) ArtworkDone(id int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.ArtworkDone(id)
+}
+
+func (r *TerminalReporter) ArtworkFailed(id int, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.ArtworkFailed(id, reason)
+}
+
+func (r *TerminalReporter) Summary(summary Summary) {
+	r.Log(summary.String())
+}
+
+// DownloadStarted registers a new download task
+func (r *TerminalReporter) DownloadStarted(task string, size int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.activeTasks {
+		if existing == task {
 			return // already exists
 		}
 	}
-	activeTasks = append(activeTasks, id)
-	taskProgress[id] = 0.0
+	r.activeTasks = append(r.activeTasks, task)
+	r.taskProgress[task] = 0.0
+	r.stats.DownloadStarted(task, size)
 }
 
-// UIUpdateDownload updates the progress for a download task
-func UIUpdateDownload(id string, percent float64) {
-	uiMu.Lock()
-	defer uiMu.Unlock()
-	taskProgress[id] = percent
+// DownloadProgress updates the progress for a download task
+func (r *TerminalReporter) DownloadProgress(task string, written, size int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.DownloadProgress(task, written, size)
+	if size > 0 {
+		r.taskProgress[task] = float64(written) / float64(size) * 100
+	}
 }
 
-// UIRemoveDownload removes a download task from the active list
-func UIRemoveDownload(id string) {
-	uiMu.Lock()
-	defer uiMu.Unlock()
-	for i, t := range activeTasks {
-		if t == id {
-			activeTasks = append(activeTasks[:i], activeTasks[i+1:]...)
+// DownloadFinished removes a download task from the active list
+func (r *TerminalReporter) DownloadFinished(task string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, t := range r.activeTasks {
+		if t == task {
+			r.activeTasks = append(r.activeTasks[:i], r.activeTasks[i+1:]...)
 			break
 		}
 	}
-	delete(taskProgress, id)
+	delete(r.taskProgress, task)
+	r.stats.DownloadFinished(task)
 }
 
-func renderUI() {
-	uiMu.Lock()
-	defer uiMu.Unlock()
+func (r *TerminalReporter) render() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
 	// Clear previously rendered fixed lines
-	if linesRendered > 0 {
-		fmt.Printf("\033[%dA\033[J", linesRendered)
+	if r.linesRendered > 0 {
+		fmt.Fprintf(r.out, "\033[%dA\033[J", r.linesRendered)
 	}
 
 	// Print scrolling logs
-	for _, l := range logs {
-		fmt.Println(l)
+	for _, l := range r.logs {
+		fmt.Fprintln(r.out, l)
 	}
-	logs = logs[:0]
+	r.logs = r.logs[:0]
 
 	lines := 0
-	if len(activeTasks) > 0 {
-		fmt.Println("\nDownloading:")
+	if r.stats.Artworks > 0 {
+		fmt.Fprintln(r.out, "\n"+r.stats.Header())
+		lines += 2
+	}
+	if len(r.activeTasks) > 0 {
+		fmt.Fprintln(r.out, "\nDownloading:")
 		lines += 2
-		for _, id := range activeTasks {
-			percent := taskProgress[id]
+		for _, id := range r.activeTasks {
+			percent := r.taskProgress[id]
 			bars := int(percent / 10)
 			if bars < 0 {
 				bars = 0
@@ -145,10 +201,10 @@ func renderUI() {
 				displayID = "..." + displayID[len(displayID)-17:]
 			}
 
-			fmt.Printf("%20s: [%s] %5.1f%%\n", displayID, barStr, percent)
+			fmt.Fprintf(r.out, "%20s: [%s] %5.1f%%\n", displayID, barStr, percent)
 			lines++
 		}
 	}
 
-	linesRendered = lines
+	r.linesRendered = lines
 }
@@ -0,0 +1,183 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Magnetkopf/pGallery/internal/dupes"
+)
+
+type DupeArtwork struct {
+	ID     int
+	Title  string
+	Pages  []dupes.PageHash // the pages that matched
+	Exists bool             // still in the index
+}
+
+type DupeCluster struct {
+	Key      string
+	Distance int
+	Artworks []DupeArtwork
+}
+
+type DupesView struct {
+	Threshold int
+	Clusters  []DupeCluster
+	Reviewed  int
+	Error     string
+}
+
+// dupesCache keeps the clusters of one threshold until build rewrites the
+// page hashes
+type dupesCache struct {
+	mu        sync.Mutex
+	modTime   time.Time
+	threshold int
+	clusters  []dupes.Cluster
+}
+
+// dupeClusters returns the clusters of near-duplicates at threshold, finding
+// them again only when the hashes or the threshold changed
+func (ctx *WebContext) dupeClusters(threshold int) ([]dupes.Cluster, error) {
+	var modTime time.Time
+	if info, err := os.Stat(filepath.Join(ctx.Base, dupes.IndexFile)); err == nil {
+		modTime = info.ModTime()
+	}
+
+	cache := &ctx.dupes
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.clusters != nil && cache.modTime.Equal(modTime) && cache.threshold == threshold {
+		return cache.clusters, nil
+	}
+	hashes, err := dupes.LoadHashes(ctx.Base)
+	if err != nil {
+		return nil, err
+	}
+	clusters := dupes.FindClusters(hashes, threshold)
+	if clusters == nil {
+		clusters = []dupes.Cluster{}
+	}
+	cache.clusters, cache.modTime, cache.threshold = clusters, modTime, threshold
+	return clusters, nil
+}
+
+// handleDupes shows the clusters of near-duplicates that still need a
+// keeper; POST records the pick in dupes.json
+func (ctx *WebContext) handleDupes(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		ctx.saveDupeDecision(w, r)
+		return
+	}
+
+	threshold := dupes.DefaultThreshold
+	if value := r.URL.Query().Get("threshold"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 && n <= 64 {
+			threshold = n
+		}
+	}
+
+	view := DupesView{Threshold: threshold}
+	clusters, err := ctx.dupeClusters(threshold)
+	if err != nil {
+		view.Error = err.Error()
+	}
+	decisions, err := dupes.LoadDecisions(ctx.Base)
+	if err != nil {
+		view.Error = err.Error()
+	}
+
+	idx := ctx.Index()
+	for _, cluster := range clusters {
+		if dupes.Reviewed(decisions, cluster) {
+			view.Reviewed++
+			continue
+		}
+
+		item := DupeCluster{Key: cluster.Key(), Distance: cluster.Distance}
+		for _, id := range cluster.Artworks {
+			artwork := DupeArtwork{ID: id}
+			if card, ok := idx.Artwork(strconv.Itoa(id)); ok {
+				artwork.Title = card.Title
+				artwork.Exists = true
+			}
+			for _, page := range cluster.Pages {
+				if page.Artwork == id {
+					artwork.Pages = append(artwork.Pages, page)
+				}
+			}
+			item.Artworks = append(item.Artworks, artwork)
+		}
+		view.Clusters = append(view.Clusters, item)
+	}
+
+	tmpl, err := template.ParseFS(templateFS, "templates/layout.html", "templates/dupes.html")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	err = tmpl.Execute(w, view)
+	if err != nil {
+		log.Printf("Error executing template: %v", err)
+	}
+}
+
+func (ctx *WebContext) saveDupeDecision(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var decision dupes.Decision
+	for _, value := range strings.Split(r.FormValue("artworks"), ",") {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid artwork %q", value), http.StatusBadRequest)
+			return
+		}
+		decision.Artworks = append(decision.Artworks, id)
+	}
+	keeper, err := strconv.Atoi(r.FormValue("keeper"))
+	if err != nil {
+		http.Error(w, "Pick a keeper", http.StatusBadRequest)
+		return
+	}
+	if keeper != 0 && !containsInt(decision.Artworks, keeper) {
+		http.Error(w, "The keeper is not in the cluster", http.StatusBadRequest)
+		return
+	}
+	decision.Keeper = keeper
+	decision.Decided = time.Now()
+
+	ctx.decisionsMu.Lock()
+	defer ctx.decisionsMu.Unlock()
+	decisions, err := dupes.LoadDecisions(ctx.Base)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := dupes.SaveDecisions(ctx.Base, append(decisions, decision)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dupes?threshold="+r.FormValue("threshold"), http.StatusSeeOther)
+}
+
+func containsInt(list []int, value int) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
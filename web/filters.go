@@ -0,0 +1,269 @@
+package web
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Magnetkopf/pGallery/internal/colors"
+	"github.com/Magnetkopf/pGallery/internal/filter"
+	"github.com/Magnetkopf/pGallery/internal/index"
+	"github.com/Magnetkopf/pGallery/internal/model"
+)
+
+// parseIndexQuery reads the filters the index answers itself: artist, tag,
+// bookmarked_by, date_from / date_to (YYYY, YYYY-MM or YYYY-MM-DD, both
+// included) and min_pages / max_pages
+func parseIndexQuery(query url.Values) (index.Query, error) {
+	q := index.Query{
+		Artist:       query.Get("artist"),
+		Tag:          query.Get("tag"),
+		BookmarkedBy: query.Get("bookmarked_by"),
+	}
+	var err error
+
+	if value := query.Get("date_from"); value != "" {
+		if q.CreatedFrom, _, err = filter.ParsePeriod(value); err != nil {
+			return q, fmt.Errorf("invalid date_from: %w", err)
+		}
+	}
+	if value := query.Get("date_to"); value != "" {
+		if _, q.CreatedTo, err = filter.ParsePeriod(value); err != nil {
+			return q, fmt.Errorf("invalid date_to: %w", err)
+		}
+	}
+	if q.MinPages, err = parseOptionalInt(query.Get("min_pages")); err != nil || q.MinPages < 0 {
+		return q, fmt.Errorf("invalid min_pages %q", query.Get("min_pages"))
+	}
+	if q.MaxPages, err = parseOptionalInt(query.Get("max_pages")); err != nil || q.MaxPages < 0 {
+		return q, fmt.Errorf("invalid max_pages %q", query.Get("max_pages"))
+	}
+	return q, nil
+}
+
+// dateFilterInfo describes a creation date range for the filter bar
+func dateFilterInfo(from, to string) string {
+	switch {
+	case to == "":
+		return "Created: from " + from
+	case from == "":
+		return "Created: until " + to
+	}
+	return "Created: " + from + " – " + to
+}
+
+// pagesFilterInfo describes a page count range for the filter bar
+func pagesFilterInfo(min, max int) string {
+	switch {
+	case max == 0:
+		return fmt.Sprintf("Pages: %d or more", min)
+	case min == 0:
+		return fmt.Sprintf("Pages: up to %d", max)
+	}
+	return fmt.Sprintf("Pages: %d–%d", min, max)
+}
+
+// aspectTolerance is how far an aspect ratio may be off and still count as
+// matching, e.g. for "16:9" or square
+const aspectTolerance = 0.02
+
+// DimensionFilter narrows artworks down by the pixel size of their pages. An
+// artwork matches when one of its pages meets every condition.
+type DimensionFilter struct {
+	Orientation string // landscape, portrait or square
+	MinWidth    int
+	MinHeight   int
+	AspectMin   float64
+	AspectMax   float64
+}
+
+// parseDimensionFilter reads orientation, min_width, min_height, aspect
+// ("16:9" or "1.78") and aspect_min / aspect_max from the query
+func parseDimensionFilter(query url.Values) (DimensionFilter, error) {
+	var f DimensionFilter
+	var err error
+
+	switch orientation := query.Get("orientation"); orientation {
+	case "", "landscape", "portrait", "square":
+		f.Orientation = orientation
+	default:
+		return f, fmt.Errorf("unknown orientation %q", orientation)
+	}
+
+	if f.MinWidth, err = parseOptionalInt(query.Get("min_width")); err != nil || f.MinWidth < 0 {
+		return f, fmt.Errorf("invalid min_width %q", query.Get("min_width"))
+	}
+	if f.MinHeight, err = parseOptionalInt(query.Get("min_height")); err != nil || f.MinHeight < 0 {
+		return f, fmt.Errorf("invalid min_height %q", query.Get("min_height"))
+	}
+
+	if value := query.Get("aspect"); value != "" {
+		aspect, err := parseAspect(value)
+		if err != nil {
+			return f, err
+		}
+		f.AspectMin = aspect * (1 - aspectTolerance)
+		f.AspectMax = aspect * (1 + aspectTolerance)
+	}
+	if value := query.Get("aspect_min"); value != "" {
+		if f.AspectMin, err = parseAspect(value); err != nil {
+			return f, err
+		}
+	}
+	if value := query.Get("aspect_max"); value != "" {
+		if f.AspectMax, err = parseAspect(value); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+func parseOptionalInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// parseAspect reads "W:H" or a plain width / height ratio
+func parseAspect(value string) (float64, error) {
+	if w, h, ok := strings.Cut(value, ":"); ok {
+		width, errW := strconv.ParseFloat(w, 64)
+		height, errH := strconv.ParseFloat(h, 64)
+		if errW != nil || errH != nil || width <= 0 || height <= 0 {
+			return 0, fmt.Errorf("invalid aspect ratio %q", value)
+		}
+		return width / height, nil
+	}
+	aspect, err := strconv.ParseFloat(value, 64)
+	if err != nil || aspect <= 0 {
+		return 0, fmt.Errorf("invalid aspect ratio %q", value)
+	}
+	return aspect, nil
+}
+
+// Active reports whether any condition is set
+func (f DimensionFilter) Active() bool {
+	return f != DimensionFilter{}
+}
+
+func (f DimensionFilter) Match(card *model.ArtworkCard) bool {
+	for _, page := range card.Pages {
+		if page.Width > 0 && f.matchPage(page) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f DimensionFilter) matchPage(page model.PageInfo) bool {
+	square := math.Abs(page.Aspect-1) <= aspectTolerance
+	switch f.Orientation {
+	case "landscape":
+		if square || page.Aspect < 1 {
+			return false
+		}
+	case "portrait":
+		if square || page.Aspect > 1 {
+			return false
+		}
+	case "square":
+		if !square {
+			return false
+		}
+	}
+
+	if page.Width < f.MinWidth || page.Height < f.MinHeight {
+		return false
+	}
+	if f.AspectMin > 0 && page.Aspect < f.AspectMin {
+		return false
+	}
+	if f.AspectMax > 0 && page.Aspect > f.AspectMax {
+		return false
+	}
+	return true
+}
+
+// String describes the filter for the filter bar
+func (f DimensionFilter) String() string {
+	var parts []string
+	if f.Orientation != "" {
+		parts = append(parts, "Orientation: "+f.Orientation)
+	}
+	if f.MinWidth > 0 || f.MinHeight > 0 {
+		parts = append(parts, fmt.Sprintf("At least %d×%d", f.MinWidth, f.MinHeight))
+	}
+	if f.AspectMin > 0 || f.AspectMax > 0 {
+		parts = append(parts, fmt.Sprintf("Aspect %.2f–%.2f", f.AspectMin, f.AspectMax))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// defaultColorDistance is how far (ΔE) a swatch may be from the picked colour
+// for the artwork to show up at all
+const defaultColorDistance = 25
+
+// colorShareWeight is how many ΔE a colour covering the whole image is ahead
+// of the same colour as a speck, so big areas of the colour rank first
+const colorShareWeight = 10
+
+// ColorFilter finds artworks with a dominant colour close to the picked one
+// and ranks them by perceptual distance in Lab space
+type ColorFilter struct {
+	Hex         string
+	Color       colors.Lab
+	MaxDistance float64
+}
+
+// parseColorFilter reads color ("#rrggbb") and color_distance from the query
+func parseColorFilter(query url.Values) (ColorFilter, error) {
+	f := ColorFilter{MaxDistance: defaultColorDistance}
+	value := query.Get("color")
+	if value == "" {
+		return f, nil
+	}
+	color, err := colors.ParseHex(value)
+	if err != nil {
+		return f, err
+	}
+	f.Hex, f.Color = "#"+strings.ToLower(strings.TrimPrefix(value, "#")), color
+
+	if value := query.Get("color_distance"); value != "" {
+		if f.MaxDistance, err = strconv.ParseFloat(value, 64); err != nil || f.MaxDistance <= 0 {
+			return f, fmt.Errorf("invalid color_distance %q", value)
+		}
+	}
+	return f, nil
+}
+
+// Active reports whether a colour was picked
+func (f ColorFilter) Active() bool {
+	return f.Hex != ""
+}
+
+// Score is lower the better card matches, ok is false when no swatch is
+// within MaxDistance
+func (f ColorFilter) Score(card *model.ArtworkCard) (score float64, ok bool) {
+	score = math.MaxFloat64
+	for _, swatch := range card.Colors {
+		color, err := colors.ParseHex(swatch.Hex)
+		if err != nil {
+			continue
+		}
+		distance := colors.Distance(f.Color, color)
+		if distance > f.MaxDistance {
+			continue
+		}
+		ok = true
+		score = math.Min(score, distance+(1-swatch.Share)*colorShareWeight)
+	}
+	return score, ok
+}
+
+// String describes the filter for the filter bar
+func (f ColorFilter) String() string {
+	return fmt.Sprintf("Colour: %s (ΔE ≤ %g)", f.Hex, f.MaxDistance)
+}
@@ -0,0 +1,93 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"image"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Magnetkopf/pGallery/internal/dupes"
+)
+
+// maxUpload caps the size of an image sent to /find
+const maxUpload = 32 << 20
+
+type FindMatch struct {
+	dupes.Match
+	Title   string // empty when the artwork is not in the index
+	Percent string
+}
+
+type FindView struct {
+	Searched bool
+	Distance int
+	Pages    int // pages searched
+	Matches  []FindMatch
+	Error    string
+}
+
+// handleFind is the reverse image search: POST an image and get the library
+// pages whose perceptual hash is closest to it
+func (ctx *WebContext) handleFind(w http.ResponseWriter, r *http.Request) {
+	view := FindView{Distance: dupes.DefaultSearchDistance}
+
+	if r.Method == http.MethodPost {
+		view.Searched = true
+		r.Body = http.MaxBytesReader(w, r.Body, maxUpload)
+		if err := ctx.findImage(r, &view); err != nil {
+			view.Error = err.Error()
+		}
+	}
+
+	tmpl, err := template.ParseFS(templateFS, "templates/layout.html", "templates/find.html")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	err = tmpl.Execute(w, view)
+	if err != nil {
+		log.Printf("Error executing template: %v", err)
+	}
+}
+
+func (ctx *WebContext) findImage(r *http.Request, view *FindView) error {
+	if value := r.FormValue("distance"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > 64 {
+			return fmt.Errorf("distance must be between 0 and 64")
+		}
+		view.Distance = n
+	}
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		return fmt.Errorf("no image uploaded: %w", err)
+	}
+	defer file.Close()
+	query, _, err := image.Decode(file)
+	if err != nil {
+		return fmt.Errorf("failed to decode the image: %w", err)
+	}
+
+	hashes, err := dupes.LoadHashes(ctx.Base)
+	if err != nil {
+		return err
+	}
+	if len(hashes) == 0 {
+		return fmt.Errorf("no page hashes yet, run build first")
+	}
+	view.Pages = len(hashes)
+
+	idx := ctx.Index()
+	for _, match := range dupes.Search(hashes, query, view.Distance, 50) {
+		item := FindMatch{Match: match, Percent: fmt.Sprintf("%.1f%%", match.Similarity*100)}
+		if card, ok := idx.Artwork(strconv.Itoa(match.Artwork)); ok {
+			item.Title = card.Title
+		}
+		view.Matches = append(view.Matches, item)
+	}
+	return nil
+}
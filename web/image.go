@@ -0,0 +1,279 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Magnetkopf/pGallery/internal/thumbs"
+)
+
+// imageCacheDir holds the variants rendered by /img, relative to base: one
+// directory per source, named by a hash of its path, with the path itself in
+// imageSourceFile and one file per size. A variant carries the modification
+// time of the source it was rendered from.
+const imageCacheDir = ".cache/img"
+
+const imageSourceFile = "src"
+
+// imageSizes are the boxes the templates ask /img for: grid cards, the artist
+// page's avatar and the artwork page's avatar. Only these are rendered, as
+// every other size would add variants to the cache that are never evicted.
+var imageSizes = [][2]int{{440, 586}, {192, 192}, {144, 144}}
+
+func validImageSize(width, height int) bool {
+	for _, size := range imageSizes {
+		if size[0] == width && size[1] == height {
+			return true
+		}
+	}
+	return false
+}
+
+func imageSizeList() string {
+	sizes := make([]string, len(imageSizes))
+	for i, size := range imageSizes {
+		sizes[i] = fmt.Sprintf("%dx%d", size[0], size[1])
+	}
+	return strings.Join(sizes, ", ")
+}
+
+// imageFlight makes concurrent requests for the same variant wait for one render
+type imageFlight struct {
+	mu    sync.Mutex
+	calls map[string]*imageCall
+}
+
+type imageCall struct {
+	done chan struct{}
+	err  error
+}
+
+// do runs fn once per key at a time; callers arriving meanwhile get its result
+func (f *imageFlight) do(key string, fn func() error) error {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = make(map[string]*imageCall)
+	}
+	if call, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &imageCall{done: make(chan struct{})}
+	f.calls[key] = call
+	f.mu.Unlock()
+
+	call.err = fn()
+	close(call.done)
+
+	f.mu.Lock()
+	delete(f.calls, key)
+	f.mu.Unlock()
+	return call.err
+}
+
+// handleImage serves src scaled down to fit w x h. fit=cover crops to fill the
+// box, fit=contain (default) keeps the whole image; format is jpeg (default)
+// or png. Renders are cached under .cache/img and rendered again, in place,
+// when the source changes.
+func (ctx *WebContext) handleImage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	src := path.Clean("/" + query.Get("src"))[1:]
+	if !servable(src) {
+		http.Error(w, "Invalid src", http.StatusBadRequest)
+		return
+	}
+	width, errW := strconv.Atoi(query.Get("w"))
+	height, errH := strconv.Atoi(query.Get("h"))
+	if errW != nil || errH != nil || !validImageSize(width, height) {
+		http.Error(w, "w x h must be one of "+imageSizeList(), http.StatusBadRequest)
+		return
+	}
+	fit := query.Get("fit")
+	if fit == "" {
+		fit = "contain"
+	}
+	if fit != "contain" && fit != "cover" {
+		http.Error(w, "fit must be contain or cover", http.StatusBadRequest)
+		return
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "jpeg"
+	}
+	if format != "jpeg" && format != "png" {
+		http.Error(w, "format must be jpeg or png", http.StatusBadRequest)
+		return
+	}
+
+	srcPath := filepath.Join(ctx.Base, filepath.FromSlash(src))
+	info, err := os.Stat(srcPath)
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	ext := ".jpg"
+	if format == "png" {
+		ext = ".png"
+	}
+	dir := imageVariantDir(ctx.Base, src)
+	cachePath := filepath.Join(dir, fmt.Sprintf("%dx%d-%s%s", width, height, fit, ext))
+	fresh := func() bool {
+		cached, err := os.Stat(cachePath)
+		return err == nil && cached.ModTime().Equal(info.ModTime())
+	}
+
+	if !fresh() {
+		err := ctx.images.do(cachePath, func() error {
+			if fresh() {
+				return nil // rendered by a request that just finished
+			}
+			return renderImage(srcPath, src, cachePath, info.ModTime(), thumbs.Size{Width: width, Height: height, Crop: fit == "cover"}, format)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeFile(w, r, cachePath)
+}
+
+// imageVariantDir is the cache directory of the variants of src
+func imageVariantDir(base, src string) string {
+	sum := sha1.Sum([]byte(src))
+	key := hex.EncodeToString(sum[:])
+	return filepath.Join(base, filepath.FromSlash(imageCacheDir), key[:2], key)
+}
+
+// renderImage writes a variant of srcPath, the file src, to cachePath,
+// replacing an older render, and stamps it with modTime
+func renderImage(srcPath, src, cachePath string, modTime time.Time, size thumbs.Size, format string) error {
+	img, err := thumbs.Decode(srcPath)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := thumbs.Encode(&buf, thumbs.Render(img, size), format); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(cachePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := writeCacheFile(filepath.Join(dir, imageSourceFile), []byte(src), time.Time{}); err != nil {
+		return err
+	}
+	return writeCacheFile(cachePath, buf.Bytes(), modTime)
+}
+
+// writeCacheFile replaces path through a temporary file, so readers never
+// see it half written, and sets its modification time unless zero
+func writeCacheFile(path string, content []byte, modTime time.Time) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && !modTime.IsZero() {
+		err = os.Chtimes(tmp.Name(), modTime, modTime)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// PruneImages removes the /img variants whose source is gone, or changed
+// since they were rendered, and returns how many it removed. Variants being
+// rendered meanwhile are left alone.
+func PruneImages(base string) (int, error) {
+	root := filepath.Join(base, filepath.FromSlash(imageCacheDir))
+	buckets, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, bucket := range buckets {
+		bucketPath := filepath.Join(root, bucket.Name())
+		if !bucket.IsDir() {
+			continue
+		}
+		dirs, err := os.ReadDir(bucketPath)
+		if err != nil {
+			return removed, err
+		}
+		for _, dir := range dirs {
+			dirPath := filepath.Join(bucketPath, dir.Name())
+			if !dir.IsDir() {
+				// a variant of the earlier layout, keyed on the source's
+				// modification time and never found again once it changed
+				if os.Remove(dirPath) == nil {
+					removed++
+				}
+				continue
+			}
+			removed += pruneVariants(base, dirPath)
+		}
+	}
+	return removed, nil
+}
+
+// pruneVariants removes the stale variants in the cache directory of one source
+func pruneVariants(base, dir string) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	var source os.FileInfo
+	if src, err := os.ReadFile(filepath.Join(dir, imageSourceFile)); err == nil && len(src) > 0 {
+		source, _ = os.Stat(filepath.Join(base, filepath.FromSlash(string(src))))
+	}
+
+	removed, rendering := 0, false
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			rendering = true
+			continue
+		}
+		if name == imageSourceFile {
+			continue
+		}
+		if source != nil {
+			if info, err := entry.Info(); err == nil && info.ModTime().Equal(source.ModTime()) {
+				continue
+			}
+		}
+		if os.Remove(filepath.Join(dir, name)) == nil {
+			removed++
+		}
+	}
+	if source == nil && !rendering {
+		os.RemoveAll(dir)
+	}
+	return removed
+}
@@ -0,0 +1,115 @@
+package web
+
+import (
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePNG(t *testing.T, path string, modTime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(file, image.NewRGBA(image.Rect(0, 0, 600, 800))); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// variants lists the rendered files in the cache directory of src
+func variants(t *testing.T, base, src string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(imageVariantDir(base, src))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Name() != imageSourceFile {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+func TestImageCache(t *testing.T) {
+	base := t.TempDir()
+	src := "10/123/p0.png"
+	srcPath := filepath.Join(base, filepath.FromSlash(src))
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writePNG(t, srcPath, first)
+	handler := (&WebContext{Base: base}).Handler()
+
+	get := func(query string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/img?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /img?%s = %d: %s", query, rec.Code, rec.Body)
+		}
+	}
+
+	get("src=" + src + "&w=440&h=586&fit=cover")
+	get("src=" + src + "&w=192&h=192&fit=cover")
+	if got := variants(t, base, src); len(got) != 2 {
+		t.Fatalf("variants = %q, want 2", got)
+	}
+
+	// the page is downloaded again: the render is replaced, not added to
+	writePNG(t, srcPath, first.Add(time.Hour))
+	get("src=" + src + "&w=440&h=586&fit=cover")
+	got := variants(t, base, src)
+	if len(got) != 2 {
+		t.Fatalf("variants after the source changed = %q, want 2", got)
+	}
+	cached, err := os.Stat(filepath.Join(imageVariantDir(base, src), "440x586-cover.jpg"))
+	if err != nil || !cached.ModTime().Equal(first.Add(time.Hour)) {
+		t.Fatalf("variant not rendered again: %v %v", cached, err)
+	}
+
+	// the 192x192 one is stale now, a build prunes it
+	legacy := filepath.Join(base, ".cache", "img", "ab", "abcdef.jpg")
+	if err := os.MkdirAll(filepath.Dir(legacy), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(legacy, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := PruneImages(base)
+	if err != nil || removed != 2 {
+		t.Fatalf("PruneImages = %d, %v, want the stale variant and the legacy file", removed, err)
+	}
+	if got := variants(t, base, src); len(got) != 1 || got[0] != "440x586-cover.jpg" {
+		t.Errorf("variants after pruning = %q, want 440x586-cover.jpg", got)
+	}
+
+	// the page is moved away by a relayout: everything of it goes
+	if err := os.Remove(srcPath); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := PruneImages(base); err != nil || removed != 1 {
+		t.Errorf("PruneImages after the source went = %d, %v, want 1", removed, err)
+	}
+	if _, err := os.Stat(imageVariantDir(base, src)); !os.IsNotExist(err) {
+		t.Errorf("cache directory of a deleted source is left: %v", err)
+	}
+	if removed, err := PruneImages(base); err != nil || removed != 0 {
+		t.Errorf("PruneImages with nothing stale = %d, %v", removed, err)
+	}
+}
@@ -0,0 +1,103 @@
+package web
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Magnetkopf/pGallery/internal/model"
+	"github.com/Magnetkopf/pGallery/internal/search"
+)
+
+// snippetWidth is how many characters of a description are shown around
+// the first match
+const snippetWidth = 80
+
+// searchCache keeps the full-text index loaded until build rewrites it
+type searchCache struct {
+	mu      sync.Mutex
+	modTime time.Time
+	index   *search.Index
+}
+
+// searchIndex returns the full-text index, reading it again when it changed
+func (ctx *WebContext) searchIndex() (*search.Index, error) {
+	info, err := os.Stat(filepath.Join(ctx.Base, search.File))
+	if err != nil {
+		return nil, fmt.Errorf("no search index, run build: %w", err)
+	}
+
+	cache := &ctx.search
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.index != nil && cache.modTime.Equal(info.ModTime()) {
+		return cache.index, nil
+	}
+	idx, err := search.Load(ctx.Base)
+	if err != nil {
+		return nil, err
+	}
+	cache.index, cache.modTime = idx, info.ModTime()
+	return idx, nil
+}
+
+// SearchHit shows why an artwork matched the search box, matches in <mark>
+type SearchHit struct {
+	Title   template.HTML
+	Snippet template.HTML // from the description, or the matching tags
+}
+
+// searchHits highlights query in the shown cards
+func searchHits(idx *search.Index, query string, cards []*model.ArtworkCard) map[string]SearchHit {
+	highlighter := search.NewHighlighter(query)
+	hits := make(map[string]SearchHit, len(cards))
+	for _, card := range cards {
+		hit := SearchHit{Title: highlightHTML(highlighter.Highlight(card.Title))}
+		doc, _ := idx.Doc(card.ID)
+		if snippet, ok := highlighter.Snippet(doc.Description, snippetWidth); ok {
+			hit.Snippet = highlightHTML(snippet)
+		} else {
+			var tags []string
+			for _, tag := range doc.Tags {
+				if snippet, ok := highlighter.Snippet(tag, snippetWidth); ok {
+					tags = append(tags, string(highlightHTML(snippet)))
+				}
+			}
+			hit.Snippet = template.HTML(strings.Join(tags, " · "))
+		}
+		hits[card.ID] = hit
+	}
+	return hits
+}
+
+func highlightHTML(fragments []search.Fragment) template.HTML {
+	var sb strings.Builder
+	for _, fragment := range fragments {
+		if fragment.Match {
+			sb.WriteString("<mark>" + html.EscapeString(fragment.Text) + "</mark>")
+		} else {
+			sb.WriteString(html.EscapeString(fragment.Text))
+		}
+	}
+	return template.HTML(sb.String())
+}
+
+// intersect keeps the ids that are also in other
+func intersect(ids, other []string) []string {
+	keep := make(map[string]bool, len(other))
+	for _, id := range other {
+		keep[id] = true
+	}
+	var result []string
+	for _, id := range ids {
+		if keep[id] {
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -2,17 +2,24 @@ package web
 
 import (
 	"embed"
-	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/Magnetkopf/pGallery/internal/filter"
+	"github.com/Magnetkopf/pGallery/internal/index"
+	"github.com/Magnetkopf/pGallery/internal/layout"
 	"github.com/Magnetkopf/pGallery/internal/model"
+	"github.com/Magnetkopf/pGallery/internal/search"
 	"gopkg.in/yaml.v3"
 )
 
@@ -22,46 +29,98 @@ var templateFS embed.FS
 type ServerArgs struct {
 	Base string
 	Port int
+	// Index is the backend to read, see index.Backends; empty picks the newer
+	Index string
 }
 
 type WebContext struct {
-	Store *model.Store
-	Base  string
+	Base string
+
+	// Status reports the scheduler state for /status; nil unless running under serve
+	Status func() DaemonStatus
+	// RunNow asks the scheduler to start a run right away; nil unless running under serve
+	RunNow func()
+
+	index atomic.Pointer[index.Index]
+	// serialises writes to dupes.json from the review page
+	decisionsMu sync.Mutex
+	// coalesces concurrent renders of the same /img variant
+	images imageFlight
+	// the full-text index, loaded on the first search
+	search searchCache
+	// the clusters last shown on the duplicates page
+	dupes dupesCache
+}
+
+// DaemonStatus describes the scheduled sync/build loop of serve
+type DaemonStatus struct {
+	Interval   time.Duration
+	Running    bool
+	Phase      string
+	Progress   string
+	LastStart  time.Time
+	LastFinish time.Time
+	LastError  string
+	NextRun    time.Time
+	Runs       int
+}
+
+// Index returns the index to answer a request from
+func (ctx *WebContext) Index() index.Index {
+	return *ctx.index.Load()
+}
+
+// SetIndex swaps in a new index and returns the previous one, nil if none;
+// requests already running keep the old one, so close it only after they end
+func (ctx *WebContext) SetIndex(idx index.Index) index.Index {
+	old := ctx.index.Swap(&idx)
+	if old == nil {
+		return nil
+	}
+	return *old
+}
+
+func (ctx *WebContext) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", ctx.handleHome)
+	mux.HandleFunc("/artist", ctx.handleArtistList)
+	mux.HandleFunc("/artists/", ctx.handleArtistProfile)
+	mux.HandleFunc("/tag", ctx.handleTagList)
+	mux.HandleFunc("/bookmarked_by", ctx.handleBookmarkerList)
+	mux.HandleFunc("/artwork", ctx.handleArtwork)
+	mux.HandleFunc("/lost", ctx.handleLost)
+	mux.HandleFunc("/dupes", ctx.handleDupes)
+	mux.HandleFunc("/find", ctx.handleFind)
+	mux.HandleFunc("/img", ctx.handleImage)
+	mux.HandleFunc("/status", ctx.handleStatus)
+	mux.HandleFunc("/static/", ctx.handleStatic)
+
+	// POSTs start a sync or record duplicate decisions; refuse them from
+	// other sites open in the same browser
+	return http.NewCrossOriginProtection().Handler(mux)
 }
 
 func Start(args ServerArgs) {
 	fmt.Printf("Starting Web UI on port %d with base %s\n", args.Port, args.Base)
 
-	// Load index.json
-	indexPath := filepath.Join(args.Base, "index.json")
-	fileContent, err := os.ReadFile(indexPath)
-	if err != nil {
-		log.Fatalf("Failed to read index.json: %v", err)
+	backend := args.Index
+	if backend == "" {
+		backend = index.Detect(args.Base)
 	}
-
-	var store model.Store
-	if err := json.Unmarshal(fileContent, &store); err != nil {
-		log.Fatalf("Failed to parse index.json: %v", err)
+	idx, err := index.Open(args.Base, backend)
+	if err != nil {
+		log.Fatal(err)
 	}
+	defer idx.Close()
 
 	ctx := &WebContext{
-		Store: &store,
-		Base:  args.Base,
+		Base: args.Base,
 	}
-
-	// Handlers
-	http.HandleFunc("/", ctx.handleHome)
-	http.HandleFunc("/artist", ctx.handleArtistList)
-	http.HandleFunc("/artists/", ctx.handleArtistProfile)
-	http.HandleFunc("/tag", ctx.handleTagList)
-	http.HandleFunc("/artwork", ctx.handleArtwork)
-
-	fs := http.FileServer(http.Dir(args.Base))
-	http.Handle("/static/", http.StripPrefix("/static/", fs))
+	ctx.SetIndex(idx)
 
 	addr := fmt.Sprintf(":%d", args.Port)
 	log.Printf("Listening on http://localhost%s", addr)
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	if err := http.ListenAndServe(addr, ctx.Handler()); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -78,9 +137,13 @@ type Pagination struct {
 }
 
 type HomeView struct {
-	Artworks   []*model.ArtworkCard
+	Artworks []*model.ArtworkCard
+	// highlighted matches by artwork ID, when searching
+	Hits       map[string]SearchHit
 	Filter     string
 	Query      template.URL
+	Values     url.Values // the request's query, to fill in the filter form
+	Sorts      []SortMode
 	Pagination Pagination
 }
 
@@ -101,6 +164,7 @@ type ArtworkDetailView struct {
 	Images       []string
 	ArtistLink   string
 	ArtistAvatar string
+	Colors       []model.Swatch
 }
 
 type ArtistProfileView struct {
@@ -108,14 +172,15 @@ type ArtistProfileView struct {
 	Artist   *model.ArtistDetail
 	Avatar   string
 	Artworks []*model.ArtworkCard
+	Values   url.Values
+	Sorts    []SortMode
 }
 
 // Handlers Implementation
 
 func (ctx *WebContext) handleHome(w http.ResponseWriter, r *http.Request) {
+	idx := ctx.Index()
 	query := r.URL.Query()
-	artistID := query.Get("artist")
-	tagName := query.Get("tag")
 	pageStr := query.Get("page")
 	limitStr := query.Get("limit")
 
@@ -135,56 +200,106 @@ func (ctx *WebContext) handleHome(w http.ResponseWriter, r *http.Request) {
 		limit = 20
 	}
 
-	// Filter
-	var filtered []*model.ArtworkCard
+	indexQuery, err := parseIndexQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dimensions, err := parseDimensionFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	color, err := parseColorFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sortBy, err := parseSort(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if seeded, ok := sortBy.withSeed(query); ok {
+		http.Redirect(w, r, r.URL.Path+"?"+seeded.Encode(), http.StatusFound)
+		return
+	}
+
+	// Filter: the index narrows down by its own fields first
+	ids, err := idx.Find(indexQuery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	// If no filters, start with all
-	if artistID == "" && tagName == "" {
-		filtered = make([]*model.ArtworkCard, 0, len(ctx.Store.ArtworkIndex))
-		for _, artwork := range ctx.Store.ArtworkIndex {
-			filtered = append(filtered, artwork)
+	// q is the query language of package filter; unless sorted otherwise its
+	// words rank the results, best match first
+	var text string
+	var searchIdx *search.Index
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		expr, err := filter.Parse(q)
+		if err != nil {
+			http.Error(w, "Invalid search: "+err.Error(), http.StatusBadRequest)
+			return
 		}
-	} else {
-		// If artist filter is present
-		var artistArtworks []*model.ArtworkCard
-		if artistID != "" {
-			if detail, ok := ctx.Store.ArtistIndex[artistID]; ok {
-				artistArtworks = detail.Artworks
+		if text = filter.Text(expr); filter.NeedsText(expr) {
+			if searchIdx, err = ctx.searchIndex(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 		}
-
-		// If tag filter is present
-		var tagArtworks []*model.ArtworkCard
-		if tagName != "" {
-			tagArtworks = ctx.Store.TagIndex[tagName]
+		matching, err := filter.Evaluate(expr, idx, searchIdx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		ids = intersect(ids, matching)
+	}
+	ranked := text != "" && !sortBy.Explicit
+	if ranked {
+		ids = searchIdx.Rank(text, ids)
+	}
 
-		// Combine filters
-		if artistID != "" && tagName != "" {
-
-			tagMap := make(map[string]bool)
-			for _, art := range tagArtworks {
-				tagMap[art.ID] = true
+	// dimension and colour filters and most sort modes look at the cards, so
+	// those need all of them; otherwise only the cards of the shown page are read
+	cardFilters := dimensions.Active() || color.Active() || sortBy.NeedsCards()
+	var filtered []*model.ArtworkCard
+	totalItems := len(ids)
+	if cardFilters {
+		for _, art := range idx.Artworks(ids) {
+			if !dimensions.Active() || dimensions.Match(art) {
+				filtered = append(filtered, art)
 			}
-			for _, art := range artistArtworks {
-				if tagMap[art.ID] {
-					filtered = append(filtered, art)
+		}
+
+		// closest colour first, unless ranked by the search or sorted
+		scores := make(map[string]float64)
+		if color.Active() {
+			var matching []*model.ArtworkCard
+			for _, art := range filtered {
+				if score, ok := color.Score(art); ok {
+					scores[art.ID] = score
+					matching = append(matching, art)
 				}
 			}
-		} else if artistID != "" {
-			filtered = artistArtworks
-		} else if tagName != "" {
-			filtered = tagArtworks
+			filtered = matching
+		}
+		switch {
+		case ranked:
+		case color.Active() && !sortBy.Explicit:
+			sortBy.SortCards(filtered)
+			sort.SliceStable(filtered, func(i, j int) bool {
+				return scores[filtered[i].ID] < scores[filtered[j].ID]
+			})
+		default:
+			sortBy.SortCards(filtered)
 		}
+		totalItems = len(filtered)
+	} else if !ranked {
+		sortBy.SortIDs(ids)
 	}
 
-	// Sort (descending by ID)
-	sort.Slice(filtered, func(i, j int) bool {
-		return filtered[i].ID > filtered[j].ID
-	})
-
 	// Pagination
-	totalItems := len(filtered)
 	totalPages := (totalItems + limit - 1) / limit
 	if page > totalPages && totalPages > 0 {
 		page = totalPages
@@ -199,17 +314,45 @@ func (ctx *WebContext) handleHome(w http.ResponseWriter, r *http.Request) {
 		end = totalItems
 	}
 
-	pagedArtworks := filtered[start:end]
+	var pagedArtworks []*model.ArtworkCard
+	if cardFilters {
+		pagedArtworks = filtered[start:end]
+	} else {
+		pagedArtworks = idx.Artworks(ids[start:end])
+	}
 
 	var filterInfo []string
-	if artistID != "" {
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		filterInfo = append(filterInfo, "Search: "+q)
+	}
+	if artistID := indexQuery.Artist; artistID != "" {
 		filterInfo = append(filterInfo, "Artist: "+artistID)
-		if detail, ok := ctx.Store.ArtistIndex[artistID]; ok && detail.Name != "" {
+		if detail, ok := idx.Artist(artistID); ok && detail.Name != "" {
 			filterInfo[len(filterInfo)-1] = "Artist: " + detail.Name
 		}
 	}
-	if tagName != "" {
-		filterInfo = append(filterInfo, "Tag: "+tagName)
+	if indexQuery.Tag != "" {
+		filterInfo = append(filterInfo, "Tag: "+indexQuery.Tag)
+	}
+	if indexQuery.BookmarkedBy != "" {
+		filterInfo = append(filterInfo, "Bookmarked by: "+indexQuery.BookmarkedBy)
+	}
+	if from, to := query.Get("date_from"), query.Get("date_to"); from != "" || to != "" {
+		filterInfo = append(filterInfo, dateFilterInfo(from, to))
+	}
+	if indexQuery.MinPages > 0 || indexQuery.MaxPages > 0 {
+		filterInfo = append(filterInfo, pagesFilterInfo(indexQuery.MinPages, indexQuery.MaxPages))
+	}
+	if dimensions.Active() {
+		filterInfo = append(filterInfo, dimensions.String())
+	}
+	if color.Active() {
+		filterInfo = append(filterInfo, color.String())
+	}
+
+	var hits map[string]SearchHit
+	if text != "" {
+		hits = searchHits(searchIdx, text, pagedArtworks)
 	}
 
 	// Reconstruct query for pagination links (excluding page and limit)
@@ -221,8 +364,11 @@ func (ctx *WebContext) handleHome(w http.ResponseWriter, r *http.Request) {
 
 	view := HomeView{
 		Artworks: pagedArtworks,
+		Hits:     hits,
 		Filter:   strings.Join(filterInfo, ", "),
 		Query:    template.URL(rawQuery),
+		Values:   query,
+		Sorts:    SortModes,
 		Pagination: Pagination{
 			CurrentPage: page,
 			TotalPages:  totalPages,
@@ -248,53 +394,36 @@ func (ctx *WebContext) handleHome(w http.ResponseWriter, r *http.Request) {
 }
 
 func (ctx *WebContext) handleArtistList(w http.ResponseWriter, r *http.Request) {
-	var items []ListItem
-	for artistID, detail := range ctx.Store.ArtistIndex {
-		label := detail.Name
-		if label == "" {
-			label = artistID
-		}
-		items = append(items, ListItem{
-			Label: label,
-			Value: artistID,
-			Count: len(detail.Artworks),
-		})
-	}
-
-	sort.Slice(items, func(i, j int) bool {
-		return items[i].Count > items[j].Count // Sort by count desc
-	})
-
-	view := ListView{
+	renderList(w, ListView{
 		Title: "Artists",
 		Type:  "artist",
-		Items: items,
-	}
-
-	renderList(w, view)
+		Items: listItems(ctx.Index().Artists()),
+	})
 }
 
 func (ctx *WebContext) handleTagList(w http.ResponseWriter, r *http.Request) {
-	var items []ListItem
-	for tag, artworks := range ctx.Store.TagIndex {
-		items = append(items, ListItem{
-			Label: tag,
-			Value: tag,
-			Count: len(artworks),
-		})
-	}
+	renderList(w, ListView{
+		Title: "Tags",
+		Type:  "tag",
+		Items: listItems(ctx.Index().Tags()),
+	})
+}
 
-	sort.Slice(items, func(i, j int) bool {
-		return items[i].Count > items[j].Count
+func (ctx *WebContext) handleBookmarkerList(w http.ResponseWriter, r *http.Request) {
+	renderList(w, ListView{
+		Title: "Bookmarked by",
+		Type:  "bookmarked_by",
+		Items: listItems(ctx.Index().Bookmarkers()),
 	})
+}
 
-	view := ListView{
-		Title: "Tags",
-		Type:  "tag",
-		Items: items,
+// listItems turns index entries, most artworks first, into list rows
+func listItems(entries []index.Entry) []ListItem {
+	items := make([]ListItem, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, ListItem{Label: entry.Label, Value: entry.Value, Count: entry.Count})
 	}
-
-	renderList(w, view)
+	return items
 }
 
 func renderList(w http.ResponseWriter, view ListView) {
@@ -309,8 +438,18 @@ func renderList(w http.ResponseWriter, view ListView) {
 	}
 }
 
-func (ctx *WebContext) findArtistAvatar(artistID string) string {
-	files, err := os.ReadDir(filepath.Join(ctx.Base, artistID))
+// artistDetail is idx.Artist without the ok, nil when unknown
+func artistDetail(idx index.Index, id string) *model.ArtistDetail {
+	detail, _ := idx.Artist(id)
+	return detail
+}
+
+// findArtistAvatar returns the artist's folder.* relative to base, if any
+func (ctx *WebContext) findArtistAvatar(artist *model.ArtistDetail) string {
+	if artist == nil || artist.Path == "" {
+		return ""
+	}
+	files, err := os.ReadDir(filepath.Join(ctx.Base, filepath.FromSlash(artist.Path)))
 	if err != nil {
 		return ""
 	}
@@ -320,7 +459,7 @@ func (ctx *WebContext) findArtistAvatar(artistID string) string {
 			continue
 		}
 		if strings.HasPrefix(file.Name(), "folder.") {
-			return filepath.Join(artistID, file.Name())
+			return artist.Path + "/" + file.Name()
 		}
 	}
 
@@ -328,28 +467,45 @@ func (ctx *WebContext) findArtistAvatar(artistID string) string {
 }
 
 func (ctx *WebContext) handleArtistProfile(w http.ResponseWriter, r *http.Request) {
+	idx := ctx.Index()
 	artistID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/artists/"), "/")
 	if artistID == "" || strings.Contains(artistID, "/") {
 		http.NotFound(w, r)
 		return
 	}
 
-	detail, ok := ctx.Store.ArtistIndex[artistID]
+	detail, ok := idx.Artist(artistID)
 	if !ok {
 		http.Error(w, "Artist not found", http.StatusNotFound)
 		return
 	}
 
-	artworks := append([]*model.ArtworkCard(nil), detail.Artworks...)
-	sort.Slice(artworks, func(i, j int) bool {
-		return artworks[i].ID > artworks[j].ID
-	})
+	query := r.URL.Query()
+	sortBy, err := parseSort(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if seeded, ok := sortBy.withSeed(query); ok {
+		http.Redirect(w, r, r.URL.Path+"?"+seeded.Encode(), http.StatusFound)
+		return
+	}
+
+	ids, err := idx.Find(index.Query{Artist: artistID})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	artworks := idx.Artworks(ids)
+	sortBy.SortCards(artworks)
 
 	view := ArtistProfileView{
 		ArtistID: artistID,
 		Artist:   detail,
-		Avatar:   ctx.findArtistAvatar(artistID),
+		Avatar:   ctx.findArtistAvatar(detail),
 		Artworks: artworks,
+		Values:   query,
+		Sorts:    SortModes,
 	}
 
 	tmpl, err := template.ParseFS(templateFS, "templates/layout.html", "templates/artist_profile.html")
@@ -364,21 +520,82 @@ func (ctx *WebContext) handleArtistProfile(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+type LostView struct {
+	Artworks []*model.LostArtwork
+}
+
+// handleLost lists bookmarked works pixiv no longer serves, newest first
+func (ctx *WebContext) handleLost(w http.ResponseWriter, r *http.Request) {
+	artworks := ctx.Index().Lost()
+	sort.Slice(artworks, func(i, j int) bool {
+		return artworks[i].FirstSeen.After(artworks[j].FirstSeen)
+	})
+
+	tmpl, err := template.ParseFS(templateFS, "templates/layout.html", "templates/lost.html")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	err = tmpl.Execute(w, LostView{Artworks: artworks})
+	if err != nil {
+		log.Printf("Error executing template: %v", err)
+	}
+}
+
+type StatusView struct {
+	Enabled bool
+	Status  DaemonStatus
+	Indexed time.Time
+	Total   int
+}
+
+func (ctx *WebContext) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if ctx.RunNow != nil {
+			ctx.RunNow()
+		}
+		http.Redirect(w, r, "/status", http.StatusSeeOther)
+		return
+	}
+
+	info := ctx.Index().Info()
+	view := StatusView{
+		Enabled: ctx.Status != nil,
+		Indexed: info.LastIndexed,
+		Total:   info.Total,
+	}
+	if ctx.Status != nil {
+		view.Status = ctx.Status()
+	}
+
+	tmpl, err := template.ParseFS(templateFS, "templates/layout.html", "templates/status.html")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	err = tmpl.Execute(w, view)
+	if err != nil {
+		log.Printf("Error executing template: %v", err)
+	}
+}
+
 func (ctx *WebContext) handleArtwork(w http.ResponseWriter, r *http.Request) {
+	idx := ctx.Index()
 	id := r.URL.Query().Get("id")
 	if id == "" {
 		http.Error(w, "Missing id", http.StatusBadRequest)
 		return
 	}
 
-	card, ok := ctx.Store.ArtworkIndex[id]
+	card, ok := idx.Artwork(id)
 	if !ok {
 		http.Error(w, "Artwork not found", http.StatusNotFound)
 		return
 	}
 
-	artistPath := filepath.Join(ctx.Base, card.ArtistID)
-	artworkPath := filepath.Join(artistPath, card.ID)
+	artworkPath := filepath.Join(ctx.Base, filepath.FromSlash(card.Path))
 	artworkYamlPath := filepath.Join(artworkPath, "artwork.yaml")
 
 	yamlBytes, err := os.ReadFile(artworkYamlPath)
@@ -401,12 +618,12 @@ func (ctx *WebContext) handleArtwork(w http.ResponseWriter, r *http.Request) {
 	} else {
 
 		for i := 0; i < artworkData.PageCount; i++ {
-			prefix := fmt.Sprintf("p%d.", i)
+			prefix := layout.PageFile(i) + "."
 			found := false
 			for _, file := range files {
 				if strings.HasPrefix(file.Name(), prefix) {
-					// Relative path for static file server: ArtistID/ArtworkID/Filename
-					relPath := filepath.Join(card.ArtistID, card.ID, file.Name())
+					// Relative path for static file server: <artwork dir>/Filename
+					relPath := card.Path + "/" + file.Name()
 					images = append(images, relPath)
 					found = true
 					break
@@ -422,7 +639,8 @@ func (ctx *WebContext) handleArtwork(w http.ResponseWriter, r *http.Request) {
 		Artwork:      artworkData,
 		Images:       images,
 		ArtistLink:   "/artists/" + card.ArtistID,
-		ArtistAvatar: ctx.findArtistAvatar(card.ArtistID),
+		ArtistAvatar: ctx.findArtistAvatar(artistDetail(idx, card.ArtistID)),
+		Colors:       card.Colors,
 	}
 
 	tmpl, err := template.ParseFS(templateFS, "templates/layout.html", "templates/artwork.html")
@@ -0,0 +1,40 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCrossOriginPost(t *testing.T) {
+	runs := 0
+	ctx := &WebContext{Base: t.TempDir(), RunNow: func() { runs++ }}
+	handler := ctx.Handler()
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		code    int
+	}{
+		{"same origin", map[string]string{"Origin": "http://example.com", "Sec-Fetch-Site": "same-origin"}, http.StatusSeeOther},
+		{"same host, no fetch metadata", map[string]string{"Origin": "http://example.com"}, http.StatusSeeOther},
+		{"not a browser", nil, http.StatusSeeOther},
+		{"other site", map[string]string{"Origin": "http://evil.test", "Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"other origin, no fetch metadata", map[string]string{"Origin": "http://evil.test"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "http://example.com/status", nil)
+		for key, value := range tt.headers {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		before := runs
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.code {
+			t.Errorf("%s: POST /status = %d, want %d", tt.name, rec.Code, tt.code)
+		}
+		if started := runs > before; started != (tt.code == http.StatusSeeOther) {
+			t.Errorf("%s: sync started = %v", tt.name, started)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package web
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/Magnetkopf/pGallery/internal/index"
+	"github.com/Magnetkopf/pGallery/internal/model"
+)
+
+// SortMode is a way to order artworks; key is nil for the modes that only
+// need the artwork ID
+type SortMode struct {
+	Name  string
+	Label string
+	key   func(card *model.ArtworkCard) int64
+}
+
+// SortModes lists the modes in the order the sort menus show them. Every mode
+// puts the highest value first unless order=asc; ties go by ID.
+var SortModes = []SortMode{
+	{Name: "id", Label: "ID"},
+	{Name: "date", Label: "Creation date", key: func(card *model.ArtworkCard) int64 {
+		created, err := index.ParseCreateDate(card.CreateDate)
+		if err != nil {
+			return 0
+		}
+		return created.Unix()
+	}},
+	{Name: "bookmarked", Label: "Bookmarked", key: func(card *model.ArtworkCard) int64 {
+		return card.BookmarkID
+	}},
+	{Name: "pages", Label: "Page count", key: func(card *model.ArtworkCard) int64 {
+		return int64(card.PageCount)
+	}},
+	{Name: "size", Label: "File size", key: func(card *model.ArtworkCard) int64 {
+		return card.Size()
+	}},
+	{Name: "bookmarks", Label: "Bookmarks on pixiv", key: func(card *model.ArtworkCard) int64 {
+		return int64(card.BookmarkCount)
+	}},
+	{Name: "likes", Label: "Likes on pixiv", key: func(card *model.ArtworkCard) int64 {
+		return int64(card.LikeCount)
+	}},
+	{Name: "views", Label: "Views on pixiv", key: func(card *model.ArtworkCard) int64 {
+		return int64(card.ViewCount)
+	}},
+	{Name: "random", Label: "Random"},
+}
+
+// Sort is the order a gallery view was asked for
+type Sort struct {
+	Mode SortMode
+	// Explicit is false when the request has no sort, so the view may keep
+	// an order of its own, like search relevance
+	Explicit bool
+	Asc      bool
+	// Seed makes the random order stable from page to page
+	Seed uint64
+}
+
+// parseSort reads sort (a SortModes name, id by default), order (asc or
+// desc) and seed, which sort=random requires
+func parseSort(query url.Values) (Sort, error) {
+	s := Sort{Mode: SortModes[0]}
+	if name := query.Get("sort"); name != "" {
+		found := false
+		for _, mode := range SortModes {
+			if mode.Name == name {
+				s.Mode, s.Explicit, found = mode, true, true
+			}
+		}
+		if !found {
+			return s, fmt.Errorf("unknown sort %q", name)
+		}
+	}
+
+	switch order := query.Get("order"); order {
+	case "", "desc":
+	case "asc":
+		s.Asc = true
+	default:
+		return s, fmt.Errorf("unknown order %q, expected asc or desc", order)
+	}
+
+	if value := query.Get("seed"); value != "" {
+		seed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return s, fmt.Errorf("invalid seed %q", value)
+		}
+		s.Seed = seed
+	}
+	return s, nil
+}
+
+// withSeed adds a new seed to query when the random order lacks one; ok is
+// false when nothing needed to change. Redirecting to the seeded URL keeps
+// the order when paging or reloading.
+func (s Sort) withSeed(query url.Values) (seeded url.Values, ok bool) {
+	if s.Mode.Name != "random" || query.Get("seed") != "" {
+		return query, false
+	}
+	seeded = url.Values{}
+	for key, values := range query {
+		seeded[key] = values
+	}
+	seeded.Set("seed", strconv.FormatUint(rand.Uint64N(1_000_000_000), 10))
+	return seeded, true
+}
+
+// NeedsCards reports whether sorting reads the artwork cards, not just IDs
+func (s Sort) NeedsCards() bool {
+	return s.Mode.key != nil
+}
+
+// SortIDs orders artwork IDs; only for modes that don't need the cards
+func (s Sort) SortIDs(ids []string) {
+	keys := make(map[string]uint64, len(ids))
+	for _, id := range ids {
+		keys[id] = s.idKey(id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return s.less(0, keys[ids[i]], 0, keys[ids[j]])
+	})
+}
+
+// SortCards orders artwork cards; it works for every mode
+func (s Sort) SortCards(cards []*model.ArtworkCard) {
+	keys := make(map[*model.ArtworkCard]int64, len(cards))
+	ids := make(map[*model.ArtworkCard]uint64, len(cards))
+	for _, card := range cards {
+		if s.Mode.key != nil {
+			keys[card] = s.Mode.key(card)
+		}
+		ids[card] = s.idKey(card.ID)
+	}
+	sort.Slice(cards, func(i, j int) bool {
+		return s.less(keys[cards[i]], ids[cards[i]], keys[cards[j]], ids[cards[j]])
+	})
+}
+
+// idKey is the numeric ID, or its hash with the seed for the random order
+func (s Sort) idKey(id string) uint64 {
+	if s.Mode.Name == "random" {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%d:%s", s.Seed, id)
+		return h.Sum64()
+	}
+	n, _ := strconv.ParseUint(id, 10, 64)
+	return n
+}
+
+// less puts the higher key first, or the lower with Asc, then the higher ID
+func (s Sort) less(keyA int64, idA uint64, keyB int64, idB uint64) bool {
+	if keyA != keyB {
+		return (keyA > keyB) != s.Asc
+	}
+	return (idA > idB) != s.Asc
+}
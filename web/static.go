@@ -0,0 +1,52 @@
+package web
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Magnetkopf/pGallery/internal/thumbs"
+)
+
+// imageExts are the formats of pages, folder.* covers and thumbnails
+var imageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true}
+
+// thumbnailDirs are the dot directories whose images the pages link to
+var thumbnailDirs = []string{thumbs.Dir + "/", ".tombstones/"}
+
+// servable reports whether src, a clean slash path relative to base, is an
+// image the web UI may hand out. Everything else in base stays private:
+// pgallery.yaml, cookie files, the indexes, the lock and the caches.
+func servable(src string) bool {
+	if !imageExts[strings.ToLower(path.Ext(src))] {
+		return false
+	}
+	for _, dir := range thumbnailDirs {
+		if strings.HasPrefix(src, dir) {
+			return true
+		}
+	}
+	for _, segment := range strings.Split(src, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// handleStatic serves the artwork pages under /static/
+func (ctx *WebContext) handleStatic(w http.ResponseWriter, r *http.Request) {
+	src := path.Clean("/" + strings.TrimPrefix(r.URL.Path, "/static/"))[1:]
+	if !servable(src) {
+		http.NotFound(w, r)
+		return
+	}
+	filePath := filepath.Join(ctx.Base, filepath.FromSlash(src))
+	if info, err := os.Stat(filePath); err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, filePath)
+}
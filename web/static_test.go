@@ -0,0 +1,79 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServable(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"10/123/p0.jpg", true},
+		{"10/123/p1.PNG", true},
+		{"10/folder.webp", true},
+		{".cache/thumbs/123/grid.jpg", true},
+		{".tombstones/123.png", true},
+		{"pgallery.yaml", false},
+		{"cookie.txt", false},
+		{"index.json", false},
+		{"10/123/artwork.yaml", false},
+		{".pgallery.lock", false},
+		{".cache/build.json", false},
+		{".cache/img/ab/abcd.jpg", false},
+		{".relayout.json", false},
+		{".hidden/p0.jpg", false},
+		{"10/.git/p0.jpg", false},
+		{"10/123/p0.jpg.tmp", false},
+		{"10/123", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := servable(tt.src); got != tt.want {
+			t.Errorf("servable(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestHandleStatic(t *testing.T) {
+	base := t.TempDir()
+	files := []string{"pgallery.yaml", "cookie.txt", ".pgallery.lock", "10/123/p0.jpg", "10/123/artwork.yaml", ".cache/build.json"}
+	for _, name := range files {
+		path := filepath.Join(base, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := (&WebContext{Base: base}).Handler()
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/static/10/123/p0.jpg", http.StatusOK},
+		{"/static/pgallery.yaml", http.StatusNotFound},
+		{"/static/cookie.txt", http.StatusNotFound},
+		{"/static/.pgallery.lock", http.StatusNotFound},
+		{"/static/10/123/artwork.yaml", http.StatusNotFound},
+		{"/static/.cache/build.json", http.StatusNotFound},
+		{"/static/10/123/", http.StatusNotFound},
+		{"/static/", http.StatusNotFound},
+		{"/static/10/123/p9.jpg", http.StatusNotFound},
+		{"/img?src=cookie.txt&w=192&h=192", http.StatusBadRequest},
+		{"/img?src=10/123/p0.jpg&w=1&h=1", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Code != tt.code {
+			t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.code)
+		}
+	}
+}